@@ -0,0 +1,284 @@
+package phi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/memberlist"
+
+	"github.com/hexablock/blox"
+	"github.com/hexablock/hexalog"
+	"github.com/hexablock/hexatype"
+	"github.com/hexablock/vivaldi"
+)
+
+var errAdminWritesDisabled = errors.New("admin writes disabled")
+
+// adminServer implements the PhiAdminRPCServer interface used by phictl to
+// inspect and operate a node
+type adminServer struct {
+	phi *Phi
+}
+
+// MembersRPC returns the gossip members along with the local coordinate and
+// lamport time
+func (server *adminServer) MembersRPC(ctx context.Context, req *MembersRequest) (*MembersResponse, error) {
+	phi := server.phi
+	members := phi.memberlist.Members()
+
+	resp := &MembersResponse{
+		Members:     make([]*NodeInfo, 0, len(members)),
+		LamportTime: uint64(phi.ltime.Time()),
+		Coordinate:  coordinateInfo(phi.coord.GetCoordinate()),
+	}
+
+	for _, m := range members {
+		info := &NodeInfo{
+			Name:  m.Name,
+			Addr:  m.Address(),
+			State: memberState(m),
+		}
+
+		var node hexatype.Node
+		if err := proto.Unmarshal(m.Meta, &node); err == nil {
+			info.ID = node.ID
+			info.Host = node.Host()
+			info.Meta = node.Metadata()
+			info.Coordinate = coordinateInfo(node.Coordinates)
+			if phi.dlg.IsDead(node.ID) {
+				info.State = "dead"
+			}
+		}
+
+		resp.Members = append(resp.Members, info)
+	}
+
+	return resp, nil
+}
+
+// DHTLookupRPC returns the dht nodes holding the key
+func (server *adminServer) DHTLookupRPC(ctx context.Context, req *KeyRequest) (*NodesResponse, error) {
+	nodes, err := server.phi.dht.Lookup(req.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &NodesResponse{Nodes: make([]*NodeInfo, 0, len(nodes))}
+	for _, n := range nodes {
+		resp.Nodes = append(resp.Nodes, nodeInfo(n))
+	}
+	return resp, nil
+}
+
+// JuryRPC returns the participants for the key as selected by the configured
+// jury
+func (server *adminServer) JuryRPC(ctx context.Context, req *KeyRequest) (*ParticipantsResponse, error) {
+	conf := server.phi.conf
+	peers, err := conf.Jury.Participants(req.Key, conf.Hexalog.Votes)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &ParticipantsResponse{Participants: make([]*ParticipantInfo, 0, len(peers))}
+	for _, p := range peers {
+		resp.Participants = append(resp.Participants, &ParticipantInfo{
+			ID:       p.ID,
+			Host:     p.Host,
+			Priority: p.Priority,
+			Index:    p.Index,
+		})
+	}
+	return resp, nil
+}
+
+// WALGetRPC returns the entry with the requested id or the latest entry for
+// the key if no id is given
+func (server *adminServer) WALGetRPC(ctx context.Context, req *KeyRequest) (*EntryInfo, error) {
+	var (
+		entry *hexalog.Entry
+		err   error
+	)
+
+	if len(req.ID) > 0 {
+		entry, err = server.phi.wal.GetEntryContext(ctx, req.Key, req.ID)
+	} else {
+		entry, err = server.phi.wal.GetLatestContext(ctx, req.Key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return server.entryInfo(entry), nil
+}
+
+// WALHistoryRPC streams the verified entries for the key in height order
+func (server *adminServer) WALHistoryRPC(req *HistoryRequest, stream PhiAdminRPC_WALHistoryRPCServer) error {
+	iter, err := server.phi.wal.HistoryContext(stream.Context(), req.Key, req.From, req.To)
+	if err != nil {
+		return err
+	}
+
+	for iter.Next() {
+		if err = stream.Send(server.entryInfo(iter.Entry())); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+// BlockGetRPC returns the contents of a block or of an index if requested
+func (server *adminServer) BlockGetRPC(ctx context.Context, req *BlockRequest) (*BlockResponse, error) {
+	if req.Index {
+		buf := new(bytes.Buffer)
+		blx := blox.NewBlox(server.phi.dev)
+		if err := blx.ReadIndex(req.ID, buf, 1); err != nil {
+			return nil, err
+		}
+		return &BlockResponse{ID: req.ID, Type: "index", Size: uint64(buf.Len()), Data: buf.Bytes()}, nil
+	}
+
+	blk, err := server.phi.dev.GetBlock(req.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	rd, err := blk.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	data, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BlockResponse{ID: blk.ID(), Type: blk.Type().String(), Size: blk.Size(), Data: data}, nil
+}
+
+// BlockPutRPC writes the data to the block device returning the index id.  It
+// requires Config.AdminWrites
+func (server *adminServer) BlockPutRPC(ctx context.Context, req *BlockRequest) (*BlockResponse, error) {
+	if !server.phi.conf.AdminWrites {
+		return nil, errAdminWritesDisabled
+	}
+
+	blx := blox.NewBlox(server.phi.dev)
+	idx, err := blx.WriteIndex(bytes.NewReader(req.Data), 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BlockResponse{ID: idx.ID(), Type: "index", Size: uint64(len(req.Data))}, nil
+}
+
+// BlockStatRPC returns the type, size and locations of a block without its data
+func (server *adminServer) BlockStatRPC(ctx context.Context, req *BlockRequest) (*BlockResponse, error) {
+	blk, err := server.phi.dev.GetBlock(req.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	locs, err := server.phi.dht.Lookup(req.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &BlockResponse{
+		ID:        blk.ID(),
+		Type:      blk.Type().String(),
+		Size:      blk.Size(),
+		Locations: make([]string, 0, len(locs)),
+	}
+	for _, loc := range locs {
+		resp.Locations = append(resp.Locations, loc.Host())
+	}
+	return resp, nil
+}
+
+// RepairRPC heals the local keys with the requested prefix.  It requires
+// Config.AdminWrites
+func (server *adminServer) RepairRPC(ctx context.Context, req *RepairRequest) (*RepairResponse, error) {
+	if !server.phi.conf.AdminWrites {
+		return nil, errAdminWritesDisabled
+	}
+
+	n, failed, err := server.phi.Repair(req.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	return &RepairResponse{Keys: uint32(n), Failed: uint32(failed)}, nil
+}
+
+func (server *adminServer) entryInfo(entry *hexalog.Entry) *EntryInfo {
+	return &EntryInfo{
+		ID:        entry.Hash(server.phi.conf.HashFunc()),
+		Key:       entry.Key,
+		Previous:  entry.Previous,
+		Height:    entry.Height,
+		Timestamp: entry.Timestamp,
+		Data:      EntryData(entry),
+	}
+}
+
+// Repair heals all keys in the local log with the given prefix against their
+// current jury.  It returns the number of keys repaired and the number of keys
+// that failed
+func (phi *Phi) Repair(prefix []byte) (int, int, error) {
+	keys, err := phi.lidx.keys(prefix)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var failed int
+	for _, key := range keys {
+		peers, er := phi.conf.Jury.Participants(key, phi.conf.Hexalog.Votes)
+		if er == nil {
+			opts := hexalog.DefaultRequestOptions()
+			opts.PeerSet = peers
+			er = phi.hexalog.Heal(key, opts)
+		}
+
+		if er != nil {
+			phi.logger.Error("Repair failed", "key", key, "err", er)
+			failed++
+		}
+	}
+
+	return len(keys) - failed, failed, nil
+}
+
+// memberState returns the gossip state of the member
+func memberState(m *memberlist.Node) string {
+	switch m.State {
+	case memberlist.StateAlive:
+		return "alive"
+	case memberlist.StateSuspect:
+		return "suspect"
+	}
+	return "dead"
+}
+
+func nodeInfo(n *hexatype.Node) *NodeInfo {
+	return &NodeInfo{
+		ID:         n.ID,
+		Host:       n.Host(),
+		Meta:       n.Metadata(),
+		Coordinate: coordinateInfo(n.Coordinates),
+	}
+}
+
+func coordinateInfo(c *vivaldi.Coordinate) *CoordinateInfo {
+	if c == nil {
+		return nil
+	}
+	return &CoordinateInfo{
+		Vec:        c.Vec,
+		Error:      c.Error,
+		Adjustment: c.Adjustment,
+		Height:     c.Height,
+	}
+}
@@ -0,0 +1,836 @@
+// Messages and service stubs for admin.proto.  Regenerate with `make protoc`
+
+package phi
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+
+// MembersRequest requests the cluster members known to the node
+type MembersRequest struct {
+}
+
+func (m *MembersRequest) Reset()         { *m = MembersRequest{} }
+func (m *MembersRequest) String() string { return proto.CompactTextString(m) }
+func (*MembersRequest) ProtoMessage()    {}
+
+// CoordinateInfo is a vivaldi coordinate
+type CoordinateInfo struct {
+	Vec        []float64 `protobuf:"fixed64,1,rep,packed,name=Vec" json:"Vec,omitempty"`
+	Error      float64   `protobuf:"fixed64,2,opt,name=Error" json:"Error,omitempty"`
+	Adjustment float64   `protobuf:"fixed64,3,opt,name=Adjustment" json:"Adjustment,omitempty"`
+	Height     float64   `protobuf:"fixed64,4,opt,name=Height" json:"Height,omitempty"`
+}
+
+func (m *CoordinateInfo) Reset()         { *m = CoordinateInfo{} }
+func (m *CoordinateInfo) String() string { return proto.CompactTextString(m) }
+func (*CoordinateInfo) ProtoMessage()    {}
+
+func (m *CoordinateInfo) GetVec() []float64 {
+	if m != nil {
+		return m.Vec
+	}
+	return nil
+}
+
+func (m *CoordinateInfo) GetError() float64 {
+	if m != nil {
+		return m.Error
+	}
+	return 0
+}
+
+func (m *CoordinateInfo) GetAdjustment() float64 {
+	if m != nil {
+		return m.Adjustment
+	}
+	return 0
+}
+
+func (m *CoordinateInfo) GetHeight() float64 {
+	if m != nil {
+		return m.Height
+	}
+	return 0
+}
+
+// NodeInfo is a cluster node as seen by gossip or the dht
+type NodeInfo struct {
+	Name       string            `protobuf:"bytes,1,opt,name=Name" json:"Name,omitempty"`
+	Addr       string            `protobuf:"bytes,2,opt,name=Addr" json:"Addr,omitempty"`
+	State      string            `protobuf:"bytes,3,opt,name=State" json:"State,omitempty"`
+	ID         []byte            `protobuf:"bytes,4,opt,name=ID,proto3" json:"ID,omitempty"`
+	Host       string            `protobuf:"bytes,5,opt,name=Host" json:"Host,omitempty"`
+	Meta       map[string]string `protobuf:"bytes,6,rep,name=Meta" json:"Meta,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Coordinate *CoordinateInfo   `protobuf:"bytes,7,opt,name=Coordinate" json:"Coordinate,omitempty"`
+}
+
+func (m *NodeInfo) Reset()         { *m = NodeInfo{} }
+func (m *NodeInfo) String() string { return proto.CompactTextString(m) }
+func (*NodeInfo) ProtoMessage()    {}
+
+func (m *NodeInfo) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *NodeInfo) GetAddr() string {
+	if m != nil {
+		return m.Addr
+	}
+	return ""
+}
+
+func (m *NodeInfo) GetState() string {
+	if m != nil {
+		return m.State
+	}
+	return ""
+}
+
+func (m *NodeInfo) GetID() []byte {
+	if m != nil {
+		return m.ID
+	}
+	return nil
+}
+
+func (m *NodeInfo) GetHost() string {
+	if m != nil {
+		return m.Host
+	}
+	return ""
+}
+
+func (m *NodeInfo) GetMeta() map[string]string {
+	if m != nil {
+		return m.Meta
+	}
+	return nil
+}
+
+func (m *NodeInfo) GetCoordinate() *CoordinateInfo {
+	if m != nil {
+		return m.Coordinate
+	}
+	return nil
+}
+
+// MembersResponse contains the gossip members along with the local lamport time
+// and coordinate
+type MembersResponse struct {
+	Members     []*NodeInfo     `protobuf:"bytes,1,rep,name=Members" json:"Members,omitempty"`
+	LamportTime uint64          `protobuf:"varint,2,opt,name=LamportTime" json:"LamportTime,omitempty"`
+	Coordinate  *CoordinateInfo `protobuf:"bytes,3,opt,name=Coordinate" json:"Coordinate,omitempty"`
+}
+
+func (m *MembersResponse) Reset()         { *m = MembersResponse{} }
+func (m *MembersResponse) String() string { return proto.CompactTextString(m) }
+func (*MembersResponse) ProtoMessage()    {}
+
+func (m *MembersResponse) GetMembers() []*NodeInfo {
+	if m != nil {
+		return m.Members
+	}
+	return nil
+}
+
+func (m *MembersResponse) GetLamportTime() uint64 {
+	if m != nil {
+		return m.LamportTime
+	}
+	return 0
+}
+
+func (m *MembersResponse) GetCoordinate() *CoordinateInfo {
+	if m != nil {
+		return m.Coordinate
+	}
+	return nil
+}
+
+// KeyRequest is a request for a key.  ID is an optional entry id
+type KeyRequest struct {
+	Key []byte `protobuf:"bytes,1,opt,name=Key,proto3" json:"Key,omitempty"`
+	ID  []byte `protobuf:"bytes,2,opt,name=ID,proto3" json:"ID,omitempty"`
+}
+
+func (m *KeyRequest) Reset()         { *m = KeyRequest{} }
+func (m *KeyRequest) String() string { return proto.CompactTextString(m) }
+func (*KeyRequest) ProtoMessage()    {}
+
+func (m *KeyRequest) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *KeyRequest) GetID() []byte {
+	if m != nil {
+		return m.ID
+	}
+	return nil
+}
+
+type NodesResponse struct {
+	Nodes []*NodeInfo `protobuf:"bytes,1,rep,name=Nodes" json:"Nodes,omitempty"`
+}
+
+func (m *NodesResponse) Reset()         { *m = NodesResponse{} }
+func (m *NodesResponse) String() string { return proto.CompactTextString(m) }
+func (*NodesResponse) ProtoMessage()    {}
+
+func (m *NodesResponse) GetNodes() []*NodeInfo {
+	if m != nil {
+		return m.Nodes
+	}
+	return nil
+}
+
+type ParticipantInfo struct {
+	ID       []byte `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	Host     string `protobuf:"bytes,2,opt,name=Host" json:"Host,omitempty"`
+	Priority int32  `protobuf:"varint,3,opt,name=Priority" json:"Priority,omitempty"`
+	Index    int32  `protobuf:"varint,4,opt,name=Index" json:"Index,omitempty"`
+}
+
+func (m *ParticipantInfo) Reset()         { *m = ParticipantInfo{} }
+func (m *ParticipantInfo) String() string { return proto.CompactTextString(m) }
+func (*ParticipantInfo) ProtoMessage()    {}
+
+func (m *ParticipantInfo) GetID() []byte {
+	if m != nil {
+		return m.ID
+	}
+	return nil
+}
+
+func (m *ParticipantInfo) GetHost() string {
+	if m != nil {
+		return m.Host
+	}
+	return ""
+}
+
+func (m *ParticipantInfo) GetPriority() int32 {
+	if m != nil {
+		return m.Priority
+	}
+	return 0
+}
+
+func (m *ParticipantInfo) GetIndex() int32 {
+	if m != nil {
+		return m.Index
+	}
+	return 0
+}
+
+type ParticipantsResponse struct {
+	Participants []*ParticipantInfo `protobuf:"bytes,1,rep,name=Participants" json:"Participants,omitempty"`
+}
+
+func (m *ParticipantsResponse) Reset()         { *m = ParticipantsResponse{} }
+func (m *ParticipantsResponse) String() string { return proto.CompactTextString(m) }
+func (*ParticipantsResponse) ProtoMessage()    {}
+
+func (m *ParticipantsResponse) GetParticipants() []*ParticipantInfo {
+	if m != nil {
+		return m.Participants
+	}
+	return nil
+}
+
+// EntryInfo is a WAL entry along with its id
+type EntryInfo struct {
+	ID        []byte `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	Key       []byte `protobuf:"bytes,2,opt,name=Key,proto3" json:"Key,omitempty"`
+	Previous  []byte `protobuf:"bytes,3,opt,name=Previous,proto3" json:"Previous,omitempty"`
+	Height    uint32 `protobuf:"varint,4,opt,name=Height" json:"Height,omitempty"`
+	Timestamp uint64 `protobuf:"varint,5,opt,name=Timestamp" json:"Timestamp,omitempty"`
+	Data      []byte `protobuf:"bytes,6,opt,name=Data,proto3" json:"Data,omitempty"`
+}
+
+func (m *EntryInfo) Reset()         { *m = EntryInfo{} }
+func (m *EntryInfo) String() string { return proto.CompactTextString(m) }
+func (*EntryInfo) ProtoMessage()    {}
+
+func (m *EntryInfo) GetID() []byte {
+	if m != nil {
+		return m.ID
+	}
+	return nil
+}
+
+func (m *EntryInfo) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *EntryInfo) GetPrevious() []byte {
+	if m != nil {
+		return m.Previous
+	}
+	return nil
+}
+
+func (m *EntryInfo) GetHeight() uint32 {
+	if m != nil {
+		return m.Height
+	}
+	return 0
+}
+
+func (m *EntryInfo) GetTimestamp() uint64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
+func (m *EntryInfo) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+// HistoryRequest requests the entries of a key between two heights inclusive.
+// A zero To means the latest entry
+type HistoryRequest struct {
+	Key  []byte `protobuf:"bytes,1,opt,name=Key,proto3" json:"Key,omitempty"`
+	From uint32 `protobuf:"varint,2,opt,name=From" json:"From,omitempty"`
+	To   uint32 `protobuf:"varint,3,opt,name=To" json:"To,omitempty"`
+}
+
+func (m *HistoryRequest) Reset()         { *m = HistoryRequest{} }
+func (m *HistoryRequest) String() string { return proto.CompactTextString(m) }
+func (*HistoryRequest) ProtoMessage()    {}
+
+func (m *HistoryRequest) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *HistoryRequest) GetFrom() uint32 {
+	if m != nil {
+		return m.From
+	}
+	return 0
+}
+
+func (m *HistoryRequest) GetTo() uint32 {
+	if m != nil {
+		return m.To
+	}
+	return 0
+}
+
+// BlockRequest is a request for a block.  If Index is set the contents of the
+// index with the id are read.  Data is the data to write on put
+type BlockRequest struct {
+	ID    []byte `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	Index bool   `protobuf:"varint,2,opt,name=Index" json:"Index,omitempty"`
+	Data  []byte `protobuf:"bytes,3,opt,name=Data,proto3" json:"Data,omitempty"`
+}
+
+func (m *BlockRequest) Reset()         { *m = BlockRequest{} }
+func (m *BlockRequest) String() string { return proto.CompactTextString(m) }
+func (*BlockRequest) ProtoMessage()    {}
+
+func (m *BlockRequest) GetID() []byte {
+	if m != nil {
+		return m.ID
+	}
+	return nil
+}
+
+func (m *BlockRequest) GetIndex() bool {
+	if m != nil {
+		return m.Index
+	}
+	return false
+}
+
+func (m *BlockRequest) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+type BlockResponse struct {
+	ID        []byte   `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	Type      string   `protobuf:"bytes,2,opt,name=Type" json:"Type,omitempty"`
+	Size      uint64   `protobuf:"varint,3,opt,name=Size" json:"Size,omitempty"`
+	Data      []byte   `protobuf:"bytes,4,opt,name=Data,proto3" json:"Data,omitempty"`
+	Locations []string `protobuf:"bytes,5,rep,name=Locations" json:"Locations,omitempty"`
+}
+
+func (m *BlockResponse) Reset()         { *m = BlockResponse{} }
+func (m *BlockResponse) String() string { return proto.CompactTextString(m) }
+func (*BlockResponse) ProtoMessage()    {}
+
+func (m *BlockResponse) GetID() []byte {
+	if m != nil {
+		return m.ID
+	}
+	return nil
+}
+
+func (m *BlockResponse) GetType() string {
+	if m != nil {
+		return m.Type
+	}
+	return ""
+}
+
+func (m *BlockResponse) GetSize() uint64 {
+	if m != nil {
+		return m.Size
+	}
+	return 0
+}
+
+func (m *BlockResponse) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func (m *BlockResponse) GetLocations() []string {
+	if m != nil {
+		return m.Locations
+	}
+	return nil
+}
+
+// RepairRequest requests local keys with the prefix to be healed
+type RepairRequest struct {
+	Prefix []byte `protobuf:"bytes,1,opt,name=Prefix,proto3" json:"Prefix,omitempty"`
+}
+
+func (m *RepairRequest) Reset()         { *m = RepairRequest{} }
+func (m *RepairRequest) String() string { return proto.CompactTextString(m) }
+func (*RepairRequest) ProtoMessage()    {}
+
+func (m *RepairRequest) GetPrefix() []byte {
+	if m != nil {
+		return m.Prefix
+	}
+	return nil
+}
+
+type RepairResponse struct {
+	Keys   uint32 `protobuf:"varint,1,opt,name=Keys" json:"Keys,omitempty"`
+	Failed uint32 `protobuf:"varint,2,opt,name=Failed" json:"Failed,omitempty"`
+}
+
+func (m *RepairResponse) Reset()         { *m = RepairResponse{} }
+func (m *RepairResponse) String() string { return proto.CompactTextString(m) }
+func (*RepairResponse) ProtoMessage()    {}
+
+func (m *RepairResponse) GetKeys() uint32 {
+	if m != nil {
+		return m.Keys
+	}
+	return 0
+}
+
+func (m *RepairResponse) GetFailed() uint32 {
+	if m != nil {
+		return m.Failed
+	}
+	return 0
+}
+
+func init() {
+	proto.RegisterType((*MembersRequest)(nil), "phi.MembersRequest")
+	proto.RegisterType((*CoordinateInfo)(nil), "phi.CoordinateInfo")
+	proto.RegisterType((*NodeInfo)(nil), "phi.NodeInfo")
+	proto.RegisterType((*MembersResponse)(nil), "phi.MembersResponse")
+	proto.RegisterType((*KeyRequest)(nil), "phi.KeyRequest")
+	proto.RegisterType((*NodesResponse)(nil), "phi.NodesResponse")
+	proto.RegisterType((*ParticipantInfo)(nil), "phi.ParticipantInfo")
+	proto.RegisterType((*ParticipantsResponse)(nil), "phi.ParticipantsResponse")
+	proto.RegisterType((*EntryInfo)(nil), "phi.EntryInfo")
+	proto.RegisterType((*HistoryRequest)(nil), "phi.HistoryRequest")
+	proto.RegisterType((*BlockRequest)(nil), "phi.BlockRequest")
+	proto.RegisterType((*BlockResponse)(nil), "phi.BlockResponse")
+	proto.RegisterType((*RepairRequest)(nil), "phi.RepairRequest")
+	proto.RegisterType((*RepairResponse)(nil), "phi.RepairResponse")
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// Client API for PhiAdminRPC service
+
+type PhiAdminRPCClient interface {
+	MembersRPC(ctx context.Context, in *MembersRequest, opts ...grpc.CallOption) (*MembersResponse, error)
+	DHTLookupRPC(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*NodesResponse, error)
+	JuryRPC(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*ParticipantsResponse, error)
+	WALGetRPC(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*EntryInfo, error)
+	WALHistoryRPC(ctx context.Context, in *HistoryRequest, opts ...grpc.CallOption) (PhiAdminRPC_WALHistoryRPCClient, error)
+	BlockGetRPC(ctx context.Context, in *BlockRequest, opts ...grpc.CallOption) (*BlockResponse, error)
+	BlockPutRPC(ctx context.Context, in *BlockRequest, opts ...grpc.CallOption) (*BlockResponse, error)
+	BlockStatRPC(ctx context.Context, in *BlockRequest, opts ...grpc.CallOption) (*BlockResponse, error)
+	RepairRPC(ctx context.Context, in *RepairRequest, opts ...grpc.CallOption) (*RepairResponse, error)
+}
+
+type phiAdminRPCClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewPhiAdminRPCClient(cc *grpc.ClientConn) PhiAdminRPCClient {
+	return &phiAdminRPCClient{cc}
+}
+
+func (c *phiAdminRPCClient) MembersRPC(ctx context.Context, in *MembersRequest, opts ...grpc.CallOption) (*MembersResponse, error) {
+	out := new(MembersResponse)
+	err := grpc.Invoke(ctx, "/phi.PhiAdminRPC/MembersRPC", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *phiAdminRPCClient) DHTLookupRPC(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*NodesResponse, error) {
+	out := new(NodesResponse)
+	err := grpc.Invoke(ctx, "/phi.PhiAdminRPC/DHTLookupRPC", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *phiAdminRPCClient) JuryRPC(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*ParticipantsResponse, error) {
+	out := new(ParticipantsResponse)
+	err := grpc.Invoke(ctx, "/phi.PhiAdminRPC/JuryRPC", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *phiAdminRPCClient) WALGetRPC(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*EntryInfo, error) {
+	out := new(EntryInfo)
+	err := grpc.Invoke(ctx, "/phi.PhiAdminRPC/WALGetRPC", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *phiAdminRPCClient) WALHistoryRPC(ctx context.Context, in *HistoryRequest, opts ...grpc.CallOption) (PhiAdminRPC_WALHistoryRPCClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_PhiAdminRPC_serviceDesc.Streams[0], c.cc, "/phi.PhiAdminRPC/WALHistoryRPC", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &phiAdminRPCWALHistoryRPCClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type PhiAdminRPC_WALHistoryRPCClient interface {
+	Recv() (*EntryInfo, error)
+	grpc.ClientStream
+}
+
+type phiAdminRPCWALHistoryRPCClient struct {
+	grpc.ClientStream
+}
+
+func (x *phiAdminRPCWALHistoryRPCClient) Recv() (*EntryInfo, error) {
+	m := new(EntryInfo)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *phiAdminRPCClient) BlockGetRPC(ctx context.Context, in *BlockRequest, opts ...grpc.CallOption) (*BlockResponse, error) {
+	out := new(BlockResponse)
+	err := grpc.Invoke(ctx, "/phi.PhiAdminRPC/BlockGetRPC", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *phiAdminRPCClient) BlockPutRPC(ctx context.Context, in *BlockRequest, opts ...grpc.CallOption) (*BlockResponse, error) {
+	out := new(BlockResponse)
+	err := grpc.Invoke(ctx, "/phi.PhiAdminRPC/BlockPutRPC", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *phiAdminRPCClient) BlockStatRPC(ctx context.Context, in *BlockRequest, opts ...grpc.CallOption) (*BlockResponse, error) {
+	out := new(BlockResponse)
+	err := grpc.Invoke(ctx, "/phi.PhiAdminRPC/BlockStatRPC", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *phiAdminRPCClient) RepairRPC(ctx context.Context, in *RepairRequest, opts ...grpc.CallOption) (*RepairResponse, error) {
+	out := new(RepairResponse)
+	err := grpc.Invoke(ctx, "/phi.PhiAdminRPC/RepairRPC", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for PhiAdminRPC service
+
+type PhiAdminRPCServer interface {
+	MembersRPC(context.Context, *MembersRequest) (*MembersResponse, error)
+	DHTLookupRPC(context.Context, *KeyRequest) (*NodesResponse, error)
+	JuryRPC(context.Context, *KeyRequest) (*ParticipantsResponse, error)
+	WALGetRPC(context.Context, *KeyRequest) (*EntryInfo, error)
+	WALHistoryRPC(*HistoryRequest, PhiAdminRPC_WALHistoryRPCServer) error
+	BlockGetRPC(context.Context, *BlockRequest) (*BlockResponse, error)
+	BlockPutRPC(context.Context, *BlockRequest) (*BlockResponse, error)
+	BlockStatRPC(context.Context, *BlockRequest) (*BlockResponse, error)
+	RepairRPC(context.Context, *RepairRequest) (*RepairResponse, error)
+}
+
+func RegisterPhiAdminRPCServer(s *grpc.Server, srv PhiAdminRPCServer) {
+	s.RegisterService(&_PhiAdminRPC_serviceDesc, srv)
+}
+
+func _PhiAdminRPC_MembersRPC_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MembersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PhiAdminRPCServer).MembersRPC(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/phi.PhiAdminRPC/MembersRPC",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PhiAdminRPCServer).MembersRPC(ctx, req.(*MembersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PhiAdminRPC_DHTLookupRPC_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PhiAdminRPCServer).DHTLookupRPC(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/phi.PhiAdminRPC/DHTLookupRPC",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PhiAdminRPCServer).DHTLookupRPC(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PhiAdminRPC_JuryRPC_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PhiAdminRPCServer).JuryRPC(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/phi.PhiAdminRPC/JuryRPC",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PhiAdminRPCServer).JuryRPC(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PhiAdminRPC_WALGetRPC_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PhiAdminRPCServer).WALGetRPC(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/phi.PhiAdminRPC/WALGetRPC",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PhiAdminRPCServer).WALGetRPC(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PhiAdminRPC_WALHistoryRPC_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(HistoryRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PhiAdminRPCServer).WALHistoryRPC(m, &phiAdminRPCWALHistoryRPCServer{stream})
+}
+
+type PhiAdminRPC_WALHistoryRPCServer interface {
+	Send(*EntryInfo) error
+	grpc.ServerStream
+}
+
+type phiAdminRPCWALHistoryRPCServer struct {
+	grpc.ServerStream
+}
+
+func (x *phiAdminRPCWALHistoryRPCServer) Send(m *EntryInfo) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _PhiAdminRPC_BlockGetRPC_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BlockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PhiAdminRPCServer).BlockGetRPC(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/phi.PhiAdminRPC/BlockGetRPC",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PhiAdminRPCServer).BlockGetRPC(ctx, req.(*BlockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PhiAdminRPC_BlockPutRPC_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BlockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PhiAdminRPCServer).BlockPutRPC(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/phi.PhiAdminRPC/BlockPutRPC",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PhiAdminRPCServer).BlockPutRPC(ctx, req.(*BlockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PhiAdminRPC_BlockStatRPC_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BlockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PhiAdminRPCServer).BlockStatRPC(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/phi.PhiAdminRPC/BlockStatRPC",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PhiAdminRPCServer).BlockStatRPC(ctx, req.(*BlockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PhiAdminRPC_RepairRPC_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RepairRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PhiAdminRPCServer).RepairRPC(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/phi.PhiAdminRPC/RepairRPC",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PhiAdminRPCServer).RepairRPC(ctx, req.(*RepairRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _PhiAdminRPC_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "phi.PhiAdminRPC",
+	HandlerType: (*PhiAdminRPCServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "MembersRPC",
+			Handler:    _PhiAdminRPC_MembersRPC_Handler,
+		},
+		{
+			MethodName: "DHTLookupRPC",
+			Handler:    _PhiAdminRPC_DHTLookupRPC_Handler,
+		},
+		{
+			MethodName: "JuryRPC",
+			Handler:    _PhiAdminRPC_JuryRPC_Handler,
+		},
+		{
+			MethodName: "WALGetRPC",
+			Handler:    _PhiAdminRPC_WALGetRPC_Handler,
+		},
+		{
+			MethodName: "BlockGetRPC",
+			Handler:    _PhiAdminRPC_BlockGetRPC_Handler,
+		},
+		{
+			MethodName: "BlockPutRPC",
+			Handler:    _PhiAdminRPC_BlockPutRPC_Handler,
+		},
+		{
+			MethodName: "BlockStatRPC",
+			Handler:    _PhiAdminRPC_BlockStatRPC_Handler,
+		},
+		{
+			MethodName: "RepairRPC",
+			Handler:    _PhiAdminRPC_RepairRPC_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WALHistoryRPC",
+			Handler:       _PhiAdminRPC_WALHistoryRPC_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "admin.proto",
+}
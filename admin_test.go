@@ -0,0 +1,31 @@
+package phi
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/memberlist"
+)
+
+func Test_memberState(t *testing.T) {
+	for state, want := range map[memberlist.NodeStateType]string{
+		memberlist.StateAlive:   "alive",
+		memberlist.StateSuspect: "suspect",
+		memberlist.StateDead:    "dead",
+	} {
+		if have := memberState(&memberlist.Node{State: state}); have != want {
+			t.Fatalf("want=%s have=%s", want, have)
+		}
+	}
+}
+
+func Test_adminServer_writes(t *testing.T) {
+	server := &adminServer{phi: &Phi{conf: DefaultConfig()}}
+
+	if _, err := server.BlockPutRPC(context.Background(), &BlockRequest{Data: []byte("data")}); err != errAdminWritesDisabled {
+		t.Fatal("should fail with", errAdminWritesDisabled, err)
+	}
+	if _, err := server.RepairRPC(context.Background(), &RepairRequest{}); err != errAdminWritesDisabled {
+		t.Fatal("should fail with", errAdminWritesDisabled, err)
+	}
+}
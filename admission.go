@@ -0,0 +1,181 @@
+package phi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/memberlist"
+
+	"github.com/hexablock/hexatype"
+)
+
+// ProtocolVersion is the version of the phi protocol spoken by this node.  It
+// is advertised in the node metadata
+const ProtocolVersion = 1
+
+// Range of protocol versions this node can talk to.  Nodes outside of it are
+// not admitted.  A release raising ProtocolVersion must follow one raising
+// maxProtocolVersion so existing nodes admit upgraded ones during a rolling
+// upgrade
+const (
+	minProtocolVersion = 1
+	maxProtocolVersion = 1
+)
+
+// Metadata keys for admission
+const (
+	metaCluster = "cluster"
+	metaVersion = "version"
+)
+
+var (
+	errClusterMismatch = errors.New("cluster id mismatch")
+	errNodeDenied      = errors.New("node denied")
+	errNodeNotAllowed  = errors.New("node not allowed")
+)
+
+// Admission decides whether a node is allowed to join the cluster.  It is
+// consulted for every alive message and merge after the cluster id and
+// protocol version checks
+type Admission interface {
+	// Admit returns an error if the node with the given gossip address is not
+	// allowed in the cluster
+	Admit(node *hexatype.Node, addr net.IP) error
+}
+
+// AdmissionPolicy implements Admission with allow and deny lists.  Deny lists
+// take precedence.  A non-empty allow list must match for the node to be
+// admitted
+type AdmissionPolicy struct {
+	AllowIDs [][]byte
+	DenyIDs  [][]byte
+
+	AllowNets []*net.IPNet
+	DenyNets  []*net.IPNet
+}
+
+// NewAdmissionPolicy returns a policy from node ids and CIDR networks
+func NewAdmissionPolicy(allowIDs, denyIDs [][]byte, allowCIDRs, denyCIDRs []string) (*AdmissionPolicy, error) {
+	policy := &AdmissionPolicy{AllowIDs: allowIDs, DenyIDs: denyIDs}
+
+	var err error
+	if policy.AllowNets, err = parseCIDRs(allowCIDRs); err != nil {
+		return nil, err
+	}
+	if policy.DenyNets, err = parseCIDRs(denyCIDRs); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// Admit satisfies the Admission interface
+func (policy *AdmissionPolicy) Admit(node *hexatype.Node, addr net.IP) error {
+	if containsID(policy.DenyIDs, node.ID) || containsIP(policy.DenyNets, addr) {
+		return errNodeDenied
+	}
+
+	if len(policy.AllowIDs) > 0 && !containsID(policy.AllowIDs, node.ID) {
+		return errNodeNotAllowed
+	}
+	if len(policy.AllowNets) > 0 && !containsIP(policy.AllowNets, addr) {
+		return errNodeNotAllowed
+	}
+
+	return nil
+}
+
+func containsID(ids [][]byte, id []byte) bool {
+	for _, i := range ids {
+		if bytes.Equal(i, id) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// protocolVersion returns the protocol version from the node metadata.  Nodes
+// predating admission control do not advertise one and speak version 1
+func protocolVersion(node *hexatype.Node) int {
+	v, ok := node.Metadata()[metaVersion]
+	if !ok {
+		return 1
+	}
+	i, _ := strconv.Atoi(v)
+	return i
+}
+
+// admit checks the cluster id and protocol version of a remote node followed
+// by the admission policy if any.  The local node is always admitted
+func (del *delegate) admit(peer *memberlist.Node) error {
+	var node hexatype.Node
+	if err := proto.Unmarshal(peer.Meta, &node); err != nil {
+		return err
+	}
+	if del.isLocal(&node) {
+		return nil
+	}
+
+	if cluster := node.Metadata()[metaCluster]; cluster != del.local.Metadata()[metaCluster] {
+		return errClusterMismatch
+	}
+	if v := protocolVersion(&node); v < minProtocolVersion || v > maxProtocolVersion {
+		return fmt.Errorf("incompatible protocol version %d", v)
+	}
+
+	if del.admission != nil {
+		return del.admission.Admit(&node, peer.Addr)
+	}
+	return nil
+}
+
+// NotifyAlive rejects alive messages from nodes that are not admitted or lost
+// a name conflict
+func (del *delegate) NotifyAlive(peer *memberlist.Node) error {
+	if del.isRejected(peer) {
+		return errConflictRejected
+	}
+
+	if err := del.admit(peer); err != nil {
+		del.logger.Debug("Node not admitted", "node", peer.Name, "host", peer.Address(), "err", err)
+		return err
+	}
+	return nil
+}
+
+// NotifyMerge aborts a join or push/pull if any of the remote cluster members
+// are not admitted
+func (del *delegate) NotifyMerge(peers []*memberlist.Node) error {
+	for _, peer := range peers {
+		if err := del.admit(peer); err != nil {
+			del.logger.Warn("Merge rejected", "node", peer.Name, "host", peer.Address(), "err", err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package phi
+
+import (
+	"net"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/memberlist"
+
+	"github.com/hexablock/hexatype"
+)
+
+func Test_AdmissionPolicy(t *testing.T) {
+	policy, err := NewAdmissionPolicy([][]byte{[]byte("a"), []byte("b")}, [][]byte{[]byte("b")},
+		[]string{"10.0.0.0/8"}, []string{"10.0.1.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		id  string
+		ip  string
+		err error
+	}{
+		{"a", "10.0.0.1", nil},
+		{"b", "10.0.0.1", errNodeDenied},
+		{"a", "10.0.1.1", errNodeDenied},
+		{"c", "10.0.0.1", errNodeNotAllowed},
+		{"a", "192.168.0.1", errNodeNotAllowed},
+	}
+	for _, c := range cases {
+		if err = policy.Admit(&hexatype.Node{ID: []byte(c.id)}, net.ParseIP(c.ip)); err != c.err {
+			t.Errorf("id=%s ip=%s: want %v got %v", c.id, c.ip, c.err, err)
+		}
+	}
+
+	if _, err = NewAdmissionPolicy(nil, nil, []string{"10.0.0.1"}, nil); err == nil {
+		t.Fatal("should fail with invalid cidr")
+	}
+}
+
+func Test_delegate_admission(t *testing.T) {
+	del := testConflictDelegate("local", ConflictReject)
+	del.admission = &AdmissionPolicy{DenyIDs: [][]byte{[]byte("b")}}
+
+	allowed := testConflictNode(t, "10.0.0.1", "a")
+	denied := testConflictNode(t, "10.0.0.2", "b")
+
+	if err := del.NotifyAlive(allowed); err != nil {
+		t.Fatal(err)
+	}
+	if err := del.NotifyAlive(denied); err != errNodeDenied {
+		t.Fatal("should deny node", err)
+	}
+	if err := del.NotifyMerge([]*memberlist.Node{allowed, denied}); err != errNodeDenied {
+		t.Fatal("should abort merge", err)
+	}
+
+	// Undecodable metadata is never admitted
+	if err := del.NotifyAlive(&memberlist.Node{Name: "x", Meta: []byte{0xff}}); err == nil {
+		t.Fatal("should fail with invalid metadata")
+	}
+}
+
+func Test_delegate_admit_version(t *testing.T) {
+	del := testConflictDelegate("local", ConflictReject)
+
+	for v, ok := range map[string]bool{
+		"0": false,
+		"1": true,
+		"2": false,
+	} {
+		meta, err := proto.Marshal(&hexatype.Node{ID: []byte("a"), Meta: map[string]string{metaVersion: v}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = del.NotifyAlive(&memberlist.Node{Name: "a", Addr: net.ParseIP("10.0.0.1"), Meta: meta})
+		if ok != (err == nil) {
+			t.Errorf("version %s: admitted=%v err=%v", v, ok, err)
+		}
+	}
+}
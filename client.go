@@ -0,0 +1,283 @@
+package phi
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/memberlist"
+
+	"github.com/hexablock/blox"
+	"github.com/hexablock/go-kelips"
+	"github.com/hexablock/hexalog"
+	"github.com/hexablock/hexatype"
+	"github.com/hexablock/vivaldi"
+)
+
+// Metadata key and value marking a node as a client
+const (
+	metaRole   = "role"
+	roleClient = "client"
+)
+
+var errPeersRequired = errors.New("peers required to join as a client")
+
+// isClient returns true if the node joined as a client
+func isClient(node *hexatype.Node) bool {
+	return node.Metadata()[metaRole] == roleClient
+}
+
+// Client is a gossip member that neither stores blocks nor votes on log
+// entries.  Members keep it out of the dht so it is never assigned data.  It
+// learns the dht from the cluster on join and reads and writes blocks and log
+// entries on the members
+type Client struct {
+	conf *Config
+
+	local hexatype.Node
+	ltime *hexatype.LamportClock
+	coord *vivaldi.Client
+
+	dht     *kelips.Kelips
+	mdht    *meteredDHT
+	dhtConn *net.UDPConn
+
+	dlg        *delegate
+	memberlist *memberlist.Memberlist
+
+	// Remote only block device and write-ahead-log
+	dev   *BlockDevice
+	wal   *Hexalog
+	hlnet *hexalog.NetTransport
+
+	events *eventBus
+	logger Logger
+
+	shutdownOnce sync.Once
+}
+
+// NewClient creates a client and joins the cluster using the configured
+// peers.  The dht is seeded from the snapshot sent by the peer on join.  The
+// data dir and hexalog address are not used.  As with Create the given config
+// is not modified
+func NewClient(conf *Config) (*Client, error) {
+	if err := conf.validate(true); err != nil {
+		return nil, err
+	}
+	if len(conf.Peers) == 0 {
+		return nil, errPeersRequired
+	}
+	conf = conf.clone()
+	if err := conf.deriveAddrs(); err != nil {
+		return nil, err
+	}
+	if conf.Logger == nil {
+		conf.Logger = NewStdLogger()
+	}
+
+	// Clients keep no state so every start is the first incarnation
+	ltime := &hexatype.LamportClock{}
+	inc, _ := nextIncarnation("", ltime)
+
+	conf.DHT.Meta[metaRole] = roleClient
+	if err := conf.setupGossip(inc); err != nil {
+		return nil, err
+	}
+
+	coord, err := vivaldi.NewClient(vivaldi.DefaultConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	client := &Client{
+		conf:   conf,
+		ltime:  ltime,
+		coord:  coord,
+		events: newEventBus(),
+		logger: conf.Logger,
+	}
+
+	if err = client.initDHT(); err != nil {
+		return nil, err
+	}
+	client.initBlockDevice()
+	client.initHexalog()
+	client.init()
+
+	if client.memberlist, err = memberlist.Create(conf.Memberlist); err != nil {
+		client.dhtConn.Close()
+		return nil, err
+	}
+
+	n, err := client.memberlist.Join(conf.Peers)
+	if err != nil {
+		client.Shutdown()
+		return nil, err
+	}
+	client.logger.Info("Joined cluster as client", "peers", n)
+
+	return client, nil
+}
+
+func (client *Client) initDHT() error {
+	udpAddr, err := net.ResolveUDPAddr("udp", client.conf.dhtBindAddr())
+	if err != nil {
+		return err
+	}
+
+	ln, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+
+	client.dhtConn = ln
+	client.dht = kelips.Create(client.conf.DHT, kelips.NewUDPTransport(ln))
+	client.mdht = &meteredDHT{dht: client.dht}
+	client.local = client.dht.LocalNode()
+
+	// Lookups must only return members
+	client.dht.RemoveNode(client.local.Host())
+
+	return nil
+}
+
+// initBlockDevice sets up a block device without a local store.  All blocks
+// are read from and written to the members
+func (client *Client) initBlockDevice() {
+	opts := blox.DefaultNetClientOptions(client.conf.HashFunc)
+	trans := blox.NewNetTransport(opts)
+
+	client.dev = NewBlockDevice(client.conf.Replicas, client.conf.HashFunc, client.local, nil, trans)
+	client.dev.logger = client.logger
+	client.dev.RegisterDHT(client.mdht)
+}
+
+// initHexalog sets up a write-ahead-log without a local log.  The client is
+// never a participant so all requests go to the members
+func (client *Client) initHexalog() {
+	c := client.conf.Hexalog
+
+	client.hlnet = hexalog.NewNetTransport(30*time.Second, 300*time.Second)
+	trans := newLocalHexalogTransport("", client.hlnet)
+
+	client.wal = NewHexalog(trans, c.Votes, c.Hasher)
+	client.wal.SetReadOptions(client.conf.WALRead)
+	client.wal.events = client.events
+	client.wal.logger = client.logger
+	if client.conf.SigningKey != nil {
+		client.wal.signer = NewSigner(client.conf.SigningKey)
+	}
+	client.conf.Jury.RegisterDHT(client.mdht)
+	client.wal.RegisterJury(client.conf.Jury)
+}
+
+func (client *Client) init() {
+	client.dlg = &delegate{
+		local:      client.local,
+		coord:      client.coord,
+		ltime:      client.ltime,
+		dht:        client.dht,
+		broadcasts: make([][]byte, 0),
+		dead:       make(map[string]struct{}),
+		conflict:   client.conf.Conflict,
+		rejected:   make(map[string]string),
+		shutdown:   client.conflictShutdown,
+		admission:  client.conf.Admission,
+		events:     client.events,
+		logger:     client.logger,
+	}
+	client.wal.RegisterLiveness(client.dlg)
+
+	c := client.conf.Memberlist
+	c.Delegate = client.dlg
+	c.Ping = client.dlg
+	c.Events = client.dlg
+	c.Alive = client.dlg
+	c.Conflict = client.dlg
+	c.Merge = client.dlg
+}
+
+// LocalNode returns the local client node
+func (client *Client) LocalNode() hexatype.Node {
+	return client.local
+}
+
+// DHT returns the dht learnt from the cluster
+func (client *Client) DHT() DHT {
+	return client.mdht
+}
+
+// BlockDevice returns a block device storing blocks on the members
+func (client *Client) BlockDevice() *BlockDevice {
+	return client.dev
+}
+
+// WAL returns the write-ahead-log of the cluster
+func (client *Client) WAL() WAL {
+	return client.wal
+}
+
+// Members returns the alive cluster members holding the log.  Clients,
+// including the local one, and members whose metadata cannot be decoded are
+// not returned
+func (client *Client) Members() []*hexatype.Node {
+	members := client.memberlist.Members()
+
+	nodes := make([]*hexatype.Node, 0, len(members))
+	for _, m := range members {
+		var node hexatype.Node
+		if err := proto.Unmarshal(m.Meta, &node); err != nil || isClient(&node) {
+			continue
+		}
+		nodes = append(nodes, &node)
+	}
+	return nodes
+}
+
+// Subscribe returns a subscription for events of the given types or all events
+// if none are given.  Up to buffSize events are buffered
+func (client *Client) Subscribe(buffSize int, types ...EventType) *Subscription {
+	return client.events.subscribe(buffSize, types)
+}
+
+// Shutdown leaves the cluster and closes all connections
+func (client *Client) Shutdown() error {
+	var err error
+	client.shutdownOnce.Do(func() {
+		err = client.shutdown()
+	})
+	return err
+}
+
+// conflictShutdown shuts down the client after it lost a gossip name conflict
+func (client *Client) conflictShutdown() {
+	if err := client.Shutdown(); err != nil {
+		client.logger.Error("Shutdown failed", "err", err)
+	}
+}
+
+func (client *Client) shutdown() error {
+	var err error
+	if er := client.memberlist.Leave(leaveTimeout); er != nil {
+		client.logger.Error("Failed to leave cluster", "err", er)
+	}
+	if er := client.memberlist.Shutdown(); er != nil {
+		err = er
+	}
+
+	client.events.closeAll()
+
+	if er := client.dev.Close(); er != nil {
+		err = er
+	}
+	// Close the pooled connections to the members' hexalog
+	client.hlnet.Shutdown()
+	if er := client.dhtConn.Close(); er != nil {
+		err = er
+	}
+
+	client.logger.Info("Shutdown complete")
+	return err
+}
@@ -0,0 +1,138 @@
+package phi
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/memberlist"
+
+	kelips "github.com/hexablock/go-kelips"
+	"github.com/hexablock/hexalog"
+	"github.com/hexablock/hexatype"
+)
+
+func Test_delegate_client(t *testing.T) {
+	// The delegate has no dht.  Clients are never added to or removed from it
+	del := testConflictDelegate("local", ConflictReject)
+	del.dead = make(map[string]struct{})
+	sub := del.events.subscribe(2, []EventType{EventNodeJoined, EventNodeLeft})
+
+	meta, err := proto.Marshal(&hexatype.Node{ID: []byte("client"), Meta: map[string]string{metaRole: roleClient}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &memberlist.Node{Name: "client", Addr: net.ParseIP("10.0.0.2"), Port: 44550, Meta: meta}
+
+	del.NotifyJoin(node)
+	del.NotifyLeave(node)
+
+	for _, typ := range []EventType{EventNodeJoined, EventNodeLeft} {
+		ev := <-sub.C
+		if ev.Type != typ || ev.Err != nil || !isClient(ev.Node) {
+			t.Fatalf("wrong event %+v", ev)
+		}
+	}
+	if !del.IsDead([]byte("client")) {
+		t.Fatal("client should be dead after leaving")
+	}
+}
+
+func Test_Client(t *testing.T) {
+	fid0, err := newTestPhi("127.0.0.1:41020", "127.0.0.1:18100", "127.0.0.1", 44570)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fid0.Shutdown()
+	fid1, err := newTestPhi("127.0.0.1:41021", "127.0.0.1:18101", "127.0.0.1", 44571)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fid1.Shutdown()
+	if err = fid1.Join([]string{"127.0.0.1:44570"}); err != nil {
+		t.Fatal(err)
+	}
+	fid2, err := newTestPhi("127.0.0.1:41023", "127.0.0.1:18102", "127.0.0.1", 44573)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fid2.Shutdown()
+	if err = fid2.Join([]string{"127.0.0.1:44570"}); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := DefaultConfig()
+	conf.Memberlist = testMemberlistConfig("127.0.0.1:41022", "127.0.0.1", 44572)
+	conf.DHT = kelips.DefaultConfig("127.0.0.1:41022")
+	conf.Hexalog = hexalog.DefaultConfig("")
+	conf.Hexalog.Votes = 2
+	conf.Peers = []string{"127.0.0.1:44570"}
+
+	client, err := NewClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+
+	<-time.After(time.Second)
+
+	isClientNode := func(n *hexatype.Node) bool {
+		return n.Host() == "127.0.0.1:41022" || isClient(n)
+	}
+
+	members := client.Members()
+	if len(members) != 3 {
+		t.Fatal("wrong member count", len(members))
+	}
+	for _, n := range members {
+		if isClientNode(n) {
+			t.Fatal("client returned as a member")
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+
+		// The client dht is seeded with the members only
+		nodes, err := client.DHT().LookupNodes(key, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(nodes) < 2 {
+			t.Fatal("client dht not seeded", len(nodes))
+		}
+		for _, n := range nodes {
+			if isClientNode(n) {
+				t.Fatal("client returned by its own lookup")
+			}
+		}
+
+		// Members never assign data or votes to the client
+		for _, fid := range []*Phi{fid0, fid1, fid2} {
+			if nodes, err = fid.DHT().LookupNodes(key, 2); err != nil {
+				t.Fatal(err)
+			}
+			for _, n := range nodes {
+				if isClientNode(n) {
+					t.Fatal("client returned by member lookup")
+				}
+			}
+
+			peers, err := fid.conf.Jury.Participants(key, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range peers {
+				if string(p.ID) == string(client.LocalNode().ID) || p.Host == "" {
+					t.Fatal("client selected as a participant", p.Host)
+				}
+			}
+		}
+	}
+
+	if err = client.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hexablock/phi/fs"
+)
+
+type cachedInode struct {
+	inode   *fs.Inode
+	expires time.Time
+}
+
+// inodeCache caches inodes, and with them directory entries, locally.  Entries
+// are invalidated by local changes and by entries applied in the cluster as
+// delivered by the invalidator.  The ttl bounds staleness if a watch is down
+type inodeCache struct {
+	fsys *fs.FS
+	ttl  time.Duration
+
+	mu     sync.Mutex
+	inodes map[uint64]*cachedInode
+
+	// Called when an inode is changed by another node e.g. to invalidate the
+	// kernel cache
+	notify func(ino uint64)
+}
+
+func newInodeCache(fsys *fs.FS, ttl time.Duration) *inodeCache {
+	return &inodeCache{
+		fsys:   fsys,
+		ttl:    ttl,
+		inodes: make(map[uint64]*cachedInode),
+	}
+}
+
+// get returns the inode from the cache fetching it if not cached or expired
+func (cache *inodeCache) get(ino uint64) (*fs.Inode, error) {
+	cache.mu.Lock()
+	ci, ok := cache.inodes[ino]
+	cache.mu.Unlock()
+
+	if ok && time.Now().Before(ci.expires) {
+		return ci.inode, nil
+	}
+
+	inode, err := cache.fsys.GetInode(ino)
+	if err != nil {
+		cache.invalidate(ino)
+		return nil, err
+	}
+
+	cache.put(inode)
+	return inode, nil
+}
+
+func (cache *inodeCache) put(inode *fs.Inode) {
+	cache.mu.Lock()
+	cache.inodes[inode.Ino] = &cachedInode{inode: inode, expires: time.Now().Add(cache.ttl)}
+	cache.mu.Unlock()
+}
+
+// invalidate removes the inode from the cache returning the cached inode if
+// any
+func (cache *inodeCache) invalidate(ino uint64) *fs.Inode {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	ci, ok := cache.inodes[ino]
+	if !ok {
+		return nil
+	}
+	delete(cache.inodes, ino)
+	return ci.inode
+}
+
+// changed invalidates an inode changed by another node
+func (cache *inodeCache) changed(ino uint64) {
+	cache.invalidate(ino)
+
+	cache.mu.Lock()
+	notify := cache.notify
+	cache.mu.Unlock()
+
+	if notify != nil {
+		notify(ino)
+	}
+}
+
+// setNotify sets the function called when an inode is changed by another node
+func (cache *inodeCache) setNotify(fn func(ino uint64)) {
+	cache.mu.Lock()
+	cache.notify = fn
+	cache.mu.Unlock()
+}
+
+// clear removes all inodes from the cache
+func (cache *inodeCache) clear() {
+	cache.mu.Lock()
+	cache.inodes = make(map[uint64]*cachedInode)
+	cache.mu.Unlock()
+}
@@ -0,0 +1,95 @@
+// Command phi-mount joins a phi cluster as a client and mounts its file-system
+// using FUSE.  File contents are read and written through blox on the cluster
+// block device and metadata through the WAL.  The client stores no data.
+// Cached inodes are invalidated as entries are applied by watching the
+// namespace on each member.
+//
+//	phi-mount -join 10.0.0.1:44550 /mnt/phi
+//
+// The mount is only supported on linux
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hexablock/log"
+	"github.com/hexablock/phi"
+	"github.com/hexablock/phi/fs"
+	"github.com/hexablock/phi/kv"
+)
+
+var (
+	gossipAddr = flag.String("gossip-addr", "127.0.0.1:44550", "Gossip bind address")
+	dhtAddr    = flag.String("dht-addr", "127.0.0.1:41000", "DHT and block transport bind address")
+	join       = flag.String("join", "", "Comma separated list of existing peers to join")
+	namespace  = flag.String("namespace", "fs/", "WAL key namespace of the file-system")
+	cacheTTL   = flag.Duration("cache-ttl", 5*time.Second, "Max time to cache inodes if a member cannot be watched")
+	debug      = flag.Bool("debug", false, "Enable debug logging")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] <mountpoint>\n\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(1)
+	}
+	mountpoint := flag.Arg(0)
+
+	if *debug {
+		log.SetLevel("DEBUG")
+	} else {
+		log.SetLevel("INFO")
+	}
+
+	client, err := phi.NewClient(buildConfig())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	kvconf := kv.DefaultConfig()
+	kvconf.Namespace = []byte(*namespace)
+	kvs := kv.New(kvconf, client.WAL(), client.BlockDevice())
+
+	fsys := fs.New(kvs, client.BlockDevice())
+	if err = fsys.Init(); err != nil {
+		client.Shutdown()
+		log.Fatal(err)
+	}
+
+	cache := newInodeCache(fsys, *cacheTTL)
+	inv := newInvalidator(client, cache, kvconf.Namespace)
+	inv.start()
+
+	err = mount(fsys, cache, mountpoint)
+	inv.stop()
+	client.Shutdown()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func buildConfig() *phi.Config {
+	conf := phi.DefaultConfig()
+	conf.Addrs = &phi.Addresses{
+		Gossip: phi.AddrConfig{Bind: *gossipAddr},
+		DHT:    phi.AddrConfig{Bind: *dhtAddr},
+	}
+	conf.DHT.EnablePropogation = true
+
+	if *join != "" {
+		conf.Peers = strings.Split(*join, ",")
+	}
+
+	return conf
+}
@@ -0,0 +1,466 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+
+	"bazil.org/fuse"
+	fusefs "bazil.org/fuse/fs"
+
+	"github.com/hexablock/log"
+	"github.com/hexablock/phi/fs"
+)
+
+// mount mounts the file-system and serves it until unmounted or interrupted
+func mount(fsys *fs.FS, cache *inodeCache, mountpoint string) error {
+	conn, err := fuse.Mount(mountpoint, fuse.FSName("phi"), fuse.Subtype("phifs"))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ffs := &fuseFS{
+		fsys:  fsys,
+		cache: cache,
+		nodes: make(map[uint64]*node),
+	}
+	ffs.srv = fusefs.New(conn, nil)
+	cache.setNotify(ffs.invalidateKernel)
+	defer cache.setNotify(nil)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		log.Printf("[INFO] Unmounting %s", mountpoint)
+		if er := fuse.Unmount(mountpoint); er != nil {
+			log.Printf("[ERROR] Unmount failed: %v", er)
+		}
+	}()
+
+	log.Printf("[INFO] Mounted %s", mountpoint)
+
+	if err = ffs.srv.Serve(ffs); err != nil {
+		return err
+	}
+
+	<-conn.Ready
+	return conn.MountError
+}
+
+// fuseFS serves the phi file-system over FUSE
+type fuseFS struct {
+	fsys  *fs.FS
+	cache *inodeCache
+	srv   *fusefs.Server
+
+	mu    sync.Mutex
+	nodes map[uint64]*node
+}
+
+// Root returns the root directory node
+func (ffs *fuseFS) Root() (fusefs.Node, error) {
+	return ffs.node(fs.RootIno), nil
+}
+
+// node returns the node for the inode.  The same node is returned for an inode
+// until the kernel forgets it
+func (ffs *fuseFS) node(ino uint64) *node {
+	ffs.mu.Lock()
+	defer ffs.mu.Unlock()
+
+	n, ok := ffs.nodes[ino]
+	if !ok {
+		n = &node{ffs: ffs, ino: ino, handles: make(map[*handle]struct{})}
+		ffs.nodes[ino] = n
+	}
+	return n
+}
+
+// invalidateKernel invalidates the kernel attributes and data of the inode if
+// the kernel knows it
+func (ffs *fuseFS) invalidateKernel(ino uint64) {
+	ffs.mu.Lock()
+	n, ok := ffs.nodes[ino]
+	ffs.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	if err := ffs.srv.InvalidateNodeAttr(n); err != nil && err != fuse.ErrNotCached {
+		log.Printf("[DEBUG] Failed to invalidate attributes inode=%d: %v", ino, err)
+	}
+	if err := ffs.srv.InvalidateNodeData(n); err != nil && err != fuse.ErrNotCached {
+		log.Printf("[DEBUG] Failed to invalidate data inode=%d: %v", ino, err)
+	}
+}
+
+// node is a file or directory
+type node struct {
+	ffs *fuseFS
+	ino uint64
+
+	// Open file handles
+	mu      sync.Mutex
+	handles map[*handle]struct{}
+}
+
+func (n *node) Attr(ctx context.Context, attr *fuse.Attr) error {
+	inode, err := n.ffs.cache.get(n.ino)
+	if err != nil {
+		return toErrno(err)
+	}
+
+	n.fillAttr(inode, attr)
+	return nil
+}
+
+func (n *node) fillAttr(inode *fs.Inode, attr *fuse.Attr) {
+	attr.Valid = n.ffs.cache.ttl
+	attr.Inode = inode.Ino
+	attr.Mode = inode.Mode
+	attr.Size = uint64(inode.Size)
+	attr.Mtime = inode.Mtime
+	attr.Ctime = inode.Ctime
+	attr.Nlink = 1
+	if inode.IsDir() {
+		attr.Nlink = 2
+	}
+}
+
+func (n *node) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fusefs.Node, error) {
+	dir, err := n.ffs.cache.get(n.ino)
+	if err != nil {
+		return nil, toErrno(err)
+	}
+	if !dir.IsDir() {
+		return nil, toErrno(fs.ErrNotDir)
+	}
+
+	de, ok := dir.Entries[req.Name]
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+
+	resp.EntryValid = n.ffs.cache.ttl
+	return n.ffs.node(de.Ino), nil
+}
+
+func (n *node) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	dir, err := n.ffs.cache.get(n.ino)
+	if err != nil {
+		return nil, toErrno(err)
+	}
+	if !dir.IsDir() {
+		return nil, toErrno(fs.ErrNotDir)
+	}
+
+	out := make([]fuse.Dirent, 0, len(dir.Entries))
+	for name, de := range dir.Entries {
+		typ := fuse.DT_File
+		if de.Dir {
+			typ = fuse.DT_Dir
+		}
+		out = append(out, fuse.Dirent{Inode: de.Ino, Name: name, Type: typ})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+
+	return out, nil
+}
+
+func (n *node) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fusefs.Node, error) {
+	inode, err := n.ffs.fsys.MkdirAt(n.ino, req.Name, req.Mode)
+	n.ffs.cache.invalidate(n.ino)
+	if err != nil {
+		return nil, toErrno(err)
+	}
+
+	n.ffs.cache.put(inode)
+	return n.ffs.node(inode.Ino), nil
+}
+
+func (n *node) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fusefs.Node, fusefs.Handle, error) {
+	inode, err := n.ffs.fsys.CreateAt(n.ino, req.Name, req.Mode)
+	n.ffs.cache.invalidate(n.ino)
+
+	if err == fs.ErrExist && req.Flags&fuse.OpenExclusive == 0 {
+		if inode, err = n.ffs.fsys.Lookup(n.ino, req.Name); err == nil && req.Flags&fuse.OpenTruncate != 0 {
+			inode, err = n.ffs.node(inode.Ino).truncate(0)
+		}
+	}
+	if err != nil {
+		return nil, nil, toErrno(err)
+	}
+
+	n.ffs.cache.put(inode)
+	child := n.ffs.node(inode.Ino)
+	n.fillAttr(inode, &resp.Attr)
+
+	return child, child.open(), nil
+}
+
+func (n *node) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	var err error
+	if req.Dir {
+		err = n.ffs.fsys.RmdirAt(n.ino, req.Name)
+	} else {
+		err = n.ffs.fsys.UnlinkAt(n.ino, req.Name)
+	}
+	n.ffs.cache.invalidate(n.ino)
+
+	return toErrno(err)
+}
+
+func (n *node) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fusefs.Node) error {
+	dst, ok := newDir.(*node)
+	if !ok {
+		return fuse.EIO
+	}
+
+	err := n.ffs.fsys.RenameAt(n.ino, req.OldName, dst.ino, req.NewName)
+	n.ffs.cache.invalidate(n.ino)
+	n.ffs.cache.invalidate(dst.ino)
+
+	return toErrno(err)
+}
+
+func (n *node) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	var (
+		inode *fs.Inode
+		err   error
+	)
+
+	// Only size changes are supported.  Other attributes are ignored
+	if req.Valid.Size() {
+		inode, err = n.truncate(int64(req.Size))
+	} else {
+		inode, err = n.ffs.cache.get(n.ino)
+	}
+	if err != nil {
+		return toErrno(err)
+	}
+
+	n.fillAttr(inode, &resp.Attr)
+	return nil
+}
+
+func (n *node) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fusefs.Handle, error) {
+	if req.Dir {
+		return n, nil
+	}
+
+	if req.Flags&fuse.OpenTruncate != 0 {
+		if _, err := n.truncate(0); err != nil {
+			return nil, toErrno(err)
+		}
+	}
+
+	return n.open(), nil
+}
+
+// open returns a new handle for the file
+func (n *node) open() *handle {
+	h := &handle{n: n}
+
+	n.mu.Lock()
+	n.handles[h] = struct{}{}
+	n.mu.Unlock()
+
+	return h
+}
+
+// truncate changes the size of the file and of the spools of open handles so
+// pending writes do not restore the previous size when flushed
+func (n *node) truncate(size int64) (*fs.Inode, error) {
+	n.mu.Lock()
+	handles := make([]*handle, 0, len(n.handles))
+	for h := range n.handles {
+		handles = append(handles, h)
+	}
+	n.mu.Unlock()
+
+	for _, h := range handles {
+		if err := h.truncate(size); err != nil {
+			return nil, err
+		}
+	}
+
+	inode, err := n.ffs.fsys.TruncateAt(n.ino, size)
+	n.ffs.cache.invalidate(n.ino)
+	return inode, err
+}
+
+// Fsync is a no-op as data is written to the cluster on flush
+func (n *node) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
+	return nil
+}
+
+// Forget removes the node once the kernel no longer references it
+func (n *node) Forget() {
+	n.ffs.mu.Lock()
+	delete(n.ffs.nodes, n.ino)
+	n.ffs.mu.Unlock()
+}
+
+// handle is an open file.  Reads are streamed from the block device.  Writes
+// are spooled to a local temp file seeded with the current contents and
+// streamed to the block device on flush
+type handle struct {
+	n *node
+
+	mu    sync.Mutex
+	spool *os.File
+	dirty bool
+}
+
+func (h *handle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.spool != nil {
+		buf := make([]byte, req.Size)
+		n, err := h.spool.ReadAt(buf, req.Offset)
+		if err != nil && err != io.EOF {
+			return toErrno(err)
+		}
+		resp.Data = buf[:n]
+		return nil
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, req.Size))
+	if _, err := h.n.ffs.fsys.ReadAt(h.n.ino, req.Offset, int64(req.Size), buf); err != nil {
+		return toErrno(err)
+	}
+	resp.Data = buf.Bytes()
+	return nil
+}
+
+func (h *handle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.openSpool(); err != nil {
+		return toErrno(err)
+	}
+
+	n, err := h.spool.WriteAt(req.Data, req.Offset)
+	if err != nil {
+		return toErrno(err)
+	}
+
+	h.dirty = true
+	resp.Size = n
+	return nil
+}
+
+func (h *handle) Flush(ctx context.Context, req *fuse.FlushRequest) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return toErrno(h.flush())
+}
+
+func (h *handle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	err := h.flush()
+	if h.spool != nil {
+		h.spool.Close()
+		os.Remove(h.spool.Name())
+		h.spool = nil
+	}
+
+	h.n.mu.Lock()
+	delete(h.n.handles, h)
+	h.n.mu.Unlock()
+
+	return toErrno(err)
+}
+
+// truncate changes the size of the spool if open
+func (h *handle) truncate(size int64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.spool == nil {
+		return nil
+	}
+	return h.spool.Truncate(size)
+}
+
+// openSpool creates the spool file seeded with the current contents if not
+// already open
+func (h *handle) openSpool() error {
+	if h.spool != nil {
+		return nil
+	}
+
+	f, err := ioutil.TempFile("", "phi-mount-")
+	if err != nil {
+		return err
+	}
+
+	if _, err = h.n.ffs.fsys.ReadAt(h.n.ino, 0, -1, f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	h.spool = f
+	return nil
+}
+
+// flush writes the spooled contents to the cluster if changed
+func (h *handle) flush() error {
+	if !h.dirty {
+		return nil
+	}
+
+	if _, err := h.spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	inode, err := h.n.ffs.fsys.WriteContent(h.n.ino, h.spool)
+	if err != nil {
+		h.n.ffs.cache.invalidate(h.n.ino)
+		return err
+	}
+
+	h.n.ffs.cache.put(inode)
+	h.dirty = false
+	return nil
+}
+
+// toErrno maps file-system errors to errnos
+func toErrno(err error) error {
+	switch err {
+	case nil:
+		return nil
+	case fs.ErrNotExist:
+		return fuse.ENOENT
+	case fs.ErrExist:
+		return fuse.EEXIST
+	case fs.ErrNotDir:
+		return fuse.Errno(syscall.ENOTDIR)
+	case fs.ErrIsDir:
+		return fuse.Errno(syscall.EISDIR)
+	case fs.ErrNotEmpty:
+		return fuse.Errno(syscall.ENOTEMPTY)
+	case fs.ErrInvalidName:
+		return fuse.Errno(syscall.EINVAL)
+	}
+
+	log.Printf("[ERROR] File-system operation failed: %v", err)
+	return fuse.EIO
+}
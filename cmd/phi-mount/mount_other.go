@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+
+	"github.com/hexablock/phi/fs"
+)
+
+func mount(fsys *fs.FS, cache *inodeCache, mountpoint string) error {
+	return errors.New("mount is only supported on linux")
+}
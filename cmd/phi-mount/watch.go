@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/hexablock/hexatype"
+	"github.com/hexablock/log"
+	"github.com/hexablock/phi"
+	"github.com/hexablock/phi/fs"
+)
+
+// Time to wait before re-opening a failed watch stream
+const watchRetryInterval = 2 * time.Second
+
+// invalidator invalidates cached inodes as their entries are applied in the
+// cluster.  Members only deliver entries for keys they are participants for so
+// the namespace is watched on every member holding the log
+type invalidator struct {
+	client *phi.Client
+	cache  *inodeCache
+	ns     []byte
+
+	mu      sync.Mutex
+	watches map[string]context.CancelFunc // by hexalog host
+	stopped bool
+
+	sub *phi.Subscription
+}
+
+func newInvalidator(client *phi.Client, cache *inodeCache, ns []byte) *invalidator {
+	return &invalidator{
+		client:  client,
+		cache:   cache,
+		ns:      ns,
+		watches: make(map[string]context.CancelFunc),
+	}
+}
+
+// start watches the current members and those joining later
+func (inv *invalidator) start() {
+	// Subscribe before listing so members joining in between are not missed
+	inv.sub = inv.client.Subscribe(16, phi.EventNodeJoined, phi.EventNodeLeft)
+
+	for _, node := range inv.client.Members() {
+		inv.watch(node)
+	}
+
+	go func() {
+		for ev := range inv.sub.C {
+			if ev.Node == nil {
+				continue
+			}
+			if ev.Type == phi.EventNodeJoined {
+				inv.watch(ev.Node)
+			} else {
+				inv.unwatch(ev.Node)
+			}
+		}
+	}()
+}
+
+// stop closes all watch streams
+func (inv *invalidator) stop() {
+	inv.sub.Close()
+
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	inv.stopped = true
+	for host, cancel := range inv.watches {
+		cancel()
+		delete(inv.watches, host)
+	}
+}
+
+func (inv *invalidator) watch(node *hexatype.Node) {
+	host := node.Metadata()["hexalog"]
+	if host == "" || node.Metadata()["role"] == "client" {
+		return
+	}
+
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	if _, ok := inv.watches[host]; ok || inv.stopped {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	inv.watches[host] = cancel
+
+	go inv.run(ctx, host)
+}
+
+func (inv *invalidator) unwatch(node *hexatype.Node) {
+	host := node.Metadata()["hexalog"]
+
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	if cancel, ok := inv.watches[host]; ok {
+		cancel()
+		delete(inv.watches, host)
+	}
+}
+
+// run streams applied entries from the member until the context is cancelled,
+// re-opening the stream if it fails
+func (inv *invalidator) run(ctx context.Context, host string) {
+	conn, err := grpc.DialContext(ctx, host, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Printf("[ERROR] Failed to connect to watch host=%s: %v", host, err)
+		return
+	}
+	defer conn.Close()
+
+	rpc := phi.NewPhiRPCClient(conn)
+	for {
+		err = inv.stream(ctx, rpc)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("[WARN] Watch failed host=%s: %v", host, err)
+
+		select {
+		case <-time.After(watchRetryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (inv *invalidator) stream(ctx context.Context, rpc phi.PhiRPCClient) error {
+	stream, err := rpc.WatchRPC(ctx, &phi.WatchRequest{Prefix: inv.ns})
+	if err != nil {
+		return err
+	}
+	// Entries may have been missed while the stream was not open
+	inv.cache.clear()
+
+	for {
+		ev, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		if ino, ok := fs.ParseInodeKey(bytes.TrimPrefix(ev.Key, inv.ns)); ok {
+			log.Printf("[DEBUG] Invalidating inode=%d height=%d", ino, ev.Height)
+			inv.cache.changed(ino)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+// Command phictl inspects and operates a phi node using its admin gRPC service.
+//
+//	phictl -addr 127.0.0.1:18080 members
+//	phictl dht lookup <key>
+//	phictl jury <key>
+//	phictl wal get <key> [id]
+//	phictl wal history <key> [from] [to]
+//	phictl block get [-index] <id>
+//	phictl block put <file>
+//	phictl block stat <id>
+//	phictl repair [prefix]
+//
+// Ids are hex encoded.  A file of - reads the data to put from stdin.  With
+// -tls-cert, -tls-key and -tls-ca the node is reached with mutual TLS through
+// its HTTP address
+//
+//	phictl -addr 127.0.0.1:8080 -tls-cert cert.pem -tls-key key.pem -tls-ca ca.pem members
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/hexablock/phi"
+)
+
+var errUsage = errors.New("usage: phictl [-addr host:port] <members|dht|jury|wal|block|repair> [args]")
+
+var (
+	addr    = flag.String("addr", "127.0.0.1:18080", "Node gRPC address or HTTP address with TLS")
+	timeout = flag.Duration("timeout", 30*time.Second, "Request timeout")
+
+	tlsCert = flag.String("tls-cert", "", "TLS certificate file")
+	tlsKey  = flag.String("tls-key", "", "TLS key file")
+	tlsCA   = flag.String("tls-ca", "", "TLS CA file to verify the node with")
+)
+
+// transportCredentials returns mutual TLS credentials if any of the tls files
+// are set and plain text ones otherwise
+func transportCredentials() (credentials.TransportCredentials, error) {
+	if *tlsCert == "" && *tlsKey == "" && *tlsCA == "" {
+		return insecure.NewCredentials(), nil
+	}
+
+	host, _, err := net.SplitHostPort(*addr)
+	if err != nil {
+		return nil, err
+	}
+	tc := &phi.TLSConfig{CertFile: *tlsCert, KeyFile: *tlsKey, CAFile: *tlsCA}
+	conf, err := tc.ClientConfig(host)
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(conf), nil
+}
+
+func main() {
+	flag.Parse()
+
+	creds, err := transportCredentials()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	conn, err := grpc.Dial(*addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	err = run(ctx, phi.NewPhiAdminRPCClient(conn), flag.Args(), os.Stdout)
+	cancel()
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run executes the sub-command in args writing the output to w
+func run(ctx context.Context, client phi.PhiAdminRPCClient, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+
+	switch args[0] {
+	case "members":
+		return members(ctx, client, tw)
+
+	case "dht":
+		if len(args) != 3 || args[1] != "lookup" {
+			return errUsage
+		}
+		return dhtLookup(ctx, client, []byte(args[2]), tw)
+
+	case "jury":
+		if len(args) != 2 {
+			return errUsage
+		}
+		return jury(ctx, client, []byte(args[1]), tw)
+
+	case "wal":
+		if len(args) < 3 {
+			return errUsage
+		}
+		return wal(ctx, client, args[1], args[2:], tw)
+
+	case "block":
+		if len(args) < 3 {
+			return errUsage
+		}
+		// Block data is written as is and not through the tabwriter
+		return block(ctx, client, args[1], args[2:], w)
+
+	case "repair":
+		req := &phi.RepairRequest{}
+		if len(args) > 1 {
+			req.Prefix = []byte(args[1])
+		}
+		resp, err := client.RepairRPC(ctx, req)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "Repaired %d keys, %d failed\n", resp.Keys, resp.Failed)
+		return nil
+	}
+
+	return errUsage
+}
+
+func members(ctx context.Context, client phi.PhiAdminRPCClient, w io.Writer) error {
+	resp, err := client.MembersRPC(ctx, &phi.MembersRequest{})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Lamport time: %d\n", resp.LamportTime)
+	fmt.Fprintf(w, "Coordinate: %s\n\n", formatCoordinate(resp.Coordinate))
+
+	fmt.Fprintln(w, "NAME\tADDRESS\tSTATE\tID\tHOST\tCOORDINATE")
+	for _, m := range resp.Members {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%x\t%s\t%s\n", m.Name, m.Addr, m.State, m.ID,
+			m.Host, formatCoordinate(m.Coordinate))
+	}
+	return nil
+}
+
+func dhtLookup(ctx context.Context, client phi.PhiAdminRPCClient, key []byte, w io.Writer) error {
+	resp, err := client.DHTLookupRPC(ctx, &phi.KeyRequest{Key: key})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "ID\tHOST\tHEXALOG")
+	for _, n := range resp.Nodes {
+		fmt.Fprintf(w, "%x\t%s\t%s\n", n.ID, n.Host, n.Meta["hexalog"])
+	}
+	return nil
+}
+
+func jury(ctx context.Context, client phi.PhiAdminRPCClient, key []byte, w io.Writer) error {
+	resp, err := client.JuryRPC(ctx, &phi.KeyRequest{Key: key})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "PRIORITY\tID\tHOST")
+	for _, p := range resp.Participants {
+		fmt.Fprintf(w, "%d\t%x\t%s\n", p.Priority, p.ID, p.Host)
+	}
+	return nil
+}
+
+func wal(ctx context.Context, client phi.PhiAdminRPCClient, cmd string, args []string, w io.Writer) error {
+	key := []byte(args[0])
+
+	switch cmd {
+	case "get":
+		req := &phi.KeyRequest{Key: key}
+		if len(args) > 1 {
+			id, err := hex.DecodeString(args[1])
+			if err != nil {
+				return err
+			}
+			req.ID = id
+		}
+
+		entry, err := client.WALGetRPC(ctx, req)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, "HEIGHT\tID\tPREVIOUS\tTIMESTAMP\tSIZE")
+		writeEntry(w, entry)
+		return nil
+
+	case "history":
+		heights := make([]uint32, 2)
+		for i, s := range args[1:] {
+			if i > 1 {
+				return errUsage
+			}
+			h, err := strconv.ParseUint(s, 10, 32)
+			if err != nil {
+				return err
+			}
+			heights[i] = uint32(h)
+		}
+
+		stream, err := client.WALHistoryRPC(ctx, &phi.HistoryRequest{Key: key, From: heights[0], To: heights[1]})
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(w, "HEIGHT\tID\tPREVIOUS\tTIMESTAMP\tSIZE")
+		for {
+			entry, err := stream.Recv()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			writeEntry(w, entry)
+		}
+	}
+
+	return errUsage
+}
+
+func block(ctx context.Context, client phi.PhiAdminRPCClient, cmd string, args []string, w io.Writer) error {
+	if cmd == "put" {
+		data, err := readInput(args[0])
+		if err != nil {
+			return err
+		}
+		resp, err := client.BlockPutRPC(ctx, &phi.BlockRequest{Data: data})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%x\n", resp.ID)
+		return nil
+	}
+
+	req := &phi.BlockRequest{}
+	if args[0] == "-index" {
+		if len(args) != 2 {
+			return errUsage
+		}
+		req.Index = true
+		args = args[1:]
+	}
+
+	id, err := hex.DecodeString(args[0])
+	if err != nil {
+		return err
+	}
+	req.ID = id
+
+	switch cmd {
+	case "get":
+		resp, err := client.BlockGetRPC(ctx, req)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(resp.Data)
+		return err
+
+	case "stat":
+		resp, err := client.BlockStatRPC(ctx, req)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "ID:\t%x\n", resp.ID)
+		fmt.Fprintf(tw, "Type:\t%s\n", resp.Type)
+		fmt.Fprintf(tw, "Size:\t%d\n", resp.Size)
+		fmt.Fprintf(tw, "Locations:\t%s\n", strings.Join(resp.Locations, ", "))
+		return tw.Flush()
+	}
+
+	return errUsage
+}
+
+func readInput(name string) ([]byte, error) {
+	if name == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(name)
+}
+
+func writeEntry(w io.Writer, entry *phi.EntryInfo) {
+	ts := time.Unix(0, int64(entry.Timestamp)).UTC().Format(time.RFC3339)
+	fmt.Fprintf(w, "%d\t%x\t%x\t%s\t%d\n", entry.Height, entry.ID, entry.Previous, ts, len(entry.Data))
+}
+
+func formatCoordinate(c *phi.CoordinateInfo) string {
+	if c == nil {
+		return "-"
+	}
+
+	vec := make([]string, len(c.Vec))
+	for i, v := range c.Vec {
+		vec[i] = strconv.FormatFloat(v, 'f', 4, 64)
+	}
+	return fmt.Sprintf("[%s] err=%.4f height=%.4f", strings.Join(vec, " "), c.Error, c.Height)
+}
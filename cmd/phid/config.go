@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/hcl"
+	"gopkg.in/yaml.v2"
+
+	"github.com/hexablock/phi"
+)
+
+var errS3HTTPAddr = errors.New("s3 gateway requires an http address")
+
+// addrConfig is the address to listen on and the address advertised to other
+// nodes.  The advertise address defaults to the bind address
+type addrConfig struct {
+	Bind      string `hcl:"bind" yaml:"bind"`
+	Advertise string `hcl:"advertise" yaml:"advertise"`
+}
+
+func (ac *addrConfig) advertise() string {
+	if ac.Advertise != "" {
+		return ac.Advertise
+	}
+	return ac.Bind
+}
+
+// admissionConfig lists hex encoded node ids and CIDR networks to allow or deny
+// into the cluster
+type admissionConfig struct {
+	AllowIDs   []string `hcl:"allow_ids" yaml:"allow_ids"`
+	DenyIDs    []string `hcl:"deny_ids" yaml:"deny_ids"`
+	AllowCIDRs []string `hcl:"allow_cidrs" yaml:"allow_cidrs"`
+	DenyCIDRs  []string `hcl:"deny_cidrs" yaml:"deny_cidrs"`
+}
+
+// policy returns the admission policy or nil if no lists are configured
+func (ac *admissionConfig) policy() (*phi.AdmissionPolicy, error) {
+	if len(ac.AllowIDs)+len(ac.DenyIDs)+len(ac.AllowCIDRs)+len(ac.DenyCIDRs) == 0 {
+		return nil, nil
+	}
+
+	allow, err := decodeIDs(ac.AllowIDs)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := decodeIDs(ac.DenyIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	return phi.NewAdmissionPolicy(allow, deny, ac.AllowCIDRs, ac.DenyCIDRs)
+}
+
+func decodeIDs(ids []string) ([][]byte, error) {
+	out := make([][]byte, 0, len(ids))
+	for _, id := range ids {
+		b, err := hex.DecodeString(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node id %q: %v", id, err)
+		}
+		out = append(out, b)
+	}
+	return out, nil
+}
+
+// tlsConfig is the certificate, key and ca file.  TLS is disabled if none are
+// set.  Node to node transports stay plain text which must be accepted with
+// allow_plaintext_transports
+type tlsConfig struct {
+	CertFile                 string `hcl:"cert_file" yaml:"cert_file"`
+	KeyFile                  string `hcl:"key_file" yaml:"key_file"`
+	CAFile                   string `hcl:"ca_file" yaml:"ca_file"`
+	AllowPlaintextTransports bool   `hcl:"allow_plaintext_transports" yaml:"allow_plaintext_transports"`
+}
+
+func (tc *tlsConfig) enabled() bool {
+	return tc.CertFile != "" || tc.KeyFile != "" || tc.CAFile != ""
+}
+
+func decodeGossipKeys(keys []string) ([][]byte, error) {
+	out := make([][]byte, 0, len(keys))
+	for _, k := range keys {
+		b, err := base64.StdEncoding.DecodeString(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gossip key: %v", err)
+		}
+		out = append(out, b)
+	}
+	return out, nil
+}
+
+var conflictStrategies = map[string]phi.ConflictStrategy{
+	"log":      phi.ConflictLog,
+	"reject":   phi.ConflictReject,
+	"shutdown": phi.ConflictShutdown,
+}
+
+// config is the daemon config as read from the config file and flags
+type config struct {
+	DataDir string `hcl:"data_dir" yaml:"data_dir"`
+
+	Gossip addrConfig `hcl:"gossip" yaml:"gossip"`
+	DHT    addrConfig `hcl:"dht" yaml:"dht"`
+	GRPC   addrConfig `hcl:"grpc" yaml:"grpc"`
+
+	// Optional http address
+	HTTPAddr string `hcl:"http_addr" yaml:"http_addr"`
+
+	// Serve the S3 gateway on the http address
+	S3 bool `hcl:"s3" yaml:"s3"`
+
+	// Allow block writes and repairs through the unauthenticated admin service
+	AdminWrites bool `hcl:"admin_writes" yaml:"admin_writes"`
+
+	Peers []string `hcl:"peers" yaml:"peers"`
+
+	Replicas int `hcl:"replicas" yaml:"replicas"`
+	Votes    int `hcl:"votes" yaml:"votes"`
+	Groups   int `hcl:"groups" yaml:"groups"`
+
+	// Gossip name conflict strategy: log, reject or shutdown
+	Conflict string `hcl:"conflict" yaml:"conflict"`
+
+	// Only nodes with the same cluster id are admitted
+	ClusterID string `hcl:"cluster_id" yaml:"cluster_id"`
+
+	Admission admissionConfig `hcl:"admission" yaml:"admission"`
+
+	// Optional mutual TLS for the HTTP server
+	TLS tlsConfig `hcl:"tls" yaml:"tls"`
+
+	// Base64 encoded keys to encrypt gossip with.  The first is used to
+	// encrypt
+	GossipKeys []string `hcl:"gossip_keys" yaml:"gossip_keys"`
+
+	LogLevel string `hcl:"log_level" yaml:"log_level"`
+
+	Tracing tracingConfig `hcl:"tracing" yaml:"tracing"`
+}
+
+func defaultConfig() *config {
+	return &config{
+		Gossip:   addrConfig{Bind: "127.0.0.1:44550"},
+		DHT:      addrConfig{Bind: "127.0.0.1:41000"},
+		GRPC:     addrConfig{Bind: "127.0.0.1:18080"},
+		Replicas: 2,
+		Votes:    2,
+		Groups:   3,
+		Conflict: "reject",
+		LogLevel: "INFO",
+		Tracing: tracingConfig{
+			Endpoint:    "127.0.0.1:4317",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// loadConfigFile decodes the file into the config.  Files with a yaml or yml
+// extension are decoded as YAML and all others as HCL which includes JSON
+func loadConfigFile(name string, conf *config) error {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, conf)
+	default:
+		err = hcl.Decode(conf, string(b))
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %v", name, err)
+	}
+	return nil
+}
+
+// validate checks the daemon specific values and the resulting phi config
+func (conf *config) validate() error {
+	switch conf.LogLevel {
+	case "DEBUG", "INFO", "WARN", "ERROR":
+	default:
+		return fmt.Errorf("invalid log level %q", conf.LogLevel)
+	}
+
+	if conf.S3 && conf.HTTPAddr == "" {
+		return errS3HTTPAddr
+	}
+
+	if _, ok := conflictStrategies[conf.Conflict]; !ok {
+		return fmt.Errorf("invalid conflict strategy %q", conf.Conflict)
+	}
+
+	if _, err := conf.Admission.policy(); err != nil {
+		return err
+	}
+	if _, err := decodeGossipKeys(conf.GossipKeys); err != nil {
+		return err
+	}
+
+	if err := conf.Tracing.validate(); err != nil {
+		return err
+	}
+
+	return conf.phiConfig().Validate()
+}
+
+// phiConfig returns the phi config for the daemon config.  The component
+// addresses are derived from the gossip, dht and grpc addresses
+func (conf *config) phiConfig() *phi.Config {
+	c := phi.DefaultConfig()
+	c.DataDir = conf.DataDir
+	c.Replicas = conf.Replicas
+	c.Peers = conf.Peers
+	c.HTTPAddr = conf.HTTPAddr
+	c.AdminWrites = conf.AdminWrites
+
+	c.Addrs = &phi.Addresses{
+		Gossip: phi.AddrConfig(conf.Gossip),
+		DHT:    phi.AddrConfig(conf.DHT),
+		GRPC:   phi.AddrConfig(conf.GRPC),
+	}
+
+	c.DHT.NumGroups = conf.Groups
+	c.DHT.EnablePropogation = true
+	c.Hexalog.Votes = conf.Votes
+	c.Conflict = conflictStrategies[conf.Conflict]
+	c.ClusterID = conf.ClusterID
+
+	// Errors are returned by validate
+	if policy, err := conf.Admission.policy(); err == nil && policy != nil {
+		c.Admission = policy
+	}
+	c.GossipKeys, _ = decodeGossipKeys(conf.GossipKeys)
+
+	if conf.TLS.enabled() {
+		c.TLS = &phi.TLSConfig{
+			CertFile: conf.TLS.CertFile,
+			KeyFile:  conf.TLS.KeyFile,
+			CAFile:   conf.TLS.CAFile,
+
+			AllowPlaintextTransports: conf.TLS.AllowPlaintextTransports,
+		}
+	}
+
+	return c
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hexablock/phi"
+)
+
+func writeTestConfig(t *testing.T, dir, name, data string) string {
+	fp := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(fp, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fp
+}
+
+func Test_loadConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "phid-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := []string{
+		writeTestConfig(t, dir, "phid.hcl", `
+data_dir = "/tmp/phid"
+peers    = ["10.0.0.1:44550"]
+replicas = 3
+votes    = 3
+
+gossip {
+  bind      = "0.0.0.0:44550"
+  advertise = "10.0.0.2:44550"
+}
+`),
+		writeTestConfig(t, dir, "phid.json", `{
+  "data_dir": "/tmp/phid",
+  "peers": ["10.0.0.1:44550"],
+  "replicas": 3,
+  "votes": 3,
+  "gossip": {"bind": "0.0.0.0:44550", "advertise": "10.0.0.2:44550"}
+}`),
+		writeTestConfig(t, dir, "phid.yaml", `
+data_dir: /tmp/phid
+peers: ["10.0.0.1:44550"]
+replicas: 3
+votes: 3
+gossip:
+  bind: 0.0.0.0:44550
+  advertise: 10.0.0.2:44550
+`),
+	}
+
+	for _, fp := range files {
+		conf := defaultConfig()
+		if err = loadConfigFile(fp, conf); err != nil {
+			t.Fatal(err)
+		}
+		if err = conf.validate(); err != nil {
+			t.Fatal(fp, err)
+		}
+
+		if conf.DataDir != "/tmp/phid" || conf.Votes != 3 || len(conf.Peers) != 1 {
+			t.Fatalf("%s: wrong config %+v", fp, conf)
+		}
+		if conf.Gossip.advertise() != "10.0.0.2:44550" {
+			t.Fatalf("%s: wrong gossip advertise %s", fp, conf.Gossip.advertise())
+		}
+		// Defaults are kept
+		if conf.Groups != 3 || conf.DHT.advertise() != "127.0.0.1:41000" {
+			t.Fatalf("%s: defaults overwritten %+v", fp, conf)
+		}
+	}
+}
+
+func Test_config_validate(t *testing.T) {
+	conf := defaultConfig()
+	if err := conf.validate(); err == nil {
+		t.Fatal("should fail without data dir")
+	}
+
+	conf.DataDir = "/tmp/phid"
+	if err := conf.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	conf.DHT.Bind = "0.0.0.0:41000"
+	if err := conf.validate(); err == nil {
+		t.Fatal("should fail without advertise address")
+	}
+	conf.DHT.Advertise = "10.0.0.2:41000"
+	if err := conf.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := applyFlag(conf, "votes", "x"); err == nil {
+		t.Fatal("should fail with invalid votes")
+	}
+	conf.Votes = 3
+	if err := conf.validate(); err == nil {
+		t.Fatal("should fail with votes greater than replicas")
+	}
+	conf.Votes = 2
+
+	if err := applyFlag(conf, "conflict", "Shutdown"); err != nil {
+		t.Fatal(err)
+	}
+	if conf.phiConfig().Conflict != phi.ConflictShutdown {
+		t.Fatal("wrong conflict strategy", conf.Conflict)
+	}
+	conf.Conflict = "ignore"
+	if err := conf.validate(); err == nil {
+		t.Fatal("should fail with unknown conflict strategy")
+	}
+	conf.Conflict = "reject"
+
+	conf.ClusterID = "prod"
+	conf.Admission.DenyIDs = []string{"0a0b"}
+	conf.Admission.AllowCIDRs = []string{"10.0.0.0/8"}
+	if pc := conf.phiConfig(); pc.ClusterID != "prod" || pc.Admission == nil {
+		t.Fatal("admission not set")
+	}
+	conf.Admission.DenyIDs = []string{"xyz"}
+	if err := conf.validate(); err == nil {
+		t.Fatal("should fail with invalid node id")
+	}
+	conf.Admission.DenyIDs = nil
+	conf.Admission.AllowCIDRs = []string{"10.0.0.1"}
+	if err := conf.validate(); err == nil {
+		t.Fatal("should fail with invalid cidr")
+	}
+	conf.Admission.AllowCIDRs = nil
+
+	conf.GossipKeys = []string{"AAECAwQFBgcICQoLDA0ODw=="}
+	if pc := conf.phiConfig(); len(pc.GossipKeys) != 1 || len(pc.GossipKeys[0]) != 16 {
+		t.Fatal("gossip keys not decoded")
+	}
+	conf.GossipKeys = []string{"AAEC"}
+	if err := conf.validate(); err == nil {
+		t.Fatal("should fail with short gossip key")
+	}
+	conf.GossipKeys = nil
+
+	if err := applyFlag(conf, "s3", "true"); err != nil {
+		t.Fatal(err)
+	}
+	if err := conf.validate(); err != errS3HTTPAddr {
+		t.Fatal("should fail with", errS3HTTPAddr, err)
+	}
+	conf.HTTPAddr = "127.0.0.1:9000"
+	if err := conf.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	conf.TLS.CertFile = "/missing/cert.pem"
+	if err := conf.validate(); err == nil {
+		t.Fatal("should fail without accepting plain text transports")
+	}
+	conf.TLS.AllowPlaintextTransports = true
+	if err := conf.validate(); err == nil {
+		t.Fatal("should fail with partial tls config")
+	}
+	conf.TLS = tlsConfig{}
+
+	conf.Tracing.Exporter = "jaeger"
+	if err := conf.validate(); err == nil {
+		t.Fatal("should fail with unknown trace exporter")
+	}
+	conf.Tracing.Exporter = "stdout"
+	conf.Tracing.SampleRatio = 2
+	if err := conf.validate(); err == nil {
+		t.Fatal("should fail with invalid sample ratio")
+	}
+	conf.Votes = 0
+	if err := conf.validate(); err == nil {
+		t.Fatal("should fail with zero votes")
+	}
+}
@@ -0,0 +1,288 @@
+// Command phid runs a phi node.  The node is configured using an optional HCL,
+// JSON or YAML config file and flags.  Flags take precedence over the file.
+//
+//	phid -config /etc/phid.hcl -peers 10.0.0.1:44550
+//
+// An example HCL config:
+//
+//	data_dir = "/var/lib/phid"
+//	peers    = ["10.0.0.1:44550"]
+//	replicas = 3
+//	votes    = 3
+//
+//	gossip {
+//	  bind      = "0.0.0.0:44550"
+//	  advertise = "10.0.0.2:44550"
+//	}
+//
+// Trace spans of WAL and block operations are exported to an OTLP collector
+// or stdout when a tracing exporter is configured:
+//
+//	tracing {
+//	  exporter = "otlp"
+//	  endpoint = "127.0.0.1:4317"
+//	}
+//
+// The S3 gateway for the object store is served on the HTTP address when
+// enabled:
+//
+//	http_addr = "127.0.0.1:9000"
+//	s3        = true
+//
+// The node leaves the cluster and shuts down gracefully on SIGINT or SIGTERM
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hexablock/blox"
+	"github.com/hexablock/hexalog"
+	"github.com/hexablock/log"
+	"github.com/hexablock/phi"
+	"github.com/hexablock/phi/kv"
+	"github.com/hexablock/phi/objstore"
+	"github.com/hexablock/phi/s3"
+)
+
+// WAL key namespace of the object store served by the S3 gateway
+const s3Namespace = "s3/"
+
+var (
+	configFile = flag.String("config", "", "Config file (.hcl, .json, .yaml)")
+
+	// Flags overriding the config file.  They are only applied if set
+	_ = flag.String("data-dir", "", "Data directory")
+	_ = flag.String("gossip-bind", "", "Gossip bind address")
+	_ = flag.String("gossip-advertise", "", "Gossip advertise address")
+	_ = flag.String("dht-bind", "", "DHT and block transport bind address")
+	_ = flag.String("dht-advertise", "", "DHT and block transport advertise address")
+	_ = flag.String("grpc-bind", "", "WAL gRPC bind address")
+	_ = flag.String("grpc-advertise", "", "WAL gRPC advertise address")
+	_ = flag.String("http-addr", "", "HTTP address")
+	_ = flag.Bool("s3", false, "Serve the S3 gateway on the HTTP address")
+	_ = flag.Bool("admin-writes", false, "Allow block writes and repairs through the admin service")
+	_ = flag.String("peers", "", "Comma separated list of existing peers to join")
+	_ = flag.Int("replicas", 0, "Block replicas")
+	_ = flag.Int("votes", 0, "WAL votes required for an entry")
+	_ = flag.Int("groups", 0, "DHT affinity groups")
+	_ = flag.String("cluster-id", "", "Cluster id.  Nodes with a different id are not admitted")
+	_ = flag.String("conflict", "", "Gossip name conflict strategy (log, reject, shutdown)")
+	_ = flag.String("tls-cert", "", "TLS certificate file for the HTTP server")
+	_ = flag.String("tls-key", "", "TLS key file for the HTTP server")
+	_ = flag.String("tls-ca", "", "TLS CA file to verify clients with")
+	_ = flag.Bool("tls-allow-plaintext-transports", false, "Accept that the gRPC, block and DHT transports are not encrypted with TLS")
+	_ = flag.String("log-level", "", "Log level (DEBUG, INFO, WARN, ERROR)")
+	_ = flag.String("trace-exporter", "", "Trace exporter (otlp, stdout).  Disabled by default")
+	_ = flag.String("trace-endpoint", "", "OTLP gRPC collector address")
+)
+
+// nopFSM accepts all entries.  The daemon only stores the log and blocks.
+// Applications read their state through the WAL
+type nopFSM struct{}
+
+func (fsm *nopFSM) Apply(entryID []byte, entry *hexalog.Entry) interface{} {
+	return nil
+}
+
+func (fsm *nopFSM) RegisterDHT(dht phi.DHT) {}
+
+func main() {
+	flag.Parse()
+
+	conf, err := loadConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	log.SetLevel(conf.LogLevel)
+
+	stopTracing, err := setupTracing(&conf.Tracing, conf.DHT.advertise())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pconf := conf.phiConfig()
+	router := phi.NewFSMRouter(&nopFSM{})
+
+	// The object store is registered with the router before the node is
+	// created so the local log is applied to its FSM on startup
+	var gw *s3Gateway
+	if conf.S3 {
+		if gw, err = newS3Gateway(router); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	node, err := phi.Create(pconf, router)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(pconf.Peers) > 0 {
+		if err = node.Join(pconf.Peers); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if gw != nil {
+		gw.start(node, pconf)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	var code int
+	select {
+	case sig := <-sigs:
+		log.Printf("[INFO] Received %s shutting down", sig)
+		if err = node.Shutdown(); err != nil {
+			log.Printf("[ERROR] Shutdown failed: %v", err)
+			os.Exit(1)
+		}
+
+	case <-node.Done():
+		// The node shut itself down e.g. after losing a name conflict.  Shutdown
+		// waits for it to complete
+		node.Shutdown()
+		log.Printf("[ERROR] Node shut down unexpectedly")
+		code = 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err = stopTracing(ctx); err != nil {
+		log.Printf("[ERROR] Failed to flush traces: %v", err)
+	}
+	cancel()
+
+	os.Exit(code)
+}
+
+// boundWAL is a phi.WAL bound to the node once it is created
+type boundWAL struct{ phi.WAL }
+
+// boundDevice is a block device bound to the node once it is created
+type boundDevice struct{ blox.BlockDevice }
+
+// s3Gateway is the S3 gateway of the object store.  The store is created
+// before the node so its kv FSM can be registered with the router, and the
+// wal and block device are bound once the node exists
+type s3Gateway struct {
+	wal boundWAL
+	dev boundDevice
+
+	store *objstore.Store
+}
+
+// newS3Gateway returns the gateway with the kv FSM of the object store
+// registered with the router
+func newS3Gateway(router *phi.FSMRouter) (*s3Gateway, error) {
+	gw := &s3Gateway{}
+
+	kvconf := kv.DefaultConfig()
+	kvconf.Namespace = []byte(s3Namespace)
+	kvs := kv.New(kvconf, &gw.wal, &gw.dev)
+	if err := router.Register(kvconf.Namespace, kvs.FSM()); err != nil {
+		return nil, err
+	}
+
+	gw.store = objstore.New(kvs, &gw.dev)
+	return gw, nil
+}
+
+// start binds the store to the node and serves the gateway on the HTTP mux
+func (gw *s3Gateway) start(node *phi.Phi, pconf *phi.Config) {
+	gw.wal.WAL = node.WAL()
+	gw.dev.BlockDevice = node.BlockDevice()
+
+	pconf.HTTPMux.Handle("/", s3.NewGateway(gw.store))
+	log.Printf("[INFO] S3 gateway started addr=%s", pconf.HTTPAddr)
+}
+
+// loadConfig returns the validated config from the defaults, config file and
+// flags in that order
+func loadConfig() (*config, error) {
+	conf := defaultConfig()
+
+	if *configFile != "" {
+		if err := loadConfigFile(*configFile, conf); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		if err == nil {
+			err = applyFlag(conf, f.Name, f.Value.String())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return conf, conf.validate()
+}
+
+// applyFlag sets the config value for a flag
+func applyFlag(conf *config, name, value string) error {
+	var err error
+
+	switch name {
+	case "data-dir":
+		conf.DataDir = value
+	case "gossip-bind":
+		conf.Gossip.Bind = value
+	case "gossip-advertise":
+		conf.Gossip.Advertise = value
+	case "dht-bind":
+		conf.DHT.Bind = value
+	case "dht-advertise":
+		conf.DHT.Advertise = value
+	case "grpc-bind":
+		conf.GRPC.Bind = value
+	case "grpc-advertise":
+		conf.GRPC.Advertise = value
+	case "http-addr":
+		conf.HTTPAddr = value
+	case "s3":
+		conf.S3, err = strconv.ParseBool(value)
+	case "admin-writes":
+		conf.AdminWrites, err = strconv.ParseBool(value)
+	case "peers":
+		conf.Peers = strings.Split(value, ",")
+	case "replicas":
+		conf.Replicas, err = strconv.Atoi(value)
+	case "votes":
+		conf.Votes, err = strconv.Atoi(value)
+	case "groups":
+		conf.Groups, err = strconv.Atoi(value)
+	case "cluster-id":
+		conf.ClusterID = value
+	case "conflict":
+		conf.Conflict = strings.ToLower(value)
+	case "tls-cert":
+		conf.TLS.CertFile = value
+	case "tls-key":
+		conf.TLS.KeyFile = value
+	case "tls-ca":
+		conf.TLS.CAFile = value
+	case "tls-allow-plaintext-transports":
+		conf.TLS.AllowPlaintextTransports, err = strconv.ParseBool(value)
+	case "log-level":
+		conf.LogLevel = strings.ToUpper(value)
+	case "trace-exporter":
+		conf.Tracing.Exporter = value
+	case "trace-endpoint":
+		conf.Tracing.Endpoint = value
+	}
+
+	return err
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+// tracingConfig configures exporting of WAL and block trace spans
+type tracingConfig struct {
+	// One of otlp or stdout.  Tracing is disabled if empty
+	Exporter string `hcl:"exporter" yaml:"exporter"`
+
+	// OTLP gRPC collector address
+	Endpoint string `hcl:"endpoint" yaml:"endpoint"`
+
+	// Fraction of traces to sample between 0 and 1
+	SampleRatio float64 `hcl:"sample_ratio" yaml:"sample_ratio"`
+}
+
+func (tc *tracingConfig) validate() error {
+	switch tc.Exporter {
+	case "", "otlp", "stdout":
+	default:
+		return fmt.Errorf("invalid trace exporter %q", tc.Exporter)
+	}
+
+	if tc.SampleRatio < 0 || tc.SampleRatio > 1 {
+		return fmt.Errorf("trace sample ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// setupTracing registers the global trace provider and propagator used by phi.
+// The returned function flushes and stops the exporter
+func setupTracing(tc *tracingConfig, node string) (func(context.Context) error, error) {
+	if tc.Exporter == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var (
+		exp sdktrace.SpanExporter
+		err error
+	)
+
+	switch tc.Exporter {
+	case "otlp":
+		exp, err = otlptracegrpc.New(context.Background(),
+			otlptracegrpc.WithEndpoint(tc.Endpoint),
+			otlptracegrpc.WithInsecure(),
+		)
+	case "stdout":
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceNameKey.String("phid"),
+		semconv.ServiceInstanceIDKey.String(node),
+	)
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(tc.SampleRatio))),
+	)
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return tp.Shutdown, nil
+}
@@ -1,9 +1,18 @@
 package phi
 
 import (
+	"crypto/ed25519"
 	"crypto/sha256"
+	"errors"
+	"fmt"
 	"hash"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 
 	"github.com/hashicorp/memberlist"
@@ -11,6 +20,61 @@ import (
 	"github.com/hexablock/hexalog"
 )
 
+var (
+	errDataDirRequired    = errors.New("data dir required")
+	errMemberlistRequired = errors.New("memberlist config required")
+	errJuryRequired       = errors.New("jury required")
+	errInvalidReplicas    = errors.New("replicas must be at least 1")
+	errInvalidVotes       = errors.New("votes must be at least 1")
+	errInvalidGroups      = errors.New("dht groups must be at least 1")
+	errInvalidConflict    = errors.New("invalid conflict strategy")
+	errInvalidGossipKey   = errors.New("gossip keys must be 16, 24 or 32 bytes")
+	errACLServer          = errors.New("acl requires the GRPCServer to be created with NewGRPCServer")
+)
+
+// ConflictStrategy determines how two nodes claiming the same gossip name are
+// handled.  The node with the lower id keeps the name unless both have the same
+// id in which case the node was restarted and the higher incarnation wins
+type ConflictStrategy uint8
+
+const (
+	// ConflictLog only logs the conflict and emits an EventConflict
+	ConflictLog ConflictStrategy = iota
+	// ConflictReject additionally rejects alive messages from the losing node
+	ConflictReject
+	// ConflictShutdown additionally shuts down the local node if it lost
+	ConflictShutdown
+)
+
+// AddrConfig is an address to listen on and the address advertised to other
+// nodes.  The advertise address defaults to the bind address
+type AddrConfig struct {
+	Bind      string
+	Advertise string
+}
+
+func (ac *AddrConfig) advertise() string {
+	if ac.Advertise != "" {
+		return ac.Advertise
+	}
+	return ac.Bind
+}
+
+// Addresses is the network config of a node.  When set on the Config the
+// memberlist, dht, block transport and hexalog addresses as well as the hexalog
+// address in the dht metadata are derived from it
+type Addresses struct {
+	// Membership and fault-tolerance
+	Gossip AddrConfig
+
+	// DHT lookups and the block transport.  The advertise address is also the
+	// node name in the gossip layer
+	DHT AddrConfig
+
+	// gRPC server for the WAL and user services
+	GRPC AddrConfig
+}
+
 // Config is the fidias config
 type Config struct {
 	// Block replicas
@@ -25,6 +89,14 @@ type Config struct {
 	// WAL parallel go-routines for seeding
 	WalSeedParallel int
 
+	// Buffered entries per watcher before it is considered lagging and closed
+	WatchBuffSize int
+
+	// Interval at which to snapshot the fsm and compact the log.  Only used if
+	// the fsm implements SnapshotFSM.  Zero disables snapshots.  Compacted
+	// entries can no longer be used to heal lagging participants
+	SnapshotInterval time.Duration
+
 	// Any existing peers. This will automatically cause the node to join the
 	// cluster
 	Peers []string
@@ -35,13 +107,77 @@ type Config struct {
 	// WAL
 	Hexalog *hexalog.Config
 
+	// WAL read options i.e. quorum reads and repair
+	WALRead *ReadOptions
+
 	DHT *kelips.Config
 
 	// Hexalog jury selection algorithm to use
 	Jury Jury
 
-	// Grpc server to allow user services to be registered
+	// How gossip name conflicts are resolved
+	Conflict ConflictStrategy
+
+	// Nodes with a different cluster id are not admitted
+	ClusterID string
+
+	// Optional policy to admit nodes into the cluster
+	Admission Admission
+
+	// Optional mutual TLS for the HTTP server.  gRPC clients can reach the
+	// gRPC server through it.  Node to node transports cannot use TLS so
+	// AllowPlaintextTransports must be set
+	TLS *TLSConfig
+
+	// Allow the admin service to write blocks and repair keys.  The admin
+	// service is not authenticated so these are rejected unless set
+	AdminWrites bool
+
+	// Optional key to sign entries proposed through the WAL with
+	SigningKey ed25519.PrivateKey
+
+	// Optional ACL for writes to log keys.  Proposals are verified by each
+	// participant before voting.  Remote proposals are verified by the
+	// GRPCServer so it must be created with NewGRPCServer
+	ACL ACL
+
+	// Verifies proposals for servers created with NewGRPCServer
+	auth       *entryAuth
+	authServer *grpc.Server
+
+	// Optional keys to encrypt gossip with.  The first key is used to encrypt
+	// and all keys to decrypt allowing keys to be rotated.  Keys can be
+	// changed at runtime using the memberlist keyring
+	GossipKeys [][]byte
+
+	// Grpc server to allow user services to be registered.  Servers created
+	// with NewGRPCServer continue traces from remote nodes and verify remote
+	// proposals
 	GRPCServer *grpc.Server
+
+	// Optional HTTP address.  The HTTP server is only started if this is set
+	HTTPAddr string
+
+	// Optional addresses for the node.  If set the individual component
+	// addresses below are derived from it
+	Addrs *Addresses
+
+	// Optional addresses to listen on for the DHT and block transport, and the
+	// gRPC server when they differ from the advertised ones e.g. behind NAT.
+	// They default to DHT.AdvertiseHost and Hexalog.AdvertiseHost
+	DHTBindAddr  string
+	GRPCBindAddr string
+
+	// HTTP mux to allow user handlers to be registered
+	HTTPMux *http.ServeMux
+
+	// Structured logger for all components.  Defaults to NewStdLogger if nil
+	Logger Logger
+
+	// Registry for metrics of all subsystems.  It is served on /metrics when
+	// an HTTP address is set and user collectors can be registered to it.  No
+	// metrics are collected if nil
+	Metrics *prometheus.Registry
 }
 
 // HashFunc returns the hash function used for the fidias as a whole.  These
@@ -56,18 +192,284 @@ func (config *Config) SetHashFunc(hf func() hash.Hash) {
 	config.DHT.HashFunc = hf
 }
 
-// DefaultConfig returns a minimally required config
+func (config *Config) dhtBindAddr() string {
+	if config.DHTBindAddr != "" {
+		return config.DHTBindAddr
+	}
+	return config.DHT.AdvertiseHost
+}
+
+func (config *Config) grpcBindAddr() string {
+	if config.GRPCBindAddr != "" {
+		return config.GRPCBindAddr
+	}
+	return config.Hexalog.AdvertiseHost
+}
+
+// Validate checks the config for missing or inconsistent values.  Addresses
+// are checked as they will be derived from Addrs.  The config is not changed.
+// It is called by Create
+func (config *Config) Validate() error {
+	return config.validate(false)
+}
+
+// validate checks the config for a cluster member or a client.  Clients store
+// nothing and serve no log so the data dir and hexalog address are not needed
+func (config *Config) validate(client bool) error {
+	if !client && config.DataDir == "" {
+		return errDataDirRequired
+	}
+	if config.Memberlist == nil && config.Addrs == nil {
+		return errMemberlistRequired
+	}
+	if config.Jury == nil {
+		return errJuryRequired
+	}
+
+	if err := config.checkAddrs(client); err != nil {
+		return err
+	}
+
+	if config.Replicas < 1 {
+		return errInvalidReplicas
+	}
+	if config.Hexalog.Votes < 1 {
+		return errInvalidVotes
+	}
+	// Log entries e.g. file-system inodes reference blocks.  They would
+	// outlive the blocks if the log was replicated to more nodes
+	if config.Hexalog.Votes > config.Replicas {
+		return fmt.Errorf("votes (%d) must not exceed replicas (%d)", config.Hexalog.Votes, config.Replicas)
+	}
+	if config.DHT.NumGroups < 1 {
+		return errInvalidGroups
+	}
+	if config.Conflict > ConflictShutdown {
+		return errInvalidConflict
+	}
+
+	if config.TLS != nil {
+		if !config.TLS.AllowPlaintextTransports {
+			return errTLSTransports
+		}
+		if err := config.TLS.Validate(); err != nil {
+			return err
+		}
+	}
+	// Remote proposals would bypass the acl
+	if !client && config.ACL != nil && (config.GRPCServer == nil || config.GRPCServer != config.authServer) {
+		return errACLServer
+	}
+	if config.SigningKey != nil && len(config.SigningKey) != ed25519.PrivateKeySize {
+		return errInvalidSigningKey
+	}
+	for _, key := range config.GossipKeys {
+		if l := len(key); l != 16 && l != 24 && l != 32 {
+			return errInvalidGossipKey
+		}
+	}
+
+	return nil
+}
+
+// clone returns a copy of the config for a node to set its derived addresses,
+// metadata and delegates on.  The dht, memberlist and hexalog configs and the
+// dht metadata are copied.  User supplied components e.g. the jury, gRPC
+// server and HTTP mux are shared
+func (config *Config) clone() *Config {
+	c := *config
+	if config.DHT != nil {
+		dht := *config.DHT
+		dht.Meta = make(map[string]string, len(config.DHT.Meta))
+		for k, v := range config.DHT.Meta {
+			dht.Meta[k] = v
+		}
+		c.DHT = &dht
+	}
+	if config.Memberlist != nil {
+		ml := *config.Memberlist
+		c.Memberlist = &ml
+	}
+	if config.Hexalog != nil {
+		hl := *config.Hexalog
+		c.Hexalog = &hl
+	}
+	return &c
+}
+
+// setupGossip sets the node metadata used to resolve gossip name conflicts and
+// for admission as well as the gossip keyring.  It is called once the config
+// is validated
+func (config *Config) setupGossip(incarnation uint64) error {
+	config.DHT.Meta[metaIncarnation] = strconv.FormatUint(incarnation, 10)
+	config.DHT.Meta[metaCluster] = config.ClusterID
+	config.DHT.Meta[metaVersion] = strconv.Itoa(ProtocolVersion)
+
+	if len(config.GossipKeys) > 0 {
+		keyring, err := memberlist.NewKeyring(config.GossipKeys, config.GossipKeys[0])
+		if err != nil {
+			return err
+		}
+		config.Memberlist.Keyring = keyring
+	}
+	return nil
+}
+
+// checkAddrs checks the component addresses as derived from Addrs if set or as
+// configured otherwise
+func (config *Config) checkAddrs(client bool) error {
+	dhtAdv, hexalogAdv := config.DHT.AdvertiseHost, config.Hexalog.AdvertiseHost
+	binds := map[string]string{"dht": config.DHTBindAddr, "grpc": config.GRPCBindAddr, "http": config.HTTPAddr}
+
+	if addrs := config.Addrs; addrs != nil {
+		if _, _, err := splitHostPort(addrs.Gossip.Bind); err != nil {
+			return fmt.Errorf("invalid gossip bind address %q: %v", addrs.Gossip.Bind, err)
+		}
+		if addrs.Gossip.Advertise != "" {
+			if _, _, err := splitHostPort(addrs.Gossip.Advertise); err != nil {
+				return fmt.Errorf("invalid gossip advertise address %q: %v", addrs.Gossip.Advertise, err)
+			}
+		}
+
+		dhtAdv, hexalogAdv = addrs.DHT.advertise(), addrs.GRPC.advertise()
+		binds["dht"], binds["grpc"] = addrs.DHT.Bind, addrs.GRPC.Bind
+
+	} else if host := config.DHT.Meta["hexalog"]; !client && host != "" && host != hexalogAdv {
+		// The jury gets the hexalog address of participants from the dht metadata
+		return fmt.Errorf("dht hexalog metadata %q does not match hexalog advertise address %q",
+			host, hexalogAdv)
+	}
+
+	if err := checkAdvertiseAddr("dht", dhtAdv); err != nil {
+		return err
+	}
+	if !client {
+		if err := checkAdvertiseAddr("hexalog", hexalogAdv); err != nil {
+			return err
+		}
+	}
+
+	for name, addr := range binds {
+		if addr == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("invalid %s address %q: %v", name, addr, err)
+		}
+	}
+
+	return nil
+}
+
+// deriveAddrs sets the memberlist, dht and hexalog addresses from Addrs if set
+// and the hexalog address in the dht metadata if not set
+func (config *Config) deriveAddrs() error {
+	if addrs := config.Addrs; addrs != nil {
+		bindHost, bindPort, err := splitHostPort(addrs.Gossip.Bind)
+		if err != nil {
+			return fmt.Errorf("invalid gossip bind address %q: %v", addrs.Gossip.Bind, err)
+		}
+
+		if config.Memberlist == nil {
+			config.Memberlist = memberlist.DefaultLANConfig()
+		}
+		c := config.Memberlist
+		c.Name = addrs.DHT.advertise()
+		c.BindAddr = bindHost
+		c.BindPort = bindPort
+
+		// Let memberlist pick a private address if none is advertised when
+		// binding to all interfaces
+		if addrs.Gossip.Advertise != "" || !isUnspecified(bindHost) {
+			advHost, advPort, err := splitHostPort(addrs.Gossip.advertise())
+			if err != nil {
+				return fmt.Errorf("invalid gossip advertise address %q: %v", addrs.Gossip.advertise(), err)
+			}
+			c.AdvertiseAddr = advHost
+			c.AdvertisePort = advPort
+		}
+
+		config.DHT.AdvertiseHost = addrs.DHT.advertise()
+		config.DHTBindAddr = addrs.DHT.Bind
+		config.Hexalog.AdvertiseHost = addrs.GRPC.advertise()
+		config.GRPCBindAddr = addrs.GRPC.Bind
+	}
+
+	if config.DHT.Meta == nil {
+		config.DHT.Meta = make(map[string]string)
+	}
+	if config.DHT.Meta["hexalog"] == "" || config.Addrs != nil {
+		config.DHT.Meta["hexalog"] = config.Hexalog.AdvertiseHost
+	}
+
+	return nil
+}
+
+// checkAdvertiseAddr checks that the address is a host and port other nodes can
+// connect to
+func checkAdvertiseAddr(name, addr string) error {
+	host, _, err := splitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid %s advertise address %q: %v", name, addr, err)
+	}
+	if host == "" || isUnspecified(host) {
+		return fmt.Errorf("%s advertise address required instead of %q", name, addr)
+	}
+	return nil
+}
+
+func isUnspecified(host string) bool {
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsUnspecified()
+}
+
+func splitHostPort(addr string) (string, int, error) {
+	host, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(p)
+	return host, port, err
+}
+
+// NewGRPCServer sets GRPCServer to a new server with the given options that
+// continues traces from remote nodes and verifies remote proposals against the
+// ACL.  Unary interceptors in opts are chained with the verification
+func (config *Config) NewGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	if config.auth == nil {
+		config.auth = &entryAuth{}
+	}
+
+	opts = append([]grpc.ServerOption{
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(config.auth.unaryInterceptor),
+	}, opts...)
+
+	config.GRPCServer = grpc.NewServer(opts...)
+	config.authServer = config.GRPCServer
+	return config.GRPCServer
+}
+
+// DefaultConfig returns a minimally required config.  Replicas defaults to 2
+// as the default 2 votes must not exceed the block replicas
 func DefaultConfig() *Config {
 	conf := &Config{
-		Replicas:        1,
+		Replicas:        2,
 		WalSeedBuffSize: 32,
 		WalSeedParallel: 2,
+		WatchBuffSize:   128,
 		Peers:           []string{},
 		Hexalog:         hexalog.DefaultConfig(""),
+		WALRead:         DefaultReadOptions(),
 		DHT:             kelips.DefaultConfig(""),
-		GRPCServer:      grpc.NewServer(),
+		HTTPMux:         http.NewServeMux(),
+		Logger:          NewStdLogger(),
+		Metrics:         prometheus.NewRegistry(),
 		Jury:            &SimpleJury{},
+		Conflict:        ConflictReject,
 	}
+	conf.NewGRPCServer()
+	conf.Metrics.MustRegister(prometheus.NewGoCollector())
 	conf.DHT.NumGroups = 3
 	conf.Hexalog.Votes = 2
 	conf.SetHashFunc(sha256.New)
@@ -0,0 +1,150 @@
+package phi
+
+import (
+	"crypto/ed25519"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+func testValidConfig() *Config {
+	conf := DefaultConfig()
+	conf.DataDir = "/tmp/phi"
+	conf.Addrs = &Addresses{
+		Gossip: AddrConfig{Bind: "0.0.0.0:44550"},
+		DHT:    AddrConfig{Bind: "0.0.0.0:41000", Advertise: "10.0.0.1:41000"},
+		GRPC:   AddrConfig{Bind: "127.0.0.1:18080"},
+	}
+	return conf
+}
+
+func Test_Config_Validate(t *testing.T) {
+	conf := testValidConfig()
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	// Validation has no side effects
+	if conf.Memberlist != nil || conf.DHT.AdvertiseHost != "" || conf.DHT.Meta["hexalog"] != "" {
+		t.Fatal("config changed by validation")
+	}
+
+	if err := conf.deriveAddrs(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.DHT.AdvertiseHost != "10.0.0.1:41000" || conf.DHTBindAddr != "0.0.0.0:41000" {
+		t.Fatal("wrong dht address", conf.DHT.AdvertiseHost, conf.DHTBindAddr)
+	}
+	if conf.Hexalog.AdvertiseHost != "127.0.0.1:18080" || conf.DHT.Meta["hexalog"] != "127.0.0.1:18080" {
+		t.Fatal("wrong hexalog address", conf.Hexalog.AdvertiseHost, conf.DHT.Meta["hexalog"])
+	}
+	ml := conf.Memberlist
+	if ml.Name != "10.0.0.1:41000" || ml.BindAddr != "0.0.0.0" || ml.BindPort != 44550 || ml.AdvertiseAddr != "" {
+		t.Fatalf("wrong memberlist config %+v", ml)
+	}
+
+	invalid := map[string]func(*Config){
+		"data dir":          func(c *Config) { c.DataDir = "" },
+		"dht advertise":     func(c *Config) { c.Addrs.DHT.Advertise = "" },
+		"gossip bind":       func(c *Config) { c.Addrs.Gossip.Bind = "0.0.0.0" },
+		"grpc advertise":    func(c *Config) { c.Addrs.GRPC.Advertise = "localhost" },
+		"votes > replicas":  func(c *Config) { c.Hexalog.Votes = 3 },
+		"zero votes":        func(c *Config) { c.Hexalog.Votes = 0 },
+		"zero groups":       func(c *Config) { c.DHT.NumGroups = 0 },
+		"no memberlist":     func(c *Config) { c.Addrs = nil },
+		"invalid http addr": func(c *Config) { c.HTTPAddr = "localhost" },
+		"conflict strategy": func(c *Config) { c.Conflict = ConflictShutdown + 1 },
+		"gossip key":        func(c *Config) { c.GossipKeys = [][]byte{[]byte("short")} },
+		"tls files":         func(c *Config) { c.TLS = &TLSConfig{CertFile: "cert.pem", AllowPlaintextTransports: true} },
+		"signing key":       func(c *Config) { c.SigningKey = ed25519.PrivateKey("short") },
+		"acl server": func(c *Config) {
+			c.ACL = NewPrefixACL()
+			c.GRPCServer = grpc.NewServer()
+		},
+	}
+
+	for name, fn := range invalid {
+		conf = testValidConfig()
+		fn(conf)
+		if err := conf.Validate(); err == nil {
+			t.Errorf("%s: should fail", name)
+		}
+	}
+
+	// Remote proposals are verified with the acl
+	conf = testValidConfig()
+	conf.ACL = NewPrefixACL()
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	conf.NewGRPCServer(grpc.MaxRecvMsgSize(1 << 20))
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Addresses set by hand must be kept in sync
+	conf = testValidConfig()
+	if err := conf.deriveAddrs(); err != nil {
+		t.Fatal(err)
+	}
+	conf.Addrs = nil
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	conf.DHT.Meta["hexalog"] = "127.0.0.1:18081"
+	if err := conf.Validate(); err == nil {
+		t.Fatal("should fail with mismatched hexalog metadata")
+	}
+}
+
+func Test_Config_validate_client(t *testing.T) {
+	conf := DefaultConfig()
+	conf.Addrs = &Addresses{
+		Gossip: AddrConfig{Bind: "0.0.0.0:44550"},
+		DHT:    AddrConfig{Bind: "0.0.0.0:41000", Advertise: "10.0.0.1:41000"},
+	}
+	// Clients need neither a data dir nor a hexalog address
+	if err := conf.validate(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := conf.Validate(); err == nil {
+		t.Fatal("members should require a data dir")
+	}
+
+	conf.Addrs.DHT.Advertise = ""
+	if err := conf.validate(true); err == nil {
+		t.Fatal("clients should require a dht advertise address")
+	}
+}
+
+func Test_Config_clone(t *testing.T) {
+	conf := testValidConfig()
+	conf.GossipKeys = [][]byte{make([]byte, 16)}
+	conf.DHT.Meta["app"] = "value"
+
+	c := conf.clone()
+	if err := c.deriveAddrs(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.setupGossip(1); err != nil {
+		t.Fatal(err)
+	}
+	c.Hexalog.Votes = 1
+
+	// Node values are only set on the copy
+	if c.DHT.Meta["app"] != "value" || c.DHT.Meta[metaIncarnation] == "" || c.Memberlist.Keyring == nil {
+		t.Fatal("copy not set up", c.DHT.Meta)
+	}
+	if _, ok := conf.DHT.Meta[metaIncarnation]; ok || conf.DHT.Meta["hexalog"] != "" || conf.DHT.AdvertiseHost != "" {
+		t.Fatal("dht config changed", conf.DHT.Meta)
+	}
+	if conf.Memberlist != nil || conf.Hexalog.Votes != 2 {
+		t.Fatal("config changed")
+	}
+
+	// An existing memberlist config is copied
+	conf.Memberlist = c.Memberlist
+	conf.Memberlist.Keyring = nil
+	if c2 := conf.clone(); c2.setupGossip(1) != nil || conf.Memberlist.Keyring != nil {
+		t.Fatal("memberlist config changed")
+	}
+}
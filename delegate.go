@@ -8,7 +8,6 @@ import (
 
 	"github.com/hexablock/go-kelips"
 	"github.com/hexablock/hexatype"
-	"github.com/hexablock/log"
 	"github.com/hexablock/vivaldi"
 )
 
@@ -33,14 +32,44 @@ type delegate struct {
 	// Message broadcast buffer
 	mu         sync.RWMutex
 	broadcasts [][]byte
+
+	// Node ids of nodes that have left or failed
+	dmu  sync.RWMutex
+	dead map[string]struct{}
+
+	// Conflict strategy and the address of the losing node by name
+	conflict ConflictStrategy
+	cmu      sync.RWMutex
+	rejected map[string]string
+
+	// Called when the local node lost a conflict and should shut down
+	shutdown func()
+
+	// Optional admission policy for remote nodes
+	admission Admission
+
+	metrics *metrics
+	events  *eventBus
+	logger  Logger
 }
 
-func (del *delegate) NotifyConflict(n1 *memberlist.Node, n2 *memberlist.Node) {
-	log.Println("NotifyConflict", n1, n2)
+// IsDead returns true if the node with the given id has left or failed.  It
+// satisfies the Liveness interface
+func (del *delegate) IsDead(id []byte) bool {
+	del.dmu.RLock()
+	_, ok := del.dead[string(id)]
+	del.dmu.RUnlock()
+	return ok
 }
 
-func (del *delegate) NotifyAlive(node *memberlist.Node) error {
-	return nil
+func (del *delegate) setDead(id []byte, dead bool) {
+	del.dmu.Lock()
+	if dead {
+		del.dead[string(id)] = struct{}{}
+	} else {
+		delete(del.dead, string(id))
+	}
+	del.dmu.Unlock()
 }
 
 // NodeMeta is used to retrieve meta-data about the current node
@@ -55,7 +84,7 @@ func (del *delegate) NodeMeta(limit int) []byte {
 		//log.Printf("[DEBUG] NodeMeta: size=%d/%d", len(b), limit)
 		return b
 	}
-	log.Printf("[ERROR] NodeMeta: %v", err)
+	del.logger.Error("Failed to encode node metadata", "err", err)
 	return nil
 }
 
@@ -73,17 +102,19 @@ func (del *delegate) NotifyMsg(msg []byte) {
 
 	switch typ {
 	case msgTypeInsertKey:
+		del.metrics.gossipMsg("insert_key")
 		tuple := kelips.TupleHost(msg[1:19])
 		key := msg[19:]
 		// Perform a single insert
 		err = del.dht.Insert(key, tuple)
 
 	default:
-		log.Println("[DEBUG] NotifyMsg:", msg)
+		del.metrics.gossipMsg("unknown")
+		del.logger.Debug("Unknown gossip message", "type", typ, "size", len(msg))
 	}
 
 	if err != nil {
-		log.Println("[ERROR] NotifyMsg", err)
+		del.logger.Error("Failed to handle gossip message", "type", typ, "err", err)
 	}
 
 }
@@ -98,7 +129,7 @@ func (del *delegate) LocalState(join bool) []byte {
 		snapshot := del.dht.Snapshot()
 		b, err := proto.Marshal(snapshot)
 		if err != nil {
-			log.Println("[ERROR]", err)
+			del.logger.Error("Failed to encode dht snapshot", "err", err)
 			return nil
 		}
 
@@ -150,19 +181,27 @@ func (del *delegate) GetBroadcasts(overhead, limit int) [][]byte {
 	return out
 }
 
+// queueLen returns the number of messages waiting to be broadcast
+func (del *delegate) queueLen() int {
+	del.mu.RLock()
+	defer del.mu.RUnlock()
+	return len(del.broadcasts)
+}
+
 func (del *delegate) seedDHT(buf []byte) {
 	// Unmarshal snapshot
 	var ss kelips.Snapshot
 	err := proto.Unmarshal(buf, &ss)
 	if err != nil {
-		log.Println("[ERROR]", err)
+		del.logger.Error("Failed to decode dht snapshot", "err", err)
 		return
 	}
 
 	if err = del.dht.Seed(&ss); err != nil {
-		log.Println("[ERROR] Failed to seed snapshot:", err)
-		return
+		del.logger.Error("Failed to seed dht", "err", err)
+	} else {
+		del.logger.Info("DHT seeded", "tuples", len(ss.Tuples), "nodes", len(ss.Nodes))
 	}
 
-	log.Printf("[INFO] DHT seeded tuples=%d nodes=%d", len(ss.Tuples), len(ss.Nodes))
+	del.events.publish(&Event{Type: EventDHTSeeded, Count: len(ss.Tuples), Err: err})
 }
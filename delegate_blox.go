@@ -3,7 +3,6 @@ package phi
 import (
 	"github.com/hexablock/blox/device"
 	kelips "github.com/hexablock/go-kelips"
-	"github.com/hexablock/log"
 )
 
 // BlockSet is the blox delegate called when new blocks are set.  It  handles
@@ -11,11 +10,13 @@ import (
 func (phi *Phi) BlockSet(index device.IndexEntry) {
 	// Update DHT
 	tuple := kelips.TupleHost(phi.local.Address)
-	if err := phi.dht.Insert(index.ID(), tuple); err != nil {
-		log.Printf("[ERROR] Failed to insert to dht: %s", err)
-		return
+	err := phi.mdht.Insert(index.ID(), tuple)
+	if err != nil {
+		phi.logger.Error("Failed to insert block to dht", "id", hexID(index.ID()), "err", err)
 	}
 
+	phi.events.publish(&Event{Type: EventBlockStored, ID: index.ID(), Err: err})
+
 	//
 	// TODO: trigger block processing
 }
@@ -24,11 +25,13 @@ func (phi *Phi) BlockSet(index device.IndexEntry) {
 func (phi *Phi) BlockRemove(id []byte) {
 	// Update DHT
 	tuple := kelips.TupleHost(phi.local.Address)
-	if err := phi.dht.Delete(id, tuple); err != nil {
-		log.Printf("[ERROR] Failed to delete from dht: %s", err)
-		return
+	err := phi.mdht.Delete(id, tuple)
+	if err != nil {
+		phi.logger.Error("Failed to delete block from dht", "id", hexID(id), "err", err)
 	}
 
+	phi.events.publish(&Event{Type: EventBlockRemoved, ID: id, Err: err})
+
 	//
 	// TODO: trigger block processing
 }
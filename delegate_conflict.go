@@ -0,0 +1,134 @@
+package phi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/memberlist"
+
+	"github.com/hexablock/hexatype"
+)
+
+// Metadata key holding the incarnation of a node.  It is a lamport time
+// persisted in the data dir and incremented each time the node starts
+const metaIncarnation = "incarnation"
+
+var (
+	errConflictRejected = errors.New("node lost name conflict")
+	errConflictLost     = errors.New("local node lost name conflict")
+)
+
+// nextIncarnation returns the incarnation of the node for this start.  The last
+// incarnation is read from the data dir and witnessed by the clock so the new
+// one is greater without relying on wall clocks.  Nothing is persisted if dir
+// is empty
+func nextIncarnation(dir string, ltime *hexatype.LamportClock) (uint64, error) {
+	if dir == "" {
+		return uint64(ltime.Increment()), nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+
+	fpath := filepath.Join(dir, "incarnation")
+	b, err := ioutil.ReadFile(fpath)
+	if err == nil {
+		last, er := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+		if er != nil {
+			return 0, fmt.Errorf("invalid incarnation in %s: %v", fpath, er)
+		}
+		ltime.Witness(hexatype.LamportTime(last))
+	} else if !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	inc := uint64(ltime.Increment())
+	return inc, ioutil.WriteFile(fpath, []byte(strconv.FormatUint(inc, 10)), 0644)
+}
+
+// incarnation returns the incarnation of the node from its metadata or 0 if
+// not available
+func incarnation(node *hexatype.Node) uint64 {
+	i, _ := strconv.ParseUint(node.Metadata()[metaIncarnation], 10, 64)
+	return i
+}
+
+// existingWins returns true if the existing node keeps the name in a conflict.
+// The same id on a new address is a restart so the higher incarnation wins
+// with the existing node winning ties.  Incarnations of different nodes are
+// not comparable so otherwise the lower id wins
+func existingWins(existing, other *hexatype.Node) bool {
+	if bytes.Equal(existing.ID, other.ID) {
+		return incarnation(existing) >= incarnation(other)
+	}
+	return bytes.Compare(existing.ID, other.ID) < 0
+}
+
+// isLocal returns true if the node is this instance of the local node
+func (del *delegate) isLocal(node *hexatype.Node) bool {
+	return bytes.Equal(node.ID, del.local.ID) && incarnation(node) == incarnation(&del.local)
+}
+
+// NotifyConflict is called when a node with the same name as an existing one
+// but a different address tries to join.  It resolves the conflict based on
+// the configured strategy.  A node whose metadata cannot be decoded loses
+func (del *delegate) NotifyConflict(existing *memberlist.Node, other *memberlist.Node) {
+	winner, loser := existing, other
+
+	var enode, onode hexatype.Node
+	lnode := &onode
+	if err := proto.Unmarshal(existing.Meta, &enode); err != nil {
+		winner, loser, lnode = other, existing, nil
+	} else if err = proto.Unmarshal(other.Meta, &onode); err != nil {
+		lnode = nil
+	} else if !existingWins(&enode, &onode) {
+		winner, loser, lnode = other, existing, &enode
+	}
+
+	ev := &Event{Type: EventConflict, Node: lnode, Name: loser.Name, Host: loser.Address()}
+
+	switch {
+	case lnode != nil && del.isLocal(lnode):
+		ev.Err = errConflictLost
+		del.logger.Error("Local node lost name conflict", "node", loser.Name, "winner", winner.Address())
+		if del.conflict == ConflictShutdown && del.shutdown != nil {
+			go del.shutdown()
+		}
+
+	case del.conflict >= ConflictReject:
+		del.cmu.Lock()
+		del.rejected[loser.Name] = loser.Address()
+		del.cmu.Unlock()
+		del.logger.Warn("Rejecting node with conflicting name", "node", loser.Name, "host", loser.Address(),
+			"winner", winner.Address())
+
+	default:
+		del.logger.Warn("Node name conflict", "node", loser.Name, "host", loser.Address(),
+			"winner", winner.Address())
+	}
+
+	del.events.publish(ev)
+}
+
+// isRejected returns true if the node lost a name conflict
+func (del *delegate) isRejected(node *memberlist.Node) bool {
+	del.cmu.RLock()
+	addr, ok := del.rejected[node.Name]
+	del.cmu.RUnlock()
+
+	return ok && addr == node.Address()
+}
+
+// clearConflict allows the name to be claimed again once the winner has left
+func (del *delegate) clearConflict(name string) {
+	del.cmu.Lock()
+	delete(del.rejected, name)
+	del.cmu.Unlock()
+}
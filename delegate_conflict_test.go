@@ -0,0 +1,164 @@
+package phi
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/memberlist"
+
+	"github.com/hexablock/hexatype"
+)
+
+func testConflictNode(t *testing.T, ip, id string) *memberlist.Node {
+	meta, err := proto.Marshal(&hexatype.Node{ID: []byte(id)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &memberlist.Node{Name: "node", Addr: net.ParseIP(ip), Port: 44550, Meta: meta}
+}
+
+func testConflictDelegate(localID string, strategy ConflictStrategy) *delegate {
+	return &delegate{
+		local:    hexatype.Node{ID: []byte(localID)},
+		conflict: strategy,
+		rejected: make(map[string]string),
+		events:   newEventBus(),
+		logger:   NewStdLogger(),
+	}
+}
+
+func testIncarnationNode(id string, inc string) *hexatype.Node {
+	return &hexatype.Node{ID: []byte(id), Meta: map[string]string{metaIncarnation: inc}}
+}
+
+func Test_existingWins(t *testing.T) {
+	a := &hexatype.Node{ID: []byte("a")}
+	b := &hexatype.Node{ID: []byte("b")}
+
+	if !existingWins(a, b) || existingWins(b, a) {
+		t.Fatal("lower id should win")
+	}
+	if !existingWins(a, &hexatype.Node{ID: []byte("a")}) {
+		t.Fatal("existing should win with the same incarnation")
+	}
+
+	// Restarts
+	if existingWins(testIncarnationNode("a", "1"), testIncarnationNode("a", "2")) {
+		t.Fatal("higher incarnation should win")
+	}
+	if !existingWins(testIncarnationNode("a", "2"), testIncarnationNode("a", "1")) {
+		t.Fatal("lower incarnation should lose")
+	}
+	// Incarnations of different nodes are ignored
+	if existingWins(testIncarnationNode("b", "1"), testIncarnationNode("a", "2")) {
+		t.Fatal("lower id should win")
+	}
+}
+
+func Test_nextIncarnation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "phi-incarnation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i := uint64(1); i <= 3; i++ {
+		ltime := &hexatype.LamportClock{}
+		inc, err := nextIncarnation(dir, ltime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if inc != i || uint64(ltime.Time()) != i {
+			t.Fatal("wrong incarnation", inc, ltime.Time())
+		}
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(dir, "incarnation"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = nextIncarnation(dir, &hexatype.LamportClock{}); err == nil {
+		t.Fatal("should fail")
+	}
+
+	if inc, _ := nextIncarnation("", &hexatype.LamportClock{}); inc != 1 {
+		t.Fatal("wrong incarnation", inc)
+	}
+}
+
+func Test_delegate_NotifyConflict(t *testing.T) {
+	del := testConflictDelegate("local", ConflictReject)
+	sub := del.events.subscribe(1, []EventType{EventConflict})
+
+	existing := testConflictNode(t, "10.0.0.1", "a")
+	other := testConflictNode(t, "10.0.0.2", "b")
+	del.NotifyConflict(existing, other)
+
+	if err := del.NotifyAlive(other); err != errConflictRejected {
+		t.Fatal("should reject loser", err)
+	}
+	if err := del.NotifyAlive(existing); err != nil {
+		t.Fatal(err)
+	}
+
+	ev := <-sub.C
+	if ev.Host != other.Address() || string(ev.Node.ID) != "b" || ev.Err != nil {
+		t.Fatal("wrong event", ev)
+	}
+
+	// Name can be claimed again once the winner left
+	del.clearConflict(existing.Name)
+	if err := del.NotifyAlive(other); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_delegate_NotifyConflict_local(t *testing.T) {
+	del := testConflictDelegate("b", ConflictShutdown)
+
+	done := make(chan struct{})
+	del.shutdown = func() { close(done) }
+
+	other := testConflictNode(t, "10.0.0.2", "b")
+	del.NotifyConflict(testConflictNode(t, "10.0.0.1", "a"), other)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("should shut down")
+	}
+	// Never reject the local node
+	if err := del.NotifyAlive(other); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_delegate_NotifyConflict_invalidMeta(t *testing.T) {
+	invalid := &memberlist.Node{Name: "node", Addr: net.ParseIP("10.0.0.3"), Port: 44550, Meta: []byte{0xff}}
+
+	// The node with undecodable metadata loses whether it is the existing or
+	// the joining node
+	for _, tc := range []struct {
+		existing, other *memberlist.Node
+	}{
+		{invalid, testConflictNode(t, "10.0.0.2", "b")},
+		{testConflictNode(t, "10.0.0.1", "a"), invalid},
+	} {
+		del := testConflictDelegate("local", ConflictReject)
+		sub := del.events.subscribe(1, []EventType{EventConflict})
+
+		del.NotifyConflict(tc.existing, tc.other)
+
+		ev := <-sub.C
+		if ev.Host != invalid.Address() || ev.Node != nil {
+			t.Fatal("wrong event", ev.Host, ev.Node)
+		}
+		if err := del.NotifyAlive(invalid); err != errConflictRejected {
+			t.Fatal("should reject loser", err)
+		}
+	}
+}
@@ -5,45 +5,66 @@ import (
 	"github.com/hashicorp/memberlist"
 
 	"github.com/hexablock/hexatype"
-	"github.com/hexablock/log"
 )
 
-// NotifyJoin adds the newly joined node to the kelips dht
+// NotifyJoin adds the newly joined node to the kelips dht unless it is a
+// client
 func (del *delegate) NotifyJoin(node *memberlist.Node) {
 
 	var remoteNode hexatype.Node
 	err := proto.Unmarshal(node.Meta, &remoteNode)
 	if err != nil {
-		log.Println("[ERROR]", err)
+		del.logger.Error("Failed to decode node metadata", "node", node.Name, "err", err)
 		return
 	}
 
-	if err = del.dht.AddNode(&remoteNode, true); err != nil {
-		log.Println("[ERROR]", err)
-		return
+	del.setDead(remoteNode.ID, false)
+
+	// Clients neither store blocks nor vote so they are kept out of the dht
+	if isClient(&remoteNode) {
+		del.logger.Info("Client joined", "node", node.Name, "host", remoteNode.Host())
+	} else if err = del.dht.AddNode(&remoteNode, true); err != nil {
+		del.logger.Error("Failed to add node to dht", "node", node.Name, "host", remoteNode.Host(), "err", err)
+	} else {
+		del.logger.Info("Node joined", "node", node.Name, "host", remoteNode.Host(),
+			"region", remoteNode.Region, "sector", remoteNode.Sector, "zone", remoteNode.Zone)
 	}
 
-	log.Printf("[INFO] Node joined host=%s region=%s sector=%s zone=%s",
-		remoteNode.Host(), remoteNode.Region, remoteNode.Sector, remoteNode.Zone)
+	del.events.publish(&Event{Type: EventNodeJoined, Node: &remoteNode, Host: remoteNode.Host(), Err: err})
 }
 
+// NotifyUpdate is called when the metadata of a node changes
 func (del *delegate) NotifyUpdate(node *memberlist.Node) {
-	// TODO
+	var remoteNode hexatype.Node
+	err := proto.Unmarshal(node.Meta, &remoteNode)
+	if err != nil {
+		del.logger.Error("Failed to decode node metadata", "node", node.Name, "err", err)
+		return
+	}
 
-	log.Println("NotifyUpdate")
+	del.logger.Debug("Node updated", "node", node.Name, "host", remoteNode.Host())
+	del.events.publish(&Event{Type: EventNodeUpdated, Node: &remoteNode, Host: remoteNode.Host()})
 }
 
+// NotifyLeave marks the node dead and removes it from the dht
 func (del *delegate) NotifyLeave(node *memberlist.Node) {
 	var remoteNode hexatype.Node
 	err := proto.Unmarshal(node.Meta, &remoteNode)
 	if err != nil {
-		log.Println("[ERROR]", err)
+		del.logger.Error("Failed to decode node metadata", "node", node.Name, "err", err)
 		return
 	}
 
-	if err = del.dht.RemoveNode(remoteNode.Host()); err != nil {
-		log.Println("[ERROR] NotifyLeave Failed to remove node:", err)
+	del.setDead(remoteNode.ID, true)
+	del.clearConflict(node.Name)
+
+	if isClient(&remoteNode) {
+		del.logger.Info("Client left", "node", node.Name, "host", remoteNode.Host())
+	} else if err = del.dht.RemoveNode(remoteNode.Host()); err != nil {
+		del.logger.Error("Failed to remove node from dht", "node", node.Name, "host", remoteNode.Host(), "err", err)
+	} else {
+		del.logger.Info("Node left", "node", node.Name, "host", remoteNode.Host())
 	}
 
-	log.Println("NotifyLeave", node.Name)
+	del.events.publish(&Event{Type: EventNodeLeft, Node: &remoteNode, Host: remoteNode.Host(), Err: err})
 }
@@ -9,7 +9,6 @@ import (
 
 	"github.com/hexablock/go-kelips"
 	"github.com/hexablock/hexatype"
-	"github.com/hexablock/log"
 	"github.com/hexablock/vivaldi"
 )
 
@@ -23,7 +22,7 @@ func (del *delegate) AckPayload() []byte {
 	coord := del.coord.GetCoordinate()
 	b, err := proto.Marshal(coord)
 	if err != nil {
-		log.Println("[ERROR] Failed marshal coordinate:", err)
+		del.logger.Error("Failed to encode coordinate", "err", err)
 	}
 	return append(ltb, b...)
 }
@@ -34,15 +33,17 @@ func (del *delegate) updateCoords(id string, other *vivaldi.Coordinate, rtt time
 		return err
 	}
 
-	// Update local coordinates
-	tuple := kelips.TupleHost(del.local.Address)
-	if err = del.dht.PingNode(tuple.String(), local, 0); err != nil {
-		log.Println("[ERROR]", err)
+	// Update local coordinates.  Clients are not part of their own dht
+	if !isClient(&del.local) {
+		tuple := kelips.TupleHost(del.local.Address)
+		if err = del.dht.PingNode(tuple.String(), local, 0); err != nil {
+			del.logger.Error("Failed to update local coordinate", "host", tuple.String(), "err", err)
+		}
 	}
 
 	// Update remote coordinates
 	if err = del.dht.PingNode(id, other, rtt); err != nil {
-		log.Println("[ERROR]", err)
+		del.logger.Error("Failed to update remote coordinate", "host", id, "err", err)
 	}
 
 	return err
@@ -58,21 +59,22 @@ func (del *delegate) NotifyPingComplete(node *memberlist.Node, rtt time.Duration
 	var other vivaldi.Coordinate
 	err := proto.Unmarshal(payload[8:], &other)
 	if err != nil {
-		log.Println("[ERROR] Failed marshal coordinate:", err)
+		del.logger.Error("Failed to decode coordinate", "node", node.Name, "err", err)
 		return
 	}
 
 	var remoteNode hexatype.Node
 	if err = proto.Unmarshal(node.Meta, &remoteNode); err != nil {
-		log.Println("[ERROR]", err)
+		del.logger.Error("Failed to decode node metadata", "node", node.Name, "err", err)
 		return
 	}
 
-	if rtt > 0 {
+	// Clients are not in the dht
+	if rtt > 0 && !isClient(&remoteNode) {
 		//tuple := kelips.TupleHost(remoteNode.Address)
 		//if err = phi.updateCoords(tuple.String(), other.Clone(), rtt); err != nil {
 		if err = del.updateCoords(remoteNode.Host(), other.Clone(), rtt); err != nil {
-			log.Println("[ERROR]", err)
+			del.logger.Error("Coordinate update failed", "host", remoteNode.Host(), "err", err)
 		}
 	}
 }
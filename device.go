@@ -1,16 +1,21 @@
 package phi
 
 import (
+	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"hash"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/hexablock/blox"
 	"github.com/hexablock/blox/block"
 	"github.com/hexablock/blox/device"
 	kelips "github.com/hexablock/go-kelips"
 	"github.com/hexablock/hexatype"
-	"github.com/hexablock/log"
 )
 
 var errBloxAddrMissing = errors.New("blox address missing")
@@ -36,8 +41,13 @@ type BlockDevice struct {
 	dev *device.BlockDevice
 
 	// Blox transport. This can be either LocalNetTransport for cluster members
-	// or a blox.NetClient one for clients
+	// or a blox.NetTransport for clients
 	trans blox.Transport
+
+	// Optional metrics
+	metrics *metrics
+
+	logger Logger
 }
 
 // NewBlockDevice inits a new Device that implements a BlockDevice that is
@@ -50,6 +60,7 @@ func NewBlockDevice(replicas int, hashFunc func() hash.Hash, local hexatype.Node
 		idx:      idx,
 		hashFunc: hashFunc,
 		trans:    trans,
+		logger:   NewStdLogger(),
 	}
 }
 
@@ -76,7 +87,7 @@ func (dev *BlockDevice) advertiseBlocks() {
 	dev.idx.Iter(func(index *device.IndexEntry) error {
 		tuple := kelips.TupleHost(dev.local.Address)
 		if err := dev.dht.Insert(index.ID(), tuple); err != nil {
-			log.Printf("[ERROR] Failed to insert to dht: %s", err)
+			dev.logger.Error("Failed to advertise block", "id", hexID(index.ID()), "err", err)
 		}
 		return nil
 	})
@@ -94,12 +105,29 @@ func (dev *BlockDevice) Hasher() func() hash.Hash {
 
 // BlockExists returns true if the block exists on any one of the assigned nodes
 func (dev *BlockDevice) BlockExists(id []byte) (bool, error) {
+	return dev.BlockExistsContext(context.Background(), id)
+}
+
+// BlockExistsContext is the same as BlockExists but starts the span from the
+// context and stops checking hosts when the context is done
+func (dev *BlockDevice) BlockExistsContext(ctx context.Context, id []byte) (bool, error) {
+	ctx, span := startBlockSpan(ctx, "BlockDevice.BlockExists", id)
+	defer span.End()
+
 	nodes, err := dev.dht.Lookup(id)
 	if err != nil {
+		endSpan(span, err)
 		return false, err
 	}
 	for _, node := range nodes {
-		if ok, err := dev.trans.BlockExists(node.Host(), id); err == nil && ok {
+		if err = ctx.Err(); err != nil {
+			endSpan(span, err)
+			return false, err
+		}
+		_, hspan := startBlockSpan(ctx, "BlockDevice.BlockExists.host", id, attribute.String("phi.host", node.Host()))
+		ok, err := dev.trans.BlockExists(node.Host(), id)
+		endSpan(hspan, err)
+		if err == nil && ok {
 			return true, nil
 		}
 	}
@@ -108,6 +136,16 @@ func (dev *BlockDevice) BlockExists(id []byte) (bool, error) {
 
 // SetBlock writes the block to the device
 func (dev *BlockDevice) SetBlock(blk block.Block) ([]byte, error) {
+	return dev.SetBlockContext(context.Background(), blk)
+}
+
+// SetBlockContext is the same as SetBlock but starts the span from the context
+// and stops writing replicas when the context is done
+func (dev *BlockDevice) SetBlockContext(ctx context.Context, blk block.Block) (id []byte, err error) {
+	ctx, span := startBlockSpan(ctx, "BlockDevice.SetBlock", blk.ID(),
+		attribute.Int64("phi.block_size", int64(blk.Size())))
+	defer func() { endSpan(span, err) }()
+
 	nodes, err := dev.dht.LookupNodes(blk.ID(), dev.replicas)
 	if err != nil {
 		return nil, err
@@ -116,20 +154,34 @@ func (dev *BlockDevice) SetBlock(blk block.Block) ([]byte, error) {
 		return nil, fmt.Errorf("no peers found")
 	}
 
-	var id []byte
+	var written int
 	for _, loc := range nodes {
+		if er := ctx.Err(); er != nil {
+			err = er
+			break
+		}
 
+		_, hspan := startBlockSpan(ctx, "BlockDevice.SetBlock.host", blk.ID(), attribute.String("phi.host", loc.Host()))
+		start := time.Now()
 		bid, er := dev.trans.SetBlock(loc.Host(), blk)
+		dev.metrics.blockOp("set", loc.Host(), start, blk.Size(), er)
+		endSpan(hspan, er)
 		if er != nil {
 			err = er
 		} else {
 			// Latest set block id
 			id = bid
-			log.Printf("[INFO] Block set id=%x host=%s", id, loc.Host())
+			written++
+			dev.logger.Info("Block set", "id", hexID(id), "host", loc.Host())
 		}
 
 	}
 
+	if written < dev.replicas {
+		dev.metrics.replicaShortfall()
+		span.AddEvent("replica shortfall", trace.WithAttributes(attribute.Int("phi.replicas", written)))
+	}
+
 	//log.Printf("[DEBUG] Device.SetBlock id=%x type=%s replicas=%d error='%v'",
 	//	blk.ID(), blk.Type(), len(nodes), err)
 
@@ -138,17 +190,34 @@ func (dev *BlockDevice) SetBlock(blk block.Block) ([]byte, error) {
 
 // GetBlock gets a block from the device
 func (dev *BlockDevice) GetBlock(id []byte) (block.Block, error) {
+	return dev.GetBlockContext(context.Background(), id)
+}
+
+// GetBlockContext is the same as GetBlock but starts the span from the context
+// and stops trying hosts when the context is done
+func (dev *BlockDevice) GetBlockContext(ctx context.Context, id []byte) (blk block.Block, err error) {
+	ctx, span := startBlockSpan(ctx, "BlockDevice.GetBlock", id)
+	defer func() { endSpan(span, err) }()
+
 	locs, err := dev.dht.Lookup(id)
 	if err != nil {
 		return nil, err
 	}
 
-	var blk block.Block
 	for _, loc := range locs {
+		if er := ctx.Err(); er != nil {
+			return nil, er
+		}
 
+		_, hspan := startBlockSpan(ctx, "BlockDevice.GetBlock.host", id, attribute.String("phi.host", loc.Host()))
+		start := time.Now()
 		if blk, err = dev.trans.GetBlock(loc.Host(), id); err == nil {
+			dev.metrics.blockOp("get", loc.Host(), start, blk.Size(), nil)
+			endSpan(hspan, nil)
 			return blk, nil
 		}
+		dev.metrics.blockOp("get", loc.Host(), start, 0, err)
+		endSpan(hspan, err)
 
 	}
 
@@ -157,14 +226,29 @@ func (dev *BlockDevice) GetBlock(id []byte) (block.Block, error) {
 
 // RemoveBlock submits a request to remove a block on the device and all replicas
 func (dev *BlockDevice) RemoveBlock(id []byte) error {
+	return dev.RemoveBlockContext(context.Background(), id)
+}
+
+// RemoveBlockContext is the same as RemoveBlock but starts the span from the
+// context and stops removing replicas when the context is done
+func (dev *BlockDevice) RemoveBlockContext(ctx context.Context, id []byte) (err error) {
+	ctx, span := startBlockSpan(ctx, "BlockDevice.RemoveBlock", id)
+	defer func() { endSpan(span, err) }()
+
 	locs, err := dev.dht.Lookup(id)
 	if err != nil {
 		return err
 	}
 
 	for _, loc := range locs {
+		if er := ctx.Err(); er != nil {
+			return er
+		}
 
-		if er := dev.trans.RemoveBlock(loc.Host(), id); er != nil {
+		_, hspan := startBlockSpan(ctx, "BlockDevice.RemoveBlock.host", id, attribute.String("phi.host", loc.Host()))
+		er := dev.trans.RemoveBlock(loc.Host(), id)
+		endSpan(hspan, er)
+		if er != nil {
 			err = er
 		}
 
@@ -173,6 +257,15 @@ func (dev *BlockDevice) RemoveBlock(id []byte) error {
 	return err
 }
 
+// startBlockSpan starts a span for a block operation.  Operations without a
+// context start a new trace.  The blox transport does not take a context so
+// the trace ends with the span per host and is not continued by the remote
+// node
+func startBlockSpan(ctx context.Context, name string, id []byte, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, attribute.String("phi.block_id", hex.EncodeToString(id)))
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
 // Close shutdowns the underlying network transport
 func (dev *BlockDevice) Close() error {
 	return dev.trans.Shutdown()
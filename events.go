@@ -0,0 +1,201 @@
+package phi
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hexablock/hexatype"
+)
+
+// EventType is the type of a cluster event
+type EventType uint8
+
+const (
+	// EventNodeJoined is emitted when a node joins the gossip cluster
+	EventNodeJoined EventType = iota + 1
+	// EventNodeLeft is emitted when a node leaves or fails
+	EventNodeLeft
+	// EventNodeUpdated is emitted when the metadata of a node changes
+	EventNodeUpdated
+	// EventConflict is emitted when two nodes claim the same gossip name.  The
+	// node fields are those of the losing node and Err is set if it is the
+	// local node
+	EventConflict
+	// EventBlockStored is emitted when a block is stored on the local device
+	EventBlockStored
+	// EventBlockRemoved is emitted when a block is removed from the local device
+	EventBlockRemoved
+	// EventDHTSeeded is emitted when the dht is seeded from a peer on join
+	EventDHTSeeded
+	// EventEntryApplied is emitted when a log entry is applied to the local fsm
+	EventEntryApplied
+	// EventProposalFailed is emitted when an entry proposal to a participant
+	// fails
+	EventProposalFailed
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventNodeJoined:
+		return "NodeJoined"
+	case EventNodeLeft:
+		return "NodeLeft"
+	case EventNodeUpdated:
+		return "NodeUpdated"
+	case EventConflict:
+		return "Conflict"
+	case EventBlockStored:
+		return "BlockStored"
+	case EventBlockRemoved:
+		return "BlockRemoved"
+	case EventDHTSeeded:
+		return "DHTSeeded"
+	case EventEntryApplied:
+		return "EntryApplied"
+	case EventProposalFailed:
+		return "ProposalFailed"
+	}
+	return "Unknown"
+}
+
+// Event is a cluster event.  Only the fields relevant to the type are set
+type Event struct {
+	Type EventType
+	Time time.Time
+
+	// Node for node events.  nil if the node metadata could not be decoded
+	Node *hexatype.Node
+
+	// Gossip name and address of the losing node for conflicts
+	Name string
+	Host string
+
+	// Log key, entry id and height for entry events.  ID is the block id for
+	// block events
+	Key    []byte
+	ID     []byte
+	Height uint32
+
+	// Number of dht tuples seeded
+	Count int
+
+	// Error that occurred handling the event if any
+	Err error
+}
+
+// Subscription receives events of the subscribed types on C.  Events are
+// dropped if C is not read fast enough.  C is closed when the subscription is
+// closed or phi is shut down
+type Subscription struct {
+	// Accessed atomically.  First for alignment on 32-bit platforms
+	dropped uint64
+
+	C <-chan *Event
+
+	ch    chan *Event
+	types map[EventType]bool
+
+	bus  *eventBus
+	once sync.Once
+}
+
+// Dropped returns the number of events dropped because the buffer was full
+func (sub *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&sub.dropped)
+}
+
+// Close stops delivery of events and closes C
+func (sub *Subscription) Close() {
+	sub.once.Do(func() {
+		sub.bus.remove(sub)
+	})
+}
+
+// eventBus publishes events to subscriptions without blocking
+type eventBus struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: make(map[*Subscription]struct{})}
+}
+
+func (bus *eventBus) subscribe(buffSize int, types []EventType) *Subscription {
+	sub := &Subscription{
+		ch:  make(chan *Event, buffSize),
+		bus: bus,
+	}
+	sub.C = sub.ch
+
+	if len(types) > 0 {
+		sub.types = make(map[EventType]bool, len(types))
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+
+	bus.mu.Lock()
+	bus.subs[sub] = struct{}{}
+	bus.mu.Unlock()
+
+	return sub
+}
+
+// remove removes the subscription and closes its channel.  The write lock
+// guarantees no publish is in progress
+func (bus *eventBus) remove(sub *Subscription) {
+	bus.mu.Lock()
+	if _, ok := bus.subs[sub]; ok {
+		delete(bus.subs, sub)
+		close(sub.ch)
+	}
+	bus.mu.Unlock()
+}
+
+// closeAll closes all subscriptions
+func (bus *eventBus) closeAll() {
+	bus.mu.RLock()
+	subs := make([]*Subscription, 0, len(bus.subs))
+	for sub := range bus.subs {
+		subs = append(subs, sub)
+	}
+	bus.mu.RUnlock()
+
+	for _, sub := range subs {
+		sub.Close()
+	}
+}
+
+// publish sends the event to all subscribers of its type.  It is safe to call
+// on a nil bus
+func (bus *eventBus) publish(ev *Event) {
+	if bus == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	bus.mu.RLock()
+	defer bus.mu.RUnlock()
+
+	for sub := range bus.subs {
+		if sub.types != nil && !sub.types[ev.Type] {
+			continue
+		}
+
+		select {
+		case sub.ch <- ev:
+		default:
+			atomic.AddUint64(&sub.dropped, 1)
+		}
+	}
+}
+
+// Subscribe returns a subscription for events of the given types or all events
+// if none are given.  Up to buffSize events are buffered
+func (phi *Phi) Subscribe(buffSize int, types ...EventType) *Subscription {
+	return phi.events.subscribe(buffSize, types)
+}
@@ -0,0 +1,70 @@
+package phi
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_eventBus(t *testing.T) {
+	bus := newEventBus()
+
+	all := bus.subscribe(4, nil)
+	blocks := bus.subscribe(4, []EventType{EventBlockStored})
+
+	bus.publish(&Event{Type: EventNodeJoined})
+	bus.publish(&Event{Type: EventBlockStored, ID: []byte("id")})
+
+	if len(all.C) != 2 {
+		t.Fatal("should have 2 events", len(all.C))
+	}
+	ev := <-blocks.C
+	if ev.Type != EventBlockStored || string(ev.ID) != "id" || ev.Time.IsZero() {
+		t.Fatal("wrong event", ev)
+	}
+	if len(blocks.C) != 0 {
+		t.Fatal("should filter events")
+	}
+
+	blocks.Close()
+	if _, ok := <-blocks.C; ok {
+		t.Fatal("channel should be closed")
+	}
+	// Closing twice is a no-op
+	blocks.Close()
+
+	bus.closeAll()
+	if len(bus.subs) != 0 {
+		t.Fatal("subscriptions should be removed")
+	}
+}
+
+func Test_eventBus_dropped(t *testing.T) {
+	bus := newEventBus()
+	sub := bus.subscribe(1, nil)
+
+	for i := 0; i < 3; i++ {
+		bus.publish(&Event{Type: EventEntryApplied})
+	}
+	if sub.Dropped() != 2 {
+		t.Fatal("should drop 2 events", sub.Dropped())
+	}
+
+	// nil bus is a no-op
+	var nbus *eventBus
+	nbus.publish(&Event{Type: EventEntryApplied})
+}
+
+func Test_formatFields(t *testing.T) {
+	out := formatFields([]interface{}{
+		"key", []byte("k1"),
+		"id", hexID([]byte{0xab}),
+		"err", errors.New("not found"),
+		"count", 2,
+		"missing",
+	})
+
+	exp := ` key=k1 id=ab err="not found" count=2 missing=MISSING`
+	if out != exp {
+		t.Fatalf("want %q got %q", exp, out)
+	}
+}
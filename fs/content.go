@@ -0,0 +1,136 @@
+package fs
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/hexablock/phi/internal/blockio"
+)
+
+var errNegativeOffset = errors.New("negative offset")
+
+// ReadAt writes length bytes of the file contents starting at offset to the
+// writer.  A negative length reads to the end of the file.  It returns the
+// number of bytes written
+func (fs *FS) ReadAt(ino uint64, offset, length int64, w io.Writer) (int64, error) {
+	inode, err := fs.GetInode(ino)
+	if err != nil {
+		return 0, err
+	}
+	if inode.IsDir() {
+		return 0, ErrIsDir
+	}
+
+	return fs.readRange(inode, offset, length, w)
+}
+
+// WriteContent replaces the contents of the file with the data from the reader
+func (fs *FS) WriteContent(ino uint64, r io.Reader) (*Inode, error) {
+	if err := fs.checkFile(ino); err != nil {
+		return nil, err
+	}
+
+	cr := &blockio.CountReader{R: r}
+	idx, err := fs.blx.WriteIndex(cr, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.setContent(ino, idx.ID(), cr.N)
+}
+
+// TruncateAt changes the size of the file.  Data past the size is discarded and
+// a larger size is filled with zeros
+func (fs *FS) TruncateAt(ino uint64, size int64) (*Inode, error) {
+	inode, err := fs.GetInode(ino)
+	if err != nil {
+		return nil, err
+	}
+	if inode.IsDir() {
+		return nil, ErrIsDir
+	}
+
+	if size == inode.Size {
+		return inode, nil
+	}
+	if size <= 0 {
+		return fs.setContent(ino, nil, 0)
+	}
+
+	keep := inode.Size
+	if size < keep {
+		keep = size
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := fs.readRange(inode, 0, keep, pw)
+		if err == nil {
+			_, err = io.CopyN(pw, zeroReader{}, size-keep)
+		}
+		pw.CloseWithError(err)
+	}()
+
+	idx, err := fs.blx.WriteIndex(pr, 2)
+	// Unblock the writer if the index write failed
+	pr.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.setContent(ino, idx.ID(), size)
+}
+
+// readRange writes the range of the file contents to the writer
+func (fs *FS) readRange(inode *Inode, offset, length int64, w io.Writer) (int64, error) {
+	if offset < 0 {
+		return 0, errNegativeOffset
+	}
+	if length < 0 || offset+length > inode.Size {
+		length = inode.Size - offset
+	}
+	if length <= 0 || inode.IndexID == nil {
+		return 0, nil
+	}
+
+	return blockio.ReadRange(fs.blx, inode.IndexID, offset, length, w)
+}
+
+// setContent points the file at new contents.  The previous data blocks are
+// not removed as they may be shared with other files
+func (fs *FS) setContent(ino uint64, id []byte, size int64) (*Inode, error) {
+	var out *Inode
+	err := fs.updateInode(ino, func(inode *Inode) error {
+		if inode.IsDir() {
+			return ErrIsDir
+		}
+		inode.IndexID = id
+		inode.Size = size
+		inode.Mtime = time.Now()
+		out = inode
+		return nil
+	})
+
+	return out, err
+}
+
+func (fs *FS) checkFile(ino uint64) error {
+	inode, err := fs.GetInode(ino)
+	if err != nil {
+		return err
+	}
+	if inode.IsDir() {
+		return ErrIsDir
+	}
+	return nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
@@ -0,0 +1,526 @@
+// Package fs implements a distributed file-system on top of phi.  Every inode
+// is a key in the kv store and all metadata changes are compare-and-set writes
+// through the WAL.  Directory inodes hold their entries so creating, removing
+// and renaming within a directory is a single atomic update of the directory.
+// File contents are stored as blox indexes on the block device.
+package fs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hexablock/blox"
+	"github.com/hexablock/phi/kv"
+)
+
+// RootIno is the inode number of the root directory
+const RootIno uint64 = 1
+
+var (
+	// ErrNotExist is returned when a file or directory does not exist
+	ErrNotExist = errors.New("file does not exist")
+	// ErrExist is returned when a file or directory already exists
+	ErrExist = errors.New("file exists")
+	// ErrNotDir is returned when a directory is expected
+	ErrNotDir = errors.New("not a directory")
+	// ErrIsDir is returned when a file is expected
+	ErrIsDir = errors.New("is a directory")
+	// ErrNotEmpty is returned when removing a directory with entries
+	ErrNotEmpty = errors.New("directory not empty")
+	// ErrInvalidName is returned for empty names, names containing a slash
+	// and renames of a directory into itself
+	ErrInvalidName = errors.New("invalid name")
+)
+
+// Dirent is a directory entry
+type Dirent struct {
+	Name string `json:"-"`
+	Ino  uint64
+	Dir  bool
+}
+
+// Inode is a file or directory
+type Inode struct {
+	Ino   uint64
+	Mode  os.FileMode
+	Size  int64
+	Ctime time.Time
+	Mtime time.Time
+
+	// Parent directory.  Only set for directories
+	Parent uint64 `json:",omitempty"`
+	// Entries of a directory by name
+	Entries map[string]*Dirent `json:",omitempty"`
+	// Set on a directory being removed so no new entries are added
+	Removed bool `json:",omitempty"`
+
+	// Blox index id of the file contents.  Nil for an empty file
+	IndexID []byte `json:",omitempty"`
+}
+
+// IsDir returns true if the inode is a directory
+func (inode *Inode) IsDir() bool {
+	return inode.Mode.IsDir()
+}
+
+// FS is a distributed file-system
+type FS struct {
+	kv  *kv.KV
+	blx *blox.Blox
+}
+
+// New returns a file-system using the kv store for inodes and the block device
+// for file contents
+func New(kvs *kv.KV, dev blox.BlockDevice) *FS {
+	return &FS{kv: kvs, blx: blox.NewBlox(dev)}
+}
+
+// Init creates the root directory if it does not exist
+func (fs *FS) Init() error {
+	now := time.Now()
+	root := &Inode{
+		Ino:     RootIno,
+		Mode:    os.ModeDir | 0755,
+		Ctime:   now,
+		Mtime:   now,
+		Parent:  RootIno,
+		Entries: make(map[string]*Dirent),
+	}
+
+	b, err := json.Marshal(root)
+	if err != nil {
+		return err
+	}
+
+	if _, err = fs.kv.CAS(inodeKey(RootIno), b, 0); err == kv.ErrVersionMismatch {
+		return nil
+	}
+	return err
+}
+
+// GetInode returns the inode with the given number
+func (fs *FS) GetInode(ino uint64) (*Inode, error) {
+	pair, err := fs.kv.Get(inodeKey(ino))
+	if err == kv.ErrNotFound {
+		return nil, ErrNotExist
+	} else if err != nil {
+		return nil, err
+	}
+
+	return decodeInode(pair.Value)
+}
+
+// Lookup returns the inode of the named entry in the parent directory
+func (fs *FS) Lookup(parent uint64, name string) (*Inode, error) {
+	dir, err := fs.getDir(parent)
+	if err != nil {
+		return nil, err
+	}
+
+	de, ok := dir.Entries[name]
+	if !ok {
+		return nil, ErrNotExist
+	}
+	return fs.GetInode(de.Ino)
+}
+
+// ReadDirAt returns the entries of the directory sorted by name
+func (fs *FS) ReadDirAt(ino uint64) ([]*Dirent, error) {
+	dir, err := fs.getDir(ino)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*Dirent, 0, len(dir.Entries))
+	for name, de := range dir.Entries {
+		out = append(out, &Dirent{Name: name, Ino: de.Ino, Dir: de.Dir})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+
+	return out, nil
+}
+
+// MkdirAt creates a directory in the parent directory
+func (fs *FS) MkdirAt(parent uint64, name string, perm os.FileMode) (*Inode, error) {
+	return fs.createAt(parent, name, os.ModeDir|perm.Perm())
+}
+
+// CreateAt creates an empty file in the parent directory
+func (fs *FS) CreateAt(parent uint64, name string, perm os.FileMode) (*Inode, error) {
+	return fs.createAt(parent, name, perm.Perm())
+}
+
+// createAt allocates a new inode and then adds it to the parent.  The inode is
+// removed if it cannot be added
+func (fs *FS) createAt(parent uint64, name string, mode os.FileMode) (*Inode, error) {
+	if !validName(name) {
+		return nil, ErrInvalidName
+	}
+
+	now := time.Now()
+	inode := &Inode{Mode: mode, Ctime: now, Mtime: now}
+	if mode.IsDir() {
+		inode.Parent = parent
+		inode.Entries = make(map[string]*Dirent)
+	}
+
+	if err := fs.allocInode(inode); err != nil {
+		return nil, err
+	}
+
+	err := fs.updateDir(parent, func(dir *Inode) error {
+		if _, ok := dir.Entries[name]; ok {
+			return ErrExist
+		}
+		dir.Entries[name] = &Dirent{Ino: inode.Ino, Dir: mode.IsDir()}
+		return nil
+	})
+	if err != nil {
+		fs.kv.Delete(inodeKey(inode.Ino))
+		return nil, err
+	}
+
+	return inode, nil
+}
+
+// UnlinkAt removes the named file from the parent directory
+func (fs *FS) UnlinkAt(parent uint64, name string) error {
+	var ino uint64
+	err := fs.updateDir(parent, func(dir *Inode) error {
+		de, ok := dir.Entries[name]
+		if !ok {
+			return ErrNotExist
+		}
+		if de.Dir {
+			return ErrIsDir
+		}
+		ino = de.Ino
+		delete(dir.Entries, name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return fs.removeInode(ino)
+}
+
+// RmdirAt removes the named empty directory from the parent directory.  The
+// directory is first marked removed so no entries can be added while it is
+// being unlinked.  The mark is undone if it cannot be unlinked
+func (fs *FS) RmdirAt(parent uint64, name string) error {
+	dir, err := fs.getDir(parent)
+	if err != nil {
+		return err
+	}
+	de, ok := dir.Entries[name]
+	if !ok {
+		return ErrNotExist
+	}
+	if !de.Dir {
+		return ErrNotDir
+	}
+
+	err = fs.updateDir(de.Ino, func(target *Inode) error {
+		if len(target.Entries) > 0 {
+			return ErrNotEmpty
+		}
+		target.Removed = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = fs.updateDir(parent, func(dir *Inode) error {
+		if current, ok := dir.Entries[name]; ok && current.Ino == de.Ino {
+			delete(dir.Entries, name)
+		}
+		return nil
+	})
+	if err != nil {
+		// The directory is still linked so it is made usable again
+		fs.updateInode(de.Ino, func(target *Inode) error {
+			target.Removed = false
+			return nil
+		})
+		return err
+	}
+
+	return fs.removeInode(de.Ino)
+}
+
+// RenameAt moves the named entry from one directory to another replacing any
+// existing file or empty directory.  A rename within a directory is atomic.
+// Across directories the entry is first added to the new parent and then
+// removed from the old one
+func (fs *FS) RenameAt(oldParent uint64, oldName string, newParent uint64, newName string) error {
+	if !validName(oldName) || !validName(newName) {
+		return ErrInvalidName
+	}
+
+	if oldParent == newParent {
+		if oldName == newName {
+			return nil
+		}
+
+		var src, replaced *Dirent
+		err := fs.updateDir(oldParent, func(dir *Inode) error {
+			var ok bool
+			if src, ok = dir.Entries[oldName]; !ok {
+				return ErrNotExist
+			}
+
+			dst := dir.Entries[newName]
+			if err := fs.checkReplace(src, dst); err != nil {
+				return err
+			}
+
+			replaced = dst
+			dir.Entries[newName] = src
+			delete(dir.Entries, oldName)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return fs.removeReplaced(src, replaced)
+	}
+
+	srcDir, err := fs.getDir(oldParent)
+	if err != nil {
+		return err
+	}
+	src, ok := srcDir.Entries[oldName]
+	if !ok {
+		return ErrNotExist
+	}
+
+	if src.Dir {
+		// Moving a directory into its own subtree would detach it
+		if err = fs.checkNotAncestor(src.Ino, newParent); err != nil {
+			return err
+		}
+	}
+
+	var replaced *Dirent
+	err = fs.updateDir(newParent, func(dir *Inode) error {
+		dst := dir.Entries[newName]
+		if err := fs.checkReplace(src, dst); err != nil {
+			return err
+		}
+
+		replaced = dst
+		dir.Entries[newName] = src
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = fs.updateDir(oldParent, func(dir *Inode) error {
+		if current, ok := dir.Entries[oldName]; ok && current.Ino == src.Ino {
+			delete(dir.Entries, oldName)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if src.Dir {
+		err = fs.updateInode(src.Ino, func(inode *Inode) error {
+			inode.Parent = newParent
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return fs.removeReplaced(src, replaced)
+}
+
+// checkReplace returns an error if src cannot replace dst.  dst may be nil
+func (fs *FS) checkReplace(src, dst *Dirent) error {
+	if dst == nil {
+		return nil
+	}
+	if dst.Ino == src.Ino {
+		return nil
+	}
+
+	if !dst.Dir {
+		if src.Dir {
+			return ErrNotDir
+		}
+		return nil
+	}
+
+	if !src.Dir {
+		return ErrIsDir
+	}
+	target, err := fs.getDir(dst.Ino)
+	if err != nil {
+		return err
+	}
+	if len(target.Entries) > 0 {
+		return ErrNotEmpty
+	}
+	return nil
+}
+
+// removeReplaced removes the inode replaced by a rename if any
+func (fs *FS) removeReplaced(src, replaced *Dirent) error {
+	if replaced == nil || replaced.Ino == src.Ino {
+		return nil
+	}
+	return fs.removeInode(replaced.Ino)
+}
+
+// checkNotAncestor returns an error if ino is dir or one of its ancestors
+func (fs *FS) checkNotAncestor(ino, dir uint64) error {
+	for {
+		if dir == ino {
+			return ErrInvalidName
+		}
+		if dir == RootIno {
+			return nil
+		}
+
+		inode, err := fs.getDir(dir)
+		if err != nil {
+			return err
+		}
+		dir = inode.Parent
+	}
+}
+
+// allocInode assigns a random unused inode number and writes the inode
+func (fs *FS) allocInode(inode *Inode) error {
+	b := make([]byte, 8)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		inode.Ino = binary.BigEndian.Uint64(b)
+		if inode.Ino <= RootIno {
+			continue
+		}
+
+		data, err := json.Marshal(inode)
+		if err != nil {
+			return err
+		}
+
+		_, err = fs.kv.CAS(inodeKey(inode.Ino), data, 0)
+		if err != kv.ErrVersionMismatch {
+			return err
+		}
+	}
+}
+
+func (fs *FS) removeInode(ino uint64) error {
+	err := fs.kv.Delete(inodeKey(ino))
+	if err == kv.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (fs *FS) getDir(ino uint64) (*Inode, error) {
+	inode, err := fs.GetInode(ino)
+	if err != nil {
+		return nil, err
+	}
+	if !inode.IsDir() {
+		return nil, ErrNotDir
+	}
+	if inode.Removed {
+		return nil, ErrNotExist
+	}
+	return inode, nil
+}
+
+// updateInode atomically updates an existing inode
+func (fs *FS) updateInode(ino uint64, fn func(*Inode) error) error {
+	_, err := fs.kv.Update(inodeKey(ino), func(current *kv.Pair) ([]byte, error) {
+		if current == nil {
+			return nil, ErrNotExist
+		}
+
+		inode, err := decodeInode(current.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = fn(inode); err != nil {
+			return nil, err
+		}
+		return json.Marshal(inode)
+	})
+
+	if err == kv.ErrNotFound {
+		return ErrNotExist
+	}
+	return err
+}
+
+// updateDir atomically updates an existing directory updating its size and
+// modification time
+func (fs *FS) updateDir(ino uint64, fn func(*Inode) error) error {
+	return fs.updateInode(ino, func(dir *Inode) error {
+		if !dir.IsDir() {
+			return ErrNotDir
+		}
+		if dir.Removed {
+			return ErrNotExist
+		}
+		if dir.Entries == nil {
+			dir.Entries = make(map[string]*Dirent)
+		}
+
+		if err := fn(dir); err != nil {
+			return err
+		}
+
+		dir.Size = int64(len(dir.Entries))
+		dir.Mtime = time.Now()
+		return nil
+	})
+}
+
+func decodeInode(b []byte) (*Inode, error) {
+	var inode Inode
+	err := json.Unmarshal(b, &inode)
+	return &inode, err
+}
+
+func inodeKey(ino uint64) []byte {
+	return []byte(fmt.Sprintf("i/%016x", ino))
+}
+
+// ParseInodeKey returns the inode number of a kv key written by the
+// file-system.  The key must not include the kv namespace
+func ParseInodeKey(key []byte) (uint64, bool) {
+	if len(key) != 18 || !bytes.HasPrefix(key, []byte("i/")) {
+		return 0, false
+	}
+
+	ino, err := strconv.ParseUint(string(key[2:]), 16, 64)
+	return ino, err == nil
+}
+
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
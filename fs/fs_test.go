@@ -0,0 +1,285 @@
+package fs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/hexablock/phi/internal/waltest"
+	"github.com/hexablock/phi/kv"
+)
+
+// newTestFS returns an initialized file-system without a block device.  Only
+// metadata operations can be used
+func newTestFS(t *testing.T) *FS {
+	return newTestFSWithWAL(t, waltest.New())
+}
+
+// newTestFSWithWAL returns an initialized file-system on the given wal
+func newTestFSWithWAL(t *testing.T, wal *waltest.WAL) *FS {
+	kvs := kv.New(kv.DefaultConfig(), wal, nil)
+	fsys := New(kvs, nil)
+	if err := fsys.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return fsys
+}
+
+func dirNames(t *testing.T, fsys *FS, name string) string {
+	ents, err := fsys.ReadDir(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(ents))
+	for i, de := range ents {
+		names[i] = de.Name
+	}
+	return fmt.Sprint(names)
+}
+
+func Test_splitPath(t *testing.T) {
+	for _, tc := range []struct {
+		path string
+		want string
+	}{
+		{"/", "[]"},
+		{"/a", "[a]"},
+		{"/a/b/", "[a b]"},
+		{"//a/./b/../c", "[a c]"},
+	} {
+		parts, err := splitPath(tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(parts) != tc.want {
+			t.Fatalf("path=%s want=%s have=%v", tc.path, tc.want, parts)
+		}
+	}
+
+	if _, err := splitPath("a/b"); err != ErrInvalidName {
+		t.Fatal("should fail with", ErrInvalidName, err)
+	}
+}
+
+func Test_validName(t *testing.T) {
+	for _, name := range []string{"", ".", "..", "a/b"} {
+		if validName(name) {
+			t.Fatal("should be invalid", name)
+		}
+	}
+	if !validName("a.txt") {
+		t.Fatal("should be valid")
+	}
+}
+
+func Test_ParseInodeKey(t *testing.T) {
+	ino, ok := ParseInodeKey(inodeKey(0xdeadbeef))
+	if !ok || ino != 0xdeadbeef {
+		t.Fatal("wrong inode", ino, ok)
+	}
+
+	if _, ok = ParseInodeKey([]byte("i/xyz")); ok {
+		t.Fatal("should not parse")
+	}
+}
+
+func Test_FS_Mkdir(t *testing.T) {
+	fsys := newTestFS(t)
+
+	if _, err := fsys.Mkdir("/a", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.Mkdir("/a", 0755); err != ErrExist {
+		t.Fatal("should fail with", ErrExist, err)
+	}
+	dir, err := fsys.Mkdir("/a/b", 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dir.IsDir() || dir.Mode.Perm() != 0700 {
+		t.Fatal("wrong mode", dir.Mode)
+	}
+
+	parent, err := fsys.Stat("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir.Parent != parent.Ino || parent.Size != 1 {
+		t.Fatal("wrong parent", dir.Parent, parent.Size)
+	}
+	if names := dirNames(t, fsys, "/a"); names != "[b]" {
+		t.Fatal("wrong entries", names)
+	}
+
+	if _, err = fsys.Mkdir("/x/y", 0755); err != ErrNotExist {
+		t.Fatal("should fail with", ErrNotExist, err)
+	}
+	if _, err = fsys.Create("/a/f", 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fsys.Mkdir("/a/f/g", 0755); err != ErrNotDir {
+		t.Fatal("should fail with", ErrNotDir, err)
+	}
+	if _, err = fsys.Mkdir("/", 0755); err != ErrInvalidName {
+		t.Fatal("should fail with", ErrInvalidName, err)
+	}
+}
+
+func Test_FS_Rename(t *testing.T) {
+	fsys := newTestFS(t)
+
+	for _, name := range []string{"/a", "/a/full", "/a/empty", "/b"} {
+		if _, err := fsys.Mkdir(name, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src, err := fsys.Create("/a/f1", 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst, err := fsys.Create("/a/f2", 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fsys.Create("/a/full/f", 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Over an existing file within a directory
+	if err = fsys.Rename("/a/f1", "/a/f2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fsys.Stat("/a/f1"); err != ErrNotExist {
+		t.Fatal("source should not exist", err)
+	}
+	inode, err := fsys.Stat("/a/f2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inode.Ino != src.Ino {
+		t.Fatal("wrong inode", inode.Ino)
+	}
+	if _, err = fsys.GetInode(dst.Ino); err != ErrNotExist {
+		t.Fatal("replaced inode should be removed", err)
+	}
+
+	if err = fsys.Rename("/a/f2", "/a/empty"); err != ErrIsDir {
+		t.Fatal("should fail with", ErrIsDir, err)
+	}
+	if err = fsys.Rename("/a/empty", "/a/f2"); err != ErrNotDir {
+		t.Fatal("should fail with", ErrNotDir, err)
+	}
+	if err = fsys.Rename("/b", "/a/full"); err != ErrNotEmpty {
+		t.Fatal("should fail with", ErrNotEmpty, err)
+	}
+	if err = fsys.Rename("/a", "/a/empty/a"); err != ErrInvalidName {
+		t.Fatal("should fail with", ErrInvalidName, err)
+	}
+
+	// Over an empty directory and then across directories
+	empty, err := fsys.Stat("/a/empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = fsys.Rename("/a/full", "/a/empty"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fsys.GetInode(empty.Ino); err != ErrNotExist {
+		t.Fatal("replaced directory should be removed", err)
+	}
+	if err = fsys.Rename("/a/empty", "/b/moved"); err != nil {
+		t.Fatal(err)
+	}
+
+	moved, err := fsys.Stat("/b/moved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := fsys.Stat("/b")
+	if moved.Parent != b.Ino {
+		t.Fatal("parent not updated", moved.Parent)
+	}
+	if _, err = fsys.Stat("/b/moved/f"); err != nil {
+		t.Fatal("entries should move with the directory", err)
+	}
+	if names := dirNames(t, fsys, "/a"); names != "[f2]" {
+		t.Fatal("wrong entries", names)
+	}
+}
+
+func Test_FS_Unlink(t *testing.T) {
+	fsys := newTestFS(t)
+
+	if _, err := fsys.Mkdir("/d", 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := fsys.Create("/d/f", 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = fsys.Unlink("/d"); err != ErrIsDir {
+		t.Fatal("should fail with", ErrIsDir, err)
+	}
+	if err = fsys.Rmdir("/d"); err != ErrNotEmpty {
+		t.Fatal("should fail with", ErrNotEmpty, err)
+	}
+	if err = fsys.Rmdir("/d/f"); err != ErrNotDir {
+		t.Fatal("should fail with", ErrNotDir, err)
+	}
+
+	// A failed rmdir leaves the directory usable
+	if _, err = fsys.Create("/d/g", 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"/d/f", "/d/g"} {
+		if err = fsys.Unlink(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = fsys.GetInode(f.Ino); err != ErrNotExist {
+		t.Fatal("inode should be removed", err)
+	}
+	if err = fsys.Unlink("/d/f"); err != ErrNotExist {
+		t.Fatal("should fail with", ErrNotExist, err)
+	}
+
+	if err = fsys.Rmdir("/d"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fsys.Stat("/d"); err != ErrNotExist {
+		t.Fatal("should not exist", err)
+	}
+}
+
+func Test_FS_Rmdir_parentFails(t *testing.T) {
+	wal := waltest.New()
+	fsys := newTestFSWithWAL(t, wal)
+
+	if _, err := fsys.Mkdir("/d", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Fail the parent update after the directory is marked removed
+	errParent := errors.New("parent update failed")
+	wal.Fail = func(key []byte) error {
+		if bytes.HasSuffix(key, inodeKey(RootIno)) {
+			return errParent
+		}
+		return nil
+	}
+	if err := fsys.Rmdir("/d"); err != errParent {
+		t.Fatal("should fail with", errParent, err)
+	}
+	wal.Fail = nil
+
+	// The directory is still linked and usable
+	if _, err := fsys.Create("/d/f", 0644); err != nil {
+		t.Fatal(err)
+	}
+	if names := dirNames(t, fsys, "/d"); names != "[f]" {
+		t.Fatal("wrong entries", names)
+	}
+}
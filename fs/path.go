@@ -0,0 +1,167 @@
+package fs
+
+import (
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// Stat returns the inode at the path
+func (fs *FS) Stat(name string) (*Inode, error) {
+	ino, err := fs.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.GetInode(ino)
+}
+
+// Mkdir creates a directory at the path.  The parent must exist
+func (fs *FS) Mkdir(name string, perm os.FileMode) (*Inode, error) {
+	parent, base, err := fs.resolveParent(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.MkdirAt(parent, base, perm)
+}
+
+// ReadDir returns the entries of the directory at the path sorted by name
+func (fs *FS) ReadDir(name string) ([]*Dirent, error) {
+	ino, err := fs.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.ReadDirAt(ino)
+}
+
+// Create creates an empty file at the path.  The parent must exist
+func (fs *FS) Create(name string, perm os.FileMode) (*Inode, error) {
+	parent, base, err := fs.resolveParent(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.CreateAt(parent, base, perm)
+}
+
+// Rename moves the file or directory at oldpath to newpath
+func (fs *FS) Rename(oldpath, newpath string) error {
+	oldParent, oldBase, err := fs.resolveParent(oldpath)
+	if err != nil {
+		return err
+	}
+	newParent, newBase, err := fs.resolveParent(newpath)
+	if err != nil {
+		return err
+	}
+	return fs.RenameAt(oldParent, oldBase, newParent, newBase)
+}
+
+// Unlink removes the file at the path
+func (fs *FS) Unlink(name string) error {
+	parent, base, err := fs.resolveParent(name)
+	if err != nil {
+		return err
+	}
+	return fs.UnlinkAt(parent, base)
+}
+
+// Rmdir removes the empty directory at the path
+func (fs *FS) Rmdir(name string) error {
+	parent, base, err := fs.resolveParent(name)
+	if err != nil {
+		return err
+	}
+	return fs.RmdirAt(parent, base)
+}
+
+// Truncate changes the size of the file at the path
+func (fs *FS) Truncate(name string, size int64) (*Inode, error) {
+	ino, err := fs.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.TruncateAt(ino, size)
+}
+
+// WriteFile replaces the contents of the file at the path creating it if it
+// does not exist
+func (fs *FS) WriteFile(name string, r io.Reader, perm os.FileMode) (*Inode, error) {
+	parent, base, err := fs.resolveParent(name)
+	if err != nil {
+		return nil, err
+	}
+
+	inode, err := fs.Lookup(parent, base)
+	if err == ErrNotExist {
+		if inode, err = fs.CreateAt(parent, base, perm); err == ErrExist {
+			inode, err = fs.Lookup(parent, base)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.WriteContent(inode.Ino, r)
+}
+
+// ReadFile writes the contents of the file at the path to the writer
+func (fs *FS) ReadFile(name string, w io.Writer) (int64, error) {
+	ino, err := fs.resolve(name)
+	if err != nil {
+		return 0, err
+	}
+	return fs.ReadAt(ino, 0, -1, w)
+}
+
+// resolve returns the inode number at the path walking from the root
+func (fs *FS) resolve(name string) (uint64, error) {
+	parts, err := splitPath(name)
+	if err != nil {
+		return 0, err
+	}
+
+	ino := RootIno
+	for _, part := range parts {
+		dir, err := fs.getDir(ino)
+		if err != nil {
+			return 0, err
+		}
+
+		de, ok := dir.Entries[part]
+		if !ok {
+			return 0, ErrNotExist
+		}
+		ino = de.Ino
+	}
+
+	return ino, nil
+}
+
+// resolveParent returns the inode number of the parent directory and the base
+// name of the path
+func (fs *FS) resolveParent(name string) (uint64, string, error) {
+	parts, err := splitPath(name)
+	if err != nil {
+		return 0, "", err
+	}
+	if len(parts) == 0 {
+		// The root has no parent
+		return 0, "", ErrInvalidName
+	}
+
+	parent, err := fs.resolve("/" + strings.Join(parts[:len(parts)-1], "/"))
+	return parent, parts[len(parts)-1], err
+}
+
+// splitPath returns the components of an absolute path
+func splitPath(name string) ([]string, error) {
+	if !strings.HasPrefix(name, "/") {
+		return nil, ErrInvalidName
+	}
+
+	name = path.Clean(name)
+	if name == "/" {
+		return nil, nil
+	}
+	return strings.Split(name[1:], "/"), nil
+}
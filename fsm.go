@@ -0,0 +1,64 @@
+package phi
+
+import (
+	"sync"
+
+	"github.com/hexablock/hexalog"
+)
+
+// appliedPos is the last applied entry for a key
+type appliedPos struct {
+	Height uint32
+	ID     []byte
+}
+
+// localFSM wraps the user FSM.  It tracks the last applied entry per key for
+// snapshots and publishes applied entries to watchers
+type localFSM struct {
+	fsm    FSM
+	watch  *watchManager
+	events *eventBus
+
+	// Held by Apply and while taking a snapshot
+	mu      sync.Mutex
+	applied map[string]*appliedPos
+}
+
+func newLocalFSM(fsm FSM, watch *watchManager, events *eventBus) *localFSM {
+	return &localFSM{
+		fsm:     fsm,
+		watch:   watch,
+		events:  events,
+		applied: make(map[string]*appliedPos),
+	}
+}
+
+// Apply applies the entry to the user fsm and then publishes it to watchers and
+// event subscribers.  The signature of signed entries is removed from the data
+func (lf *localFSM) Apply(entryID []byte, entry *hexalog.Entry) interface{} {
+	if isSigned(entry.Data) {
+		unsigned := *entry
+		unsigned.Data = EntryData(entry)
+		entry = &unsigned
+	}
+
+	lf.mu.Lock()
+	resp := lf.fsm.Apply(entryID, entry)
+	lf.applied[string(entry.Key)] = &appliedPos{Height: uint32(entry.Height), ID: entryID}
+	lf.mu.Unlock()
+
+	lf.watch.publish(&WatchEvent{
+		Key:     entry.Key,
+		Height:  uint32(entry.Height),
+		EntryID: entryID,
+		Data:    entry.Data,
+	})
+	lf.events.publish(&Event{
+		Type:   EventEntryApplied,
+		Key:    entry.Key,
+		ID:     entryID,
+		Height: uint32(entry.Height),
+	})
+
+	return resp
+}
@@ -0,0 +1,96 @@
+package phi
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/hexablock/hexalog"
+)
+
+type fsmRoute struct {
+	prefix []byte
+	fsm    FSM
+}
+
+// FSMRouter implements an FSM that dispatches entries to the FSM registered for
+// the longest matching key prefix.  Entries for keys not matching any prefix
+// are applied to the default FSM if one is set.  This allows multiple
+// applications to share a cluster each with their own key namespace
+type FSMRouter struct {
+	mu     sync.RWMutex
+	routes []*fsmRoute
+
+	// FSM for unmatched keys. Can be nil
+	def FSM
+
+	// Registered dht handed to FSMs registered after the fact
+	dht DHT
+}
+
+// NewFSMRouter returns a new FSMRouter with the given default FSM.  The
+// default may be nil in which case unmatched entries are not applied
+func NewFSMRouter(def FSM) *FSMRouter {
+	return &FSMRouter{def: def, routes: make([]*fsmRoute, 0)}
+}
+
+// Register registers the FSM for keys with the given prefix.  It returns an
+// error if the prefix is already registered
+func (router *FSMRouter) Register(prefix []byte, fsm FSM) error {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+
+	for _, r := range router.routes {
+		if bytes.Equal(r.prefix, prefix) {
+			return fmt.Errorf("fsm already registered prefix=%s", prefix)
+		}
+	}
+
+	router.routes = append(router.routes, &fsmRoute{prefix: prefix, fsm: fsm})
+	// Longest prefix first
+	sort.SliceStable(router.routes, func(i, j int) bool {
+		return len(router.routes[i].prefix) > len(router.routes[j].prefix)
+	})
+
+	if router.dht != nil {
+		fsm.RegisterDHT(router.dht)
+	}
+
+	return nil
+}
+
+// Apply applies the entry to the FSM registered for the key
+func (router *FSMRouter) Apply(entryID []byte, entry *hexalog.Entry) interface{} {
+	fsm := router.route(entry.Key)
+	if fsm == nil {
+		return fmt.Errorf("no fsm for key=%s", entry.Key)
+	}
+	return fsm.Apply(entryID, entry)
+}
+
+// RegisterDHT registers the dht with all FSMs including the default
+func (router *FSMRouter) RegisterDHT(dht DHT) {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+
+	router.dht = dht
+	for _, r := range router.routes {
+		r.fsm.RegisterDHT(dht)
+	}
+	if router.def != nil {
+		router.def.RegisterDHT(dht)
+	}
+}
+
+func (router *FSMRouter) route(key []byte) FSM {
+	router.mu.RLock()
+	defer router.mu.RUnlock()
+
+	for _, r := range router.routes {
+		if bytes.HasPrefix(key, r.prefix) {
+			return r.fsm
+		}
+	}
+	return router.def
+}
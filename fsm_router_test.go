@@ -0,0 +1,50 @@
+package phi
+
+import (
+	"testing"
+
+	"github.com/hexablock/hexalog"
+)
+
+type testCountFSM struct {
+	dht     DHT
+	applied int
+}
+
+func (fsm *testCountFSM) Apply(id []byte, entry *hexalog.Entry) interface{} {
+	fsm.applied++
+	return nil
+}
+
+func (fsm *testCountFSM) RegisterDHT(dht DHT) {
+	fsm.dht = dht
+}
+
+func Test_FSMRouter(t *testing.T) {
+	def := &testCountFSM{}
+	fs := &testCountFSM{}
+	fsData := &testCountFSM{}
+
+	router := NewFSMRouter(def)
+	if err := router.Register([]byte("fs/"), fs); err != nil {
+		t.Fatal(err)
+	}
+	if err := router.Register([]byte("fs/data/"), fsData); err != nil {
+		t.Fatal(err)
+	}
+	if err := router.Register([]byte("fs/"), fs); err == nil {
+		t.Fatal("should fail on duplicate prefix")
+	}
+
+	for _, k := range []string{"fs/a", "fs/data/a", "fs/data/b", "kv/a"} {
+		router.Apply(nil, &hexalog.Entry{Key: []byte(k)})
+	}
+
+	if fs.applied != 1 || fsData.applied != 2 || def.applied != 1 {
+		t.Fatal("wrong dispatch", fs.applied, fsData.applied, def.applied)
+	}
+
+	if r := NewFSMRouter(nil).Apply(nil, &hexalog.Entry{Key: []byte("a")}); r == nil {
+		t.Fatal("should return an error without a default fsm")
+	}
+}
@@ -1,13 +1,26 @@
 package phi
 
 import (
+	"bytes"
 	"context"
+	"encoding/hex"
+	"errors"
 	"hash"
+	"net"
+	"sort"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
 	"github.com/hexablock/hexalog"
 	"github.com/hexablock/hexatype"
-	"github.com/hexablock/log"
+)
+
+var (
+	errEmptyPeerSet = errors.New("empty peer set")
+	errNoLivePeers  = errors.New("no live peers in peer set")
 )
 
 // WriteStats contains stats regarding a write operation to the log
@@ -23,6 +36,10 @@ type RetryOptions struct {
 	RetryInterval time.Duration
 }
 
+// UpdateFunc is called with the latest entry for a key and returns the data for
+// the next entry. prev is nil if the key has no entries
+type UpdateFunc func(prev *hexalog.Entry) ([]byte, error)
+
 // Jury implements an interface to get participants for an entry proposal. These
 // are the peers participating in the voting process
 type Jury interface {
@@ -30,6 +47,12 @@ type Jury interface {
 	RegisterDHT(dht DHT)
 }
 
+// Liveness implements an interface to check whether a participant is known to
+// have left or failed
+type Liveness interface {
+	IsDead(id []byte) bool
+}
+
 // Hexalog is a network aware Hexalog.  It implements selecting the
 // participants from the network for consistency
 type Hexalog struct {
@@ -44,6 +67,26 @@ type Hexalog struct {
 
 	// Jury selector for voting rounds
 	jury Jury
+
+	// Optional liveness checker used to skip dead participants
+	live Liveness
+
+	// Options used for reads
+	readOpts *ReadOptions
+
+	// Optional index to iterate forward over a keys history
+	index EntryIndex
+
+	// Optional metrics
+	metrics *metrics
+
+	// Optional event bus for failed proposals
+	events *eventBus
+
+	// Optional signer for proposed entries
+	signer *Signer
+
+	logger Logger
 }
 
 // DefaultRetryOptions returns a default set of RetryOptions
@@ -54,9 +97,26 @@ func DefaultRetryOptions() *RetryOptions {
 	}
 }
 
+// normalize sets sane values for unset or invalid options
+func (retry *RetryOptions) normalize() {
+	if retry.Retries < 1 {
+		retry.Retries = 1
+	}
+
+	if retry.RetryInterval == 0 {
+		retry.RetryInterval = 30 * time.Millisecond
+	}
+}
+
 // NewHexalog inita a new DHT aware WAL
 func NewHexalog(trans WALTransport, minVotes int, hashFunc func() hash.Hash) *Hexalog {
-	return &Hexalog{trans: trans, minVotes: minVotes, hashFunc: hashFunc}
+	return &Hexalog{
+		trans:    trans,
+		minVotes: minVotes,
+		hashFunc: hashFunc,
+		readOpts: DefaultReadOptions(),
+		logger:   NewStdLogger(),
+	}
 }
 
 // RegisterJury registers a jury interface used to get participants
@@ -64,21 +124,36 @@ func (hexlog *Hexalog) RegisterJury(jury Jury) {
 	hexlog.jury = jury
 }
 
+// RegisterLiveness registers a liveness interface used to skip dead participants
+// when proposing entries
+func (hexlog *Hexalog) RegisterLiveness(live Liveness) {
+	hexlog.live = live
+}
+
 // NewEntry returns a new Entry for the given key from Hexalog.  It returns an
 // error if the node is not part of the location set or a lookup error occurs
 func (hexlog *Hexalog) NewEntry(key []byte) (*hexalog.Entry, []*hexalog.Participant, error) {
-	peers, err := hexlog.jury.Participants(key, hexlog.minVotes)
-	if err != nil {
+	return hexlog.NewEntryContext(context.Background(), key)
+}
+
+// NewEntryContext is the same as NewEntry but aborts when the context is done
+func (hexlog *Hexalog) NewEntryContext(ctx context.Context, key []byte) (entry *hexalog.Entry, peers []*hexalog.Participant, err error) {
+	ctx, span := startSpan(ctx, "Hexalog.NewEntry", key)
+	defer func() { endSpan(span, err) }()
+
+	if peers, err = hexlog.participants(ctx, key); err != nil {
 		return nil, nil, err
 	}
 
 	opt := &hexalog.RequestOptions{}
-	var entry *hexalog.Entry
 
 	for _, loc := range peers {
-		if entry, err = hexlog.trans.NewEntry(loc.Host, key, opt); err == nil {
+		if entry, err = hexlog.trans.NewEntry(ctx, loc.Host, key, opt); err == nil {
 			return entry, peers, nil
 		}
+		if ctx.Err() != nil {
+			return nil, peers, ctx.Err()
+		}
 	}
 
 	return nil, peers, err
@@ -88,7 +163,17 @@ func (hexlog *Hexalog) NewEntry(key []byte) (*hexalog.Entry, []*hexalog.Particip
 // given height and previous hash of the entry to determine the values for
 // the new entry.  This is essentially a compare and set
 func (hexlog *Hexalog) NewEntryFrom(entry *hexalog.Entry) (*hexalog.Entry, []*hexalog.Participant, error) {
-	peers, err := hexlog.jury.Participants(entry.Key, hexlog.minVotes)
+	return hexlog.NewEntryFromContext(context.Background(), entry)
+}
+
+// NewEntryFromContext is the same as NewEntryFrom but aborts when the context
+// is done
+func (hexlog *Hexalog) NewEntryFromContext(ctx context.Context, entry *hexalog.Entry) (*hexalog.Entry, []*hexalog.Participant, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	peers, err := hexlog.participants(ctx, entry.Key)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -103,65 +188,272 @@ func (hexlog *Hexalog) NewEntryFrom(entry *hexalog.Entry) (*hexalog.Entry, []*he
 	return nentry, peers, nil
 }
 
-// GetEntry tries to get an entry from the network from all known locations
+// GetEntry tries to get an entry from the network from all known locations.
+// With quorum reads enabled a quorum of participants is queried in parallel
 func (hexlog *Hexalog) GetEntry(key, id []byte) (*hexalog.Entry, error) {
-	peers, err := hexlog.jury.Participants(key, hexlog.minVotes)
+	return hexlog.GetEntryContext(context.Background(), key, id)
+}
+
+// GetEntryContext is the same as GetEntry but aborts when the context is done
+func (hexlog *Hexalog) GetEntryContext(ctx context.Context, key, id []byte) (entry *hexalog.Entry, err error) {
+	ctx, span := startSpan(ctx, "Hexalog.GetEntry", key, attribute.String("phi.entry_id", hex.EncodeToString(id)))
+	defer func() { endSpan(span, err) }()
+
+	peers, err := hexlog.participants(ctx, key)
 	if err != nil {
 		return nil, err
 	}
 
+	if hexlog.readOpts != nil && hexlog.readOpts.Quorum {
+		return hexlog.getEntryQuorum(ctx, peers, key, id)
+	}
+
 	opt := &hexalog.RequestOptions{}
 
 	for _, p := range peers {
-		ent, er := hexlog.trans.GetEntry(p.Host, key, id, opt)
+		ent, er := hexlog.trans.GetEntry(ctx, p.Host, key, id, opt)
 		if er == nil {
 			return ent, nil
 		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		err = er
 	}
 
 	return nil, err
 }
 
-// ProposeEntry finds locations for the entry and proposes it to those locations
-// It retries the specified number of times before returning.  It returns a an
-// entry id on success and error otherwise
-func (hexlog *Hexalog) ProposeEntry(entry *hexalog.Entry, opts *hexalog.RequestOptions, retry *RetryOptions) (eid []byte, stats *WriteStats, err error) {
+// ProposeEntry finds locations for the entry and proposes it to those
+// locations.  The proposal is sent to the highest priority live participant
+// falling through to the next one on transport errors.  It retries the
+// specified number of times before returning.  The entry is signed in place
+// if a signing key is configured.  It returns a an entry id on success and
+// error otherwise
+func (hexlog *Hexalog) ProposeEntry(entry *hexalog.Entry, opts *hexalog.RequestOptions, retry *RetryOptions) ([]byte, *WriteStats, error) {
+	return hexlog.ProposeEntryContext(context.Background(), entry, opts, retry)
+}
+
+// ProposeEntryContext is the same as ProposeEntry but aborts when the context
+// is done.  The context is passed down to the transport
+func (hexlog *Hexalog) ProposeEntryContext(ctx context.Context, entry *hexalog.Entry, opts *hexalog.RequestOptions, retry *RetryOptions) (eid []byte, stats *WriteStats, err error) {
+	ctx, span := startSpan(ctx, "Hexalog.ProposeEntry", entry.Key, attribute.Int64("phi.height", int64(entry.Height)))
+	defer func() {
+		if stats != nil {
+			span.SetAttributes(
+				attribute.Int64("phi.ballot_time_ns", stats.BallotTime.Nanoseconds()),
+				attribute.Int64("phi.apply_time_ns", stats.ApplyTime.Nanoseconds()),
+			)
+		}
+		endSpan(span, err)
+	}()
+
 	if retry == nil {
 		retry = DefaultRetryOptions()
 	} else {
-		if retry.Retries < 1 {
-			retry.Retries = 1
-		}
+		retry.normalize()
+	}
 
-		if retry.RetryInterval == 0 {
-			retry.RetryInterval = 30 * time.Millisecond
-		}
+	if hexlog.signer != nil {
+		hexlog.signer.Sign(entry)
 	}
 
 	ps := len(opts.PeerSet)
 
 	for i := 0; i < retry.Retries; i++ {
-		log.Printf("[DEBUG] Proposing key=%s participants=%d try=%d/%d", entry.Key, ps, i, retry.Retries)
+		hexlog.logger.Debug("Proposing", "key", entry.Key, "participants", ps, "try", i, "retries", retry.Retries)
 		// Propose with retries.  Retry only on a ErrPreviousHash error
-		var resp *hexalog.ReqResp
-		if resp, err = hexlog.trans.ProposeEntry(context.Background(), opts.PeerSet[0].Host, entry, opts); err == nil {
+		if eid, stats, err = hexlog.propose(ctx, entry, opts); err != hexatype.ErrPreviousHash {
+			return
+		}
+		hexlog.metrics.previousHashRetry("propose")
+		span.AddEvent("previous hash mismatch")
+
+		// Keep the mismatch as the error once out of retries
+		if er := sleepContext(ctx, retry.RetryInterval); er != nil {
+			err = er
+			return
+		}
+	}
+
+	return
+}
+
+// Update performs a compare-and-set write on the key.  It fetches the latest
+// entry, calls fn with it to get the data for the next entry and proposes the
+// new entry.  If the proposal fails with ErrPreviousHash, the latest entry is
+// re-fetched and fn is called again.  The interval between tries doubles on
+// each failure.  It returns the id of the written entry
+func (hexlog *Hexalog) Update(key []byte, fn UpdateFunc, retry *RetryOptions) ([]byte, *WriteStats, error) {
+	return hexlog.UpdateContext(context.Background(), key, fn, retry)
+}
+
+// UpdateContext is the same as Update but aborts when the context is done
+func (hexlog *Hexalog) UpdateContext(ctx context.Context, key []byte, fn UpdateFunc, retry *RetryOptions) (eid []byte, stats *WriteStats, err error) {
+	ctx, span := startSpan(ctx, "Hexalog.Update", key)
+	defer func() { endSpan(span, err) }()
+
+	if retry == nil {
+		retry = DefaultRetryOptions()
+	} else {
+		retry.normalize()
+	}
+
+	interval := retry.RetryInterval
+
+	for i := 0; i < retry.Retries; i++ {
+		var (
+			prev  *hexalog.Entry
+			entry *hexalog.Entry
+			peers []*hexalog.Participant
+		)
+
+		if prev, entry, peers, err = hexlog.nextEntry(ctx, key); err != nil {
+			return
+		}
+
+		if entry.Data, err = fn(prev); err != nil {
+			return
+		}
+		if hexlog.signer != nil {
+			hexlog.signer.Sign(entry)
+		}
+
+		opts := hexalog.DefaultRequestOptions()
+		opts.PeerSet = peers
+		opts.WaitBallot = true
+		opts.WaitApply = true
+
+		hexlog.logger.Debug("Updating", "key", key, "height", entry.Height, "try", i, "retries", retry.Retries)
+		if eid, stats, err = hexlog.propose(ctx, entry, opts); err != hexatype.ErrPreviousHash {
+			return
+		}
+		hexlog.metrics.previousHashRetry("update")
+		span.AddEvent("previous hash mismatch")
+
+		// Keep the mismatch as the error once out of retries
+		if er := sleepContext(ctx, interval); er != nil {
+			err = er
+			return
+		}
+		interval *= 2
+	}
+
+	return
+}
+
+// participants returns the jury for the key tracing the selection
+func (hexlog *Hexalog) participants(ctx context.Context, key []byte) ([]*hexalog.Participant, error) {
+	_, span := startSpan(ctx, "Jury.Participants", key)
+	peers, err := hexlog.jury.Participants(key, hexlog.minVotes)
+	span.SetAttributes(attribute.Int("phi.participants", len(peers)))
+	endSpan(span, err)
+	return peers, err
+}
+
+// nextEntry returns the latest entry for the key along with the next entry to
+// be proposed and its participants.  The returned latest entry is nil if the
+// key does not exist
+func (hexlog *Hexalog) nextEntry(ctx context.Context, key []byte) (*hexalog.Entry, *hexalog.Entry, []*hexalog.Participant, error) {
+	prev, err := hexlog.GetLatestContext(ctx, key)
+	if err == hexatype.ErrKeyNotFound {
+		// First entry for the key
+		entry, peers, er := hexlog.NewEntryContext(ctx, key)
+		return nil, entry, peers, er
+	} else if err != nil {
+		return nil, nil, nil, err
+	}
 
-			eid = entry.Hash(hexlog.hashFunc())
-			stats = &WriteStats{
+	entry, peers, err := hexlog.NewEntryFromContext(ctx, prev)
+	return prev, entry, peers, err
+}
+
+// propose makes a single attempt to propose the entry.  Peers are tried in
+// order of priority skipping ones known to be dead.  It moves on to the next
+// peer only on a transport error
+func (hexlog *Hexalog) propose(ctx context.Context, entry *hexalog.Entry, opts *hexalog.RequestOptions) ([]byte, *WriteStats, error) {
+	if len(opts.PeerSet) == 0 {
+		return nil, nil, errEmptyPeerSet
+	}
+
+	peers := make([]*hexalog.Participant, len(opts.PeerSet))
+	copy(peers, opts.PeerSet)
+	sort.SliceStable(peers, func(i, j int) bool {
+		return peers[i].Priority < peers[j].Priority
+	})
+
+	err := errNoLivePeers
+	for _, p := range peers {
+		if er := ctx.Err(); er != nil {
+			return nil, nil, er
+		}
+
+		if hexlog.live != nil && hexlog.live.IsDead(p.ID) {
+			hexlog.logger.Debug("Skipping dead participant", "host", p.Host)
+			continue
+		}
+
+		resp, er := hexlog.trans.ProposeEntry(ctx, p.Host, entry, opts)
+		if er == nil {
+			stats := &WriteStats{
 				BallotTime:   time.Duration(resp.BallotTime),
 				ApplyTime:    time.Duration(resp.ApplyTime),
 				Participants: opts.PeerSet,
 			}
-			return
+			hexlog.metrics.writeStats(stats)
+			return entry.Hash(hexlog.hashFunc()), stats, nil
+		}
 
-		} else if err == hexatype.ErrPreviousHash {
-			time.Sleep(retry.RetryInterval)
-		} else {
-			return
+		hexlog.events.publish(&Event{
+			Type:   EventProposalFailed,
+			Host:   p.Host,
+			Key:    entry.Key,
+			Height: uint32(entry.Height),
+			Err:    er,
+		})
+
+		// Do not fail over when the caller gave up
+		if ctx.Err() != nil || !isTransportError(er) {
+			return nil, nil, er
 		}
 
+		hexlog.logger.Error("Failed to propose", "key", entry.Key, "host", p.Host, "err", er)
+		err = er
 	}
 
-	return
+	return nil, nil, err
+}
+
+// isTransportError returns true if the error is due to the peer being
+// unreachable rather than the proposal being rejected
+func isTransportError(err error) bool {
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+
+	if st, ok := status.FromError(err); ok {
+		switch st.Code() {
+		case codes.Unavailable, codes.DeadlineExceeded:
+			return true
+		}
+	}
+
+	return false
+}
+
+// sleepContext sleeps for the given duration returning early with the context
+// error if the context is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isZeroHash returns true if the hash is empty or all zeros i.e. there is no
+// previous entry
+func isZeroHash(h []byte) bool {
+	return len(h) == 0 || bytes.Equal(h, make([]byte, len(h)))
 }
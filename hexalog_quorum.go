@@ -0,0 +1,266 @@
+package phi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hexablock/hexalog"
+	"github.com/hexablock/hexatype"
+)
+
+var (
+	errQuorumNotReached = errors.New("read quorum not reached")
+	errRepairRemote     = errors.New("repair only supported on the local participant")
+)
+
+// ReadOptions for WAL reads
+type ReadOptions struct {
+	// Query a quorum of participants in parallel instead of returning the
+	// first entry any participant returns
+	Quorum bool
+
+	// Repair participants found to be lagging behind the quorum
+	Repair bool
+}
+
+// DefaultReadOptions returns a default set of ReadOptions
+func DefaultReadOptions() *ReadOptions {
+	return &ReadOptions{}
+}
+
+// walRepairer is optionally implemented by a WALTransport to bring a lagging
+// participant up to date for a key
+type walRepairer interface {
+	// CanRepair returns true if the participant at host can be repaired
+	CanRepair(host string) bool
+	RepairKey(ctx context.Context, host string, key []byte, opts *hexalog.RequestOptions) error
+}
+
+// peerTip is the last entry id and height for a key on a participant
+type peerTip struct {
+	peer   *hexalog.Participant
+	id     []byte
+	height uint32
+	entry  *hexalog.Entry
+	err    error
+}
+
+// SetReadOptions sets the options used for all reads
+func (hexlog *Hexalog) SetReadOptions(opts *ReadOptions) {
+	hexlog.readOpts = opts
+}
+
+// GetLatest returns the latest entry for the key.  With quorum reads enabled
+// the tip is resolved from a quorum of participants otherwise from the first
+// participant that responds.  It returns hexatype.ErrKeyNotFound if the key has
+// no entries
+func (hexlog *Hexalog) GetLatest(key []byte) (*hexalog.Entry, error) {
+	return hexlog.GetLatestContext(context.Background(), key)
+}
+
+// GetLatestContext is the same as GetLatest but aborts when the context is
+// done
+func (hexlog *Hexalog) GetLatestContext(ctx context.Context, key []byte) (*hexalog.Entry, error) {
+	peers, err := hexlog.participants(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if hexlog.readOpts != nil && hexlog.readOpts.Quorum {
+		return hexlog.getLatestQuorum(ctx, peers, key)
+	}
+
+	var tip *peerTip
+	for _, p := range peers {
+		if tip = hexlog.getTip(ctx, p, key); tip.err == nil {
+			return hexlog.getTipEntry(ctx, key, tip)
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+
+	if tip == nil {
+		return nil, errEmptyPeerSet
+	}
+	return nil, tip.err
+}
+
+// getLatestQuorum returns the highest entry of a quorum of participants
+func (hexlog *Hexalog) getLatestQuorum(ctx context.Context, peers []*hexalog.Participant, key []byte) (*hexalog.Entry, error) {
+	tips, err := hexlog.queryQuorum(ctx, peers, func(ctx context.Context, p *hexalog.Participant) *peerTip {
+		return hexlog.getTip(ctx, p, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Select the highest tip
+	var latest *peerTip
+	for _, tip := range tips {
+		if tip.err != nil {
+			continue
+		}
+
+		if latest == nil || tip.height > latest.height {
+			latest = tip
+		} else if tip.height == latest.height && !bytes.Equal(tip.id, latest.id) {
+			return nil, fmt.Errorf("diverged key=%s height=%d", key, tip.height)
+		}
+	}
+
+	if latest == nil {
+		return nil, tips[0].err
+	}
+
+	entry, err := hexlog.getTipEntry(ctx, key, latest)
+	if err != nil {
+		return nil, err
+	}
+
+	hexlog.repairLagging(key, peers, tips, latest.height)
+
+	return entry, nil
+}
+
+// getTip returns the last entry id and height for the key on the participant
+func (hexlog *Hexalog) getTip(ctx context.Context, p *hexalog.Participant, key []byte) *peerTip {
+	tip := &peerTip{peer: p}
+	var next *hexalog.Entry
+	if next, tip.err = hexlog.trans.NewEntry(ctx, p.Host, key, &hexalog.RequestOptions{}); tip.err == nil {
+		// The next entry points to the last one
+		tip.id = next.Previous
+		tip.height = uint32(next.Height) - 1
+	}
+	return tip
+}
+
+// getTipEntry gets the entry a tip points to from the participant
+func (hexlog *Hexalog) getTipEntry(ctx context.Context, key []byte, tip *peerTip) (*hexalog.Entry, error) {
+	if tip.height == 0 || isZeroHash(tip.id) {
+		return nil, hexatype.ErrKeyNotFound
+	}
+
+	entry, err := hexlog.trans.GetEntry(ctx, tip.peer.Host, key, tip.id, &hexalog.RequestOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(entry.Hash(hexlog.hashFunc()), tip.id) {
+		return nil, fmt.Errorf("hash mismatch key=%s id=%x", key, tip.id)
+	}
+	return entry, nil
+}
+
+// getEntryQuorum gets the entry from a quorum of participants in parallel.  The
+// first entry whose hash matches the id is returned.  Participants that
+// responded without the entry are considered lagging and repaired if enabled
+func (hexlog *Hexalog) getEntryQuorum(ctx context.Context, peers []*hexalog.Participant, key, id []byte) (*hexalog.Entry, error) {
+	results, err := hexlog.queryQuorum(ctx, peers, func(ctx context.Context, p *hexalog.Participant) *peerTip {
+		res := &peerTip{peer: p, id: id}
+		if res.entry, res.err = hexlog.trans.GetEntry(ctx, p.Host, key, id, &hexalog.RequestOptions{}); res.err == nil {
+			res.height = uint32(res.entry.Height)
+		}
+		return res
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var entry *hexalog.Entry
+	for _, res := range results {
+		if res.entry != nil && bytes.Equal(res.entry.Hash(hexlog.hashFunc()), id) {
+			entry = res.entry
+			break
+		}
+	}
+
+	if entry == nil {
+		return nil, hexatype.ErrEntryNotFound
+	}
+
+	hexlog.repairLagging(key, peers, results, uint32(entry.Height))
+
+	return entry, nil
+}
+
+// queryQuorum calls fn for each participant in parallel.  It returns once a
+// quorum of participants have responded.  Responses with transport errors do
+// not count towards the quorum.  An error is returned if a quorum cannot be
+// reached
+func (hexlog *Hexalog) queryQuorum(ctx context.Context, peers []*hexalog.Participant, fn func(context.Context, *hexalog.Participant) *peerTip) ([]*peerTip, error) {
+	if len(peers) == 0 {
+		return nil, errEmptyPeerSet
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Buffered so stragglers do not block after we return
+	ch := make(chan *peerTip, len(peers))
+	for _, p := range peers {
+		go func(p *hexalog.Participant) {
+			ch <- fn(ctx, p)
+		}(p)
+	}
+
+	quorum := len(peers)/2 + 1
+	out := make([]*peerTip, 0, len(peers))
+
+	var err error
+	for i := 0; i < len(peers); i++ {
+		var res *peerTip
+		select {
+		case res = <-ch:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		if res.err != nil && isTransportError(res.err) {
+			hexlog.logger.Debug("Quorum read failed", "host", res.peer.Host, "err", res.err)
+			err = res.err
+			continue
+		}
+
+		if out = append(out, res); len(out) >= quorum {
+			return out, nil
+		}
+	}
+
+	if err == nil {
+		return nil, errQuorumNotReached
+	}
+	return nil, fmt.Errorf("%v: %v", errQuorumNotReached, err)
+}
+
+// repairLagging repairs participants whose height is below the given height
+// in the background.  Only participants the transport can repair i.e. the
+// local one are repaired.  It is a no-op if repair is not enabled or the
+// transport does not support it
+func (hexlog *Hexalog) repairLagging(key []byte, peers []*hexalog.Participant, tips []*peerTip, height uint32) {
+	if hexlog.readOpts == nil || !hexlog.readOpts.Repair {
+		return
+	}
+
+	repairer, ok := hexlog.trans.(walRepairer)
+	if !ok {
+		return
+	}
+
+	opts := hexalog.DefaultRequestOptions()
+	opts.PeerSet = peers
+
+	for _, tip := range tips {
+		if tip.height >= height || !repairer.CanRepair(tip.peer.Host) {
+			continue
+		}
+
+		go func(host string) {
+			hexlog.logger.Info("Repairing", "key", key, "host", host, "height", height)
+			if err := repairer.RepairKey(context.Background(), host, key, opts); err != nil {
+				hexlog.logger.Debug("Repair failed", "key", key, "host", host, "err", err)
+			}
+		}(tip.peer.Host)
+	}
+}
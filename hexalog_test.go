@@ -0,0 +1,307 @@
+package phi
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/hexablock/hexalog"
+	"github.com/hexablock/hexatype"
+)
+
+type testWALTransport struct {
+	down     map[string]bool
+	proposed []string
+	// Entries per host in height order
+	logs map[string][]*hexalog.Entry
+
+	// Host that can be repaired and hosts repairs were requested for
+	local    string
+	repaired chan string
+}
+
+func newTestWALTransport() *testWALTransport {
+	return &testWALTransport{
+		down: make(map[string]bool),
+		logs: make(map[string][]*hexalog.Entry),
+	}
+}
+
+// appendEntries appends n entries for the key to the given hosts
+func (trans *testWALTransport) appendEntries(key []byte, n int, hosts ...string) {
+	for _, host := range hosts {
+		for i := 0; i < n; i++ {
+			next, _ := trans.NewEntry(context.Background(), host, key, nil)
+			next.Data = []byte(fmt.Sprintf("%d", next.Height))
+			trans.logs[host] = append(trans.logs[host], next)
+		}
+	}
+}
+
+func (trans *testWALTransport) NewEntry(ctx context.Context, host string, key []byte, opts *hexalog.RequestOptions) (*hexalog.Entry, error) {
+	if trans.down[host] {
+		return nil, status.Error(codes.Unavailable, "down")
+	}
+
+	l := trans.logs[host]
+	if len(l) == 0 {
+		return &hexalog.Entry{Key: key, Previous: make([]byte, 32), Height: 1}, nil
+	}
+
+	last := l[len(l)-1]
+	return &hexalog.Entry{Key: key, Previous: last.Hash(sha256.New()), Height: last.Height + 1}, nil
+}
+
+func (trans *testWALTransport) ProposeEntry(ctx context.Context, host string, entry *hexalog.Entry, opts *hexalog.RequestOptions) (*hexalog.ReqResp, error) {
+	trans.proposed = append(trans.proposed, host)
+	if trans.down[host] {
+		return nil, status.Error(codes.Unavailable, "down")
+	}
+	return &hexalog.ReqResp{}, nil
+}
+
+func (trans *testWALTransport) GetEntry(ctx context.Context, host string, key []byte, id []byte, opts *hexalog.RequestOptions) (*hexalog.Entry, error) {
+	if trans.down[host] {
+		return nil, status.Error(codes.Unavailable, "down")
+	}
+
+	for _, e := range trans.logs[host] {
+		if bytes.Equal(e.Hash(sha256.New()), id) {
+			return e, nil
+		}
+	}
+	return nil, hexatype.ErrEntryNotFound
+}
+
+func (trans *testWALTransport) CanRepair(host string) bool {
+	return host == trans.local
+}
+
+func (trans *testWALTransport) RepairKey(ctx context.Context, host string, key []byte, opts *hexalog.RequestOptions) error {
+	trans.repaired <- host
+	return nil
+}
+
+type testJury struct {
+	peers []*hexalog.Participant
+}
+
+func (jury *testJury) Participants(key []byte, min int) ([]*hexalog.Participant, error) {
+	return jury.peers, nil
+}
+
+func (jury *testJury) RegisterDHT(dht DHT) {}
+
+func newTestJury(n int) *testJury {
+	jury := &testJury{peers: make([]*hexalog.Participant, n)}
+	for i := range jury.peers {
+		jury.peers[i] = &hexalog.Participant{
+			ID:       []byte(fmt.Sprintf("id%d", i)),
+			Host:     fmt.Sprintf("host%d", i),
+			Priority: int32(i),
+		}
+	}
+	return jury
+}
+
+type testLiveness map[string]bool
+
+func (live testLiveness) IsDead(id []byte) bool {
+	return live[string(id)]
+}
+
+func Test_Hexalog_ProposeEntry_failover(t *testing.T) {
+	trans := newTestWALTransport()
+	trans.down["host1"] = true
+	wal := NewHexalog(trans, 2, sha256.New)
+	wal.RegisterLiveness(testLiveness{"id0": true})
+
+	opts := hexalog.DefaultRequestOptions()
+	opts.PeerSet = []*hexalog.Participant{
+		{ID: []byte("id2"), Host: "host2", Priority: 2},
+		{ID: []byte("id1"), Host: "host1", Priority: 1},
+		{ID: []byte("id0"), Host: "host0", Priority: 0},
+	}
+
+	entry := &hexalog.Entry{Key: []byte("key"), Height: 1}
+	if _, _, err := wal.ProposeEntry(entry, opts, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(trans.proposed) != 2 {
+		t.Fatalf("proposals want=2 have=%d", len(trans.proposed))
+	}
+	if trans.proposed[0] != "host1" || trans.proposed[1] != "host2" {
+		t.Fatal("wrong proposal order", trans.proposed)
+	}
+
+	opts.PeerSet = nil
+	if _, _, err := wal.ProposeEntry(entry, opts, nil); err != errEmptyPeerSet {
+		t.Fatal("should fail with", errEmptyPeerSet, err)
+	}
+}
+
+func Test_Hexalog_ProposeEntryContext(t *testing.T) {
+	trans := newTestWALTransport()
+	wal := NewHexalog(trans, 2, sha256.New)
+
+	opts := hexalog.DefaultRequestOptions()
+	opts.PeerSet = []*hexalog.Participant{{ID: []byte("id0"), Host: "host0"}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	entry := &hexalog.Entry{Key: []byte("key"), Height: 1}
+	if _, _, err := wal.ProposeEntryContext(ctx, entry, opts, nil); err != context.Canceled {
+		t.Fatal("should fail with", context.Canceled, err)
+	}
+	if len(trans.proposed) != 0 {
+		t.Fatal("should not propose with a cancelled context")
+	}
+}
+
+func Test_Hexalog_GetLatest(t *testing.T) {
+	key := []byte("key")
+	trans := newTestWALTransport()
+	wal := NewHexalog(trans, 2, sha256.New)
+	wal.RegisterJury(newTestJury(3))
+
+	if _, err := wal.GetLatest(key); err != hexatype.ErrKeyNotFound {
+		t.Fatal("should fail with", hexatype.ErrKeyNotFound, err)
+	}
+
+	// host0 is lagging
+	trans.appendEntries(key, 2, "host0")
+	trans.appendEntries(key, 3, "host1", "host2")
+
+	// Without quorum reads the first participant is trusted
+	latest, err := wal.GetLatest(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Height != 2 {
+		t.Fatalf("height want=2 have=%d", latest.Height)
+	}
+
+	// host2 being down makes host0 part of the quorum
+	trans = newTestWALTransport()
+	trans.appendEntries(key, 2, "host0")
+	trans.appendEntries(key, 3, "host1")
+	trans.down["host2"] = true
+	trans.local = "host0"
+	trans.repaired = make(chan string, 3)
+
+	wal = NewHexalog(trans, 2, sha256.New)
+	wal.RegisterJury(newTestJury(3))
+	wal.SetReadOptions(&ReadOptions{Quorum: true, Repair: true})
+
+	if latest, err = wal.GetLatest(key); err != nil {
+		t.Fatal(err)
+	}
+	if latest.Height != 3 {
+		t.Fatalf("height want=3 have=%d", latest.Height)
+	}
+	// Only the local participant is repaired
+	if host := <-trans.repaired; host != "host0" {
+		t.Fatal("wrong host repaired", host)
+	}
+
+	// No quorum
+	trans = newTestWALTransport()
+	trans.appendEntries(key, 3, "host0")
+	trans.down["host1"] = true
+	trans.down["host2"] = true
+
+	wal = NewHexalog(trans, 2, sha256.New)
+	wal.RegisterJury(newTestJury(3))
+	wal.SetReadOptions(&ReadOptions{Quorum: true})
+	if _, err = wal.GetLatest(key); err == nil {
+		t.Fatal("should fail without quorum")
+	}
+}
+
+func Test_Hexalog_History(t *testing.T) {
+	key := []byte("key")
+	trans := newTestWALTransport()
+	trans.appendEntries(key, 5, "host0", "host1", "host2")
+
+	wal := NewHexalog(trans, 2, sha256.New)
+	wal.RegisterJury(newTestJury(3))
+
+	iter, err := wal.History(key, 2, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var heights []uint32
+	for iter.Next() {
+		heights = append(heights, uint32(iter.Entry().Height))
+	}
+	if err = iter.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(heights) != "[2 3 4]" {
+		t.Fatal("wrong heights", heights)
+	}
+
+	if _, err = wal.History(key, 4, 2); err == nil {
+		t.Fatal("should fail with an invalid range")
+	}
+}
+
+// testIndex returns the ids of the first n entries of the key on host
+type testIndex struct {
+	trans *testWALTransport
+	host  string
+	n     int
+}
+
+func (idx *testIndex) EntryIDs(key []byte, fromHeight, toHeight uint32) ([][]byte, error) {
+	if idx.n == 0 {
+		return nil, hexatype.ErrKeyNotFound
+	}
+
+	out := make([][]byte, 0)
+	for _, e := range idx.trans.logs[idx.host][:idx.n] {
+		if e.Height >= fromHeight && (toHeight == 0 || e.Height <= toHeight) {
+			out = append(out, e.Hash(sha256.New()))
+		}
+	}
+	return out, nil
+}
+
+func Test_Hexalog_History_index(t *testing.T) {
+	key := []byte("key")
+	trans := newTestWALTransport()
+	trans.appendEntries(key, 5, "host0", "host1", "host2")
+
+	wal := NewHexalog(trans, 2, sha256.New)
+	wal.RegisterJury(newTestJury(3))
+
+	// Complete, lagging and missing local index
+	for _, n := range []int{5, 3, 0} {
+		wal.RegisterIndex(&testIndex{trans: trans, host: "host0", n: n})
+
+		iter, err := wal.History(key, 2, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var heights []uint32
+		for iter.Next() {
+			heights = append(heights, uint32(iter.Entry().Height))
+		}
+		if err = iter.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(heights) != "[2 3 4 5]" {
+			t.Fatalf("index=%d: wrong heights %v", n, heights)
+		}
+	}
+}
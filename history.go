@@ -0,0 +1,277 @@
+package phi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/hexablock/hexalog"
+)
+
+// EntryIndex implements an interface to get entry ids for a key in height order
+type EntryIndex interface {
+	EntryIDs(key []byte, fromHeight, toHeight uint32) ([][]byte, error)
+}
+
+// EntryIterator iterates over the entries of a key in ascending height order.
+// Each entry is verified against the hash chain before being returned
+type EntryIterator struct {
+	ctx    context.Context
+	hexlog *Hexalog
+	key    []byte
+
+	// Entry ids to fetch when iterating forward from the index
+	ids [][]byte
+	// Entries already fetched when walking backwards.  These follow the ids
+	entries []*hexalog.Entry
+
+	// Hash of the last returned entry
+	last []byte
+
+	entry *hexalog.Entry
+	err   error
+}
+
+// Next advances the iterator to the next entry.  It returns false when there
+// are no more entries or an error occurred
+func (iter *EntryIterator) Next() bool {
+	if iter.err != nil {
+		return false
+	}
+
+	var (
+		entry *hexalog.Entry
+		id    []byte
+	)
+
+	switch {
+	case len(iter.ids) > 0:
+		id = iter.ids[0]
+		iter.ids = iter.ids[1:]
+
+		if entry, iter.err = iter.hexlog.GetEntryContext(iter.ctx, iter.key, id); iter.err != nil {
+			return false
+		}
+		if !bytes.Equal(entry.Hash(iter.hexlog.hashFunc()), id) {
+			iter.err = fmt.Errorf("hash mismatch key=%s id=%x", iter.key, id)
+			return false
+		}
+
+	case len(iter.entries) > 0:
+		entry = iter.entries[0]
+		iter.entries = iter.entries[1:]
+		id = entry.Hash(iter.hexlog.hashFunc())
+
+	default:
+		iter.entry = nil
+		return false
+	}
+
+	if iter.last != nil && !bytes.Equal(entry.Previous, iter.last) {
+		iter.err = fmt.Errorf("broken chain key=%s height=%d", iter.key, entry.Height)
+		return false
+	}
+
+	iter.last = id
+	iter.entry = entry
+	return true
+}
+
+// Entry returns the current entry
+func (iter *EntryIterator) Entry() *hexalog.Entry {
+	return iter.entry
+}
+
+// Err returns the error encountered during iteration if any
+func (iter *EntryIterator) Err() error {
+	return iter.err
+}
+
+// RegisterIndex registers an index used to iterate forward over a keys history.
+// Without an index history is built walking backwards from the latest entry.
+// Entries newer than the last one in the index are fetched from the
+// participants
+func (hexlog *Hexalog) RegisterIndex(index EntryIndex) {
+	hexlog.index = index
+}
+
+// History returns an iterator over the entries of the key from fromHeight to
+// toHeight inclusive.  A toHeight of zero iterates up to the latest entry
+func (hexlog *Hexalog) History(key []byte, fromHeight, toHeight uint32) (*EntryIterator, error) {
+	return hexlog.HistoryContext(context.Background(), key, fromHeight, toHeight)
+}
+
+// HistoryContext is the same as History but aborts when the context is done.
+// The context is used for the lifetime of the iterator
+func (hexlog *Hexalog) HistoryContext(ctx context.Context, key []byte, fromHeight, toHeight uint32) (*EntryIterator, error) {
+	if fromHeight == 0 {
+		fromHeight = 1
+	}
+	if toHeight != 0 && toHeight < fromHeight {
+		return nil, fmt.Errorf("invalid height range %d-%d", fromHeight, toHeight)
+	}
+
+	iter := &EntryIterator{ctx: ctx, hexlog: hexlog, key: key}
+
+	// The local index lags behind or does not have the key at all if the
+	// local node is not a participant.  It is only used up to the last id it
+	// has in common with the participants
+	var last []byte
+	if hexlog.index != nil {
+		ids, err := hexlog.index.EntryIDs(key, fromHeight, toHeight)
+		if err != nil {
+			hexlog.logger.Debug("Index lookup failed", "key", key, "err", err)
+		} else if len(ids) > 0 {
+			iter.ids = ids
+			last = ids[len(ids)-1]
+		}
+	}
+
+	entries, found, err := hexlog.walkBack(ctx, key, fromHeight, toHeight, last)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		iter.ids = nil
+	}
+	iter.entries = entries
+
+	return iter, nil
+}
+
+// walkBack fetches the entries from the latest entry down to fromHeight by
+// following the previous hashes.  It stops before the entry with the stop id if
+// given and reports whether it was found.  The returned entries are in
+// ascending height order
+func (hexlog *Hexalog) walkBack(ctx context.Context, key []byte, fromHeight, toHeight uint32, stop []byte) ([]*hexalog.Entry, bool, error) {
+	entry, err := hexlog.GetLatestContext(ctx, key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if toHeight == 0 || toHeight > uint32(entry.Height) {
+		toHeight = uint32(entry.Height)
+	}
+
+	out := make([]*hexalog.Entry, 0)
+	found := false
+	for {
+		height := uint32(entry.Height)
+		if stop != nil && bytes.Equal(entry.Hash(hexlog.hashFunc()), stop) {
+			found = true
+			break
+		}
+		if height < fromHeight {
+			break
+		}
+		if height <= toHeight {
+			out = append(out, entry)
+		}
+		if height == fromHeight || isZeroHash(entry.Previous) {
+			break
+		}
+
+		id := entry.Previous
+		if entry, err = hexlog.GetEntryContext(ctx, key, id); err != nil {
+			return nil, false, err
+		}
+		if !bytes.Equal(entry.Hash(hexlog.hashFunc()), id) {
+			return nil, false, fmt.Errorf("hash mismatch key=%s id=%x", key, id)
+		}
+		if uint32(entry.Height) != height-1 {
+			return nil, false, fmt.Errorf("broken chain key=%s height=%d", key, entry.Height)
+		}
+	}
+
+	// Reverse to ascending order
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+
+	return out, found, nil
+}
+
+// logIndex implements an EntryIndex using the local hexalog index store
+type logIndex struct {
+	store hexalog.IndexStore
+
+	// Height per key below which entries have been compacted
+	mu     sync.RWMutex
+	floors map[string]uint32
+}
+
+func newLogIndex(store hexalog.IndexStore) *logIndex {
+	return &logIndex{store: store, floors: make(map[string]uint32)}
+}
+
+// setFloor sets the lowest available height for the key after compaction
+func (idx *logIndex) setFloor(key []byte, height uint32) {
+	idx.mu.Lock()
+	idx.floors[string(key)] = height
+	idx.mu.Unlock()
+}
+
+func (idx *logIndex) copyFloors() map[string]uint32 {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	out := make(map[string]uint32, len(idx.floors))
+	for k, v := range idx.floors {
+		out[k] = v
+	}
+	return out
+}
+
+func (idx *logIndex) floor(key []byte) uint32 {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.floors[string(key)]
+}
+
+// EntryIDs returns the entry ids for the key between the heights inclusive. A
+// toHeight of zero returns all ids from fromHeight.  Compacted entries are not
+// returned
+func (idx *logIndex) EntryIDs(key []byte, fromHeight, toHeight uint32) ([][]byte, error) {
+	if floor := idx.floor(key); fromHeight < floor {
+		fromHeight = floor
+	}
+
+	kli, err := idx.store.GetKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([][]byte, 0)
+	var height uint32
+
+	err = kli.Iter(nil, func(id []byte) error {
+		height++
+		if height < fromHeight || (toHeight != 0 && height > toHeight) {
+			return nil
+		}
+
+		cid := make([]byte, len(id))
+		copy(cid, id)
+		out = append(out, cid)
+		return nil
+	})
+
+	return out, err
+}
+
+// keys returns all keys in the local index with the given prefix
+func (idx *logIndex) keys(prefix []byte) ([][]byte, error) {
+	out := make([][]byte, 0)
+
+	err := idx.store.Iter(func(key []byte, kli hexalog.KeylogIndex) error {
+		if bytes.HasPrefix(key, prefix) {
+			k := make([]byte, len(key))
+			copy(k, key)
+			out = append(out, k)
+		}
+		return nil
+	})
+
+	return out, err
+}
@@ -0,0 +1,68 @@
+// Package blockio contains io helpers shared by the packages storing data as
+// blox indexes
+package blockio
+
+import (
+	"errors"
+	"io"
+
+	"github.com/hexablock/blox"
+)
+
+var errRangeDone = errors.New("range done")
+
+// CountReader counts the bytes read
+type CountReader struct {
+	R io.Reader
+	N int64
+}
+
+func (cr *CountReader) Read(p []byte) (int, error) {
+	n, err := cr.R.Read(p)
+	cr.N += int64(n)
+	return n, err
+}
+
+// ReadRange writes length bytes of the index data starting at offset to the
+// writer.  The range must be within the data.  It returns the number of bytes
+// written
+func ReadRange(blx *blox.Blox, id []byte, offset, length int64, w io.Writer) (int64, error) {
+	rw := &rangeWriter{w: w, skip: offset, remaining: length}
+	if err := blx.ReadIndex(id, rw, 1); err != nil && rw.remaining > 0 {
+		return length - rw.remaining, err
+	}
+	return length, nil
+}
+
+// rangeWriter writes only the requested range to the underlying writer.  It
+// returns errRangeDone once the range has been written to stop the read
+type rangeWriter struct {
+	w         io.Writer
+	skip      int64
+	remaining int64
+}
+
+func (rw *rangeWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if rw.remaining == 0 {
+		return 0, errRangeDone
+	}
+
+	if rw.skip >= int64(len(p)) {
+		rw.skip -= int64(len(p))
+		return n, nil
+	}
+	p = p[rw.skip:]
+	rw.skip = 0
+
+	if int64(len(p)) > rw.remaining {
+		p = p[:rw.remaining]
+	}
+
+	written, err := rw.w.Write(p)
+	rw.remaining -= int64(written)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,47 @@
+package blockio
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func Test_rangeWriter(t *testing.T) {
+	data := "0123456789abcdefghij"
+
+	for _, tc := range []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, 5, "01234"},
+		{3, 4, "3456"},
+		{8, 6, "89abcd"},
+		{15, 5, "fghij"},
+	} {
+		buf := new(bytes.Buffer)
+		rw := &rangeWriter{w: buf, skip: tc.offset, remaining: tc.length}
+
+		// Write in chunks to cross boundaries
+		var err error
+		rd := strings.NewReader(data)
+		chunk := make([]byte, 4)
+		for {
+			var n int
+			if n, err = rd.Read(chunk); err == io.EOF {
+				err = nil
+				break
+			}
+			if _, err = rw.Write(chunk[:n]); err != nil {
+				break
+			}
+		}
+
+		if err != nil && err != errRangeDone {
+			t.Fatal(err)
+		}
+		if buf.String() != tc.want {
+			t.Fatalf("offset=%d length=%d want=%s have=%s", tc.offset, tc.length, tc.want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Package waltest contains an in-memory WAL for testing the packages built on
+// the phi WAL
+package waltest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/hexablock/hexalog"
+	"github.com/hexablock/hexatype"
+	"github.com/hexablock/phi"
+)
+
+// WAL is a single node wal holding the entries of each key in memory.  Only
+// the update and latest entry methods are implemented
+type WAL struct {
+	phi.WAL
+
+	// Optional hook called before each update.  A non-nil error fails the
+	// update without writing an entry
+	Fail func(key []byte) error
+
+	// Optional FSM each written entry is applied to.  Entries are applied
+	// without an entry id
+	FSM phi.FSM
+
+	mu   sync.Mutex
+	logs map[string][]*hexalog.Entry
+}
+
+// New returns an empty WAL
+func New() *WAL {
+	return &WAL{logs: make(map[string][]*hexalog.Entry)}
+}
+
+// Update satisfies the phi.WAL interface
+func (wal *WAL) Update(key []byte, fn phi.UpdateFunc, retry *phi.RetryOptions) ([]byte, *phi.WriteStats, error) {
+	return wal.UpdateContext(context.Background(), key, fn, retry)
+}
+
+// UpdateContext satisfies the phi.WAL interface
+func (wal *WAL) UpdateContext(ctx context.Context, key []byte, fn phi.UpdateFunc, retry *phi.RetryOptions) ([]byte, *phi.WriteStats, error) {
+	if wal.Fail != nil {
+		if err := wal.Fail(key); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// The update function may read the wal so it is called without holding
+	// the lock
+	prev, _ := wal.GetLatestContext(ctx, key)
+	data, err := fn(prev)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+
+	l := wal.logs[string(key)]
+	entry := &hexalog.Entry{Key: key, Height: 1, Data: data}
+	if len(l) > 0 {
+		entry.Height = l[len(l)-1].Height + 1
+	}
+	wal.logs[string(key)] = append(l, entry)
+
+	if wal.FSM != nil {
+		wal.FSM.Apply(nil, entry)
+	}
+
+	return nil, &phi.WriteStats{}, nil
+}
+
+// GetLatest satisfies the phi.WAL interface
+func (wal *WAL) GetLatest(key []byte) (*hexalog.Entry, error) {
+	return wal.GetLatestContext(context.Background(), key)
+}
+
+// GetLatestContext satisfies the phi.WAL interface
+func (wal *WAL) GetLatestContext(ctx context.Context, key []byte) (*hexalog.Entry, error) {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+
+	l := wal.logs[string(key)]
+	if len(l) == 0 {
+		return nil, hexatype.ErrKeyNotFound
+	}
+	return l[len(l)-1], nil
+}
@@ -0,0 +1,42 @@
+package kv
+
+import "errors"
+
+// Operation types stored in the entry data
+const (
+	opPut byte = iota + 1
+	opDelete
+)
+
+// Value storage types
+const (
+	// Value is stored in the entry
+	valInline byte = iota
+	// Entry contains the blox index id of the value
+	valBlock
+)
+
+var errInvalidData = errors.New("invalid entry data")
+
+// encodeOp encodes an operation as entry data
+func encodeOp(op, kind byte, payload []byte) []byte {
+	b := make([]byte, 2+len(payload))
+	b[0] = op
+	b[1] = kind
+	copy(b[2:], payload)
+	return b
+}
+
+// decodeOp decodes entry data into its operation, value kind and payload
+func decodeOp(data []byte) (op, kind byte, payload []byte, err error) {
+	if len(data) < 2 {
+		err = errInvalidData
+		return
+	}
+
+	op, kind, payload = data[0], data[1], data[2:]
+	if op != opPut && op != opDelete {
+		err = errInvalidData
+	}
+	return
+}
@@ -0,0 +1,128 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hexablock/hexalog"
+	"github.com/hexablock/phi"
+)
+
+// storedPair is a pair as held by the fsm.  The value is either inline or a
+// blox index id
+type storedPair struct {
+	Key     []byte
+	Value   []byte
+	BlockID []byte
+	Version uint32
+}
+
+// FSM implements a phi.SnapshotFSM holding the latest value of each key this
+// node is a participant for
+type FSM struct {
+	// Namespace prefix stripped from wal keys
+	ns []byte
+
+	mu    sync.RWMutex
+	pairs map[string]*storedPair
+
+	dht phi.DHT
+
+	logger phi.Logger
+}
+
+// NewFSM returns a new FSM for keys in the given namespace
+func NewFSM(ns []byte) *FSM {
+	return &FSM{ns: ns, pairs: make(map[string]*storedPair), logger: phi.NewStdLogger()}
+}
+
+// Apply applies a put or delete entry
+func (fsm *FSM) Apply(entryID []byte, entry *hexalog.Entry) interface{} {
+	op, kind, payload, err := decodeOp(entry.Data)
+	if err != nil {
+		fsm.logger.Error("KV apply failed", "key", string(entry.Key), "err", err)
+		return err
+	}
+
+	key := bytes.TrimPrefix(entry.Key, fsm.ns)
+
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+
+	if op == opDelete {
+		delete(fsm.pairs, string(key))
+		return nil
+	}
+
+	pair := &storedPair{Key: key, Version: uint32(entry.Height)}
+	if kind == valBlock {
+		pair.BlockID = payload
+	} else {
+		pair.Value = payload
+	}
+	fsm.pairs[string(key)] = pair
+
+	return nil
+}
+
+// RegisterDHT satisfies the phi.FSM interface
+func (fsm *FSM) RegisterDHT(dht phi.DHT) {
+	fsm.dht = dht
+}
+
+// list returns pairs with the given prefix sorted by key
+func (fsm *FSM) list(prefix []byte) []*storedPair {
+	fsm.mu.RLock()
+	out := make([]*storedPair, 0)
+	for k, p := range fsm.pairs {
+		if strings.HasPrefix(k, string(prefix)) {
+			out = append(out, p)
+		}
+	}
+	fsm.mu.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		return bytes.Compare(out[i].Key, out[j].Key) < 0
+	})
+	return out
+}
+
+// Snapshot writes all pairs to the writer
+func (fsm *FSM) Snapshot(w io.Writer) error {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+
+	enc := json.NewEncoder(w)
+	for _, p := range fsm.pairs {
+		if err := enc.Encode(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore replaces all pairs with the ones from the reader
+func (fsm *FSM) Restore(r io.Reader) error {
+	pairs := make(map[string]*storedPair)
+
+	dec := json.NewDecoder(r)
+	for {
+		var p storedPair
+		if err := dec.Decode(&p); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		pairs[string(p.Key)] = &p
+	}
+
+	fsm.mu.Lock()
+	fsm.pairs = pairs
+	fsm.mu.Unlock()
+
+	return nil
+}
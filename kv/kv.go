@@ -0,0 +1,329 @@
+// Package kv implements a consistent distributed key-value store on top of the
+// phi WAL.  Each key is a WAL key within a namespace and every write is a
+// compare-and-set entry.  The version of a key is the height of its latest
+// entry.  Values larger than the configured inline size are written to the
+// block device and only the blox index id is stored in the WAL.
+package kv
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/hexablock/blox"
+	"github.com/hexablock/blox/block"
+	"github.com/hexablock/hexalog"
+	"github.com/hexablock/hexatype"
+	"github.com/hexablock/phi"
+)
+
+var (
+	// ErrNotFound is returned when a key does not exist or has been deleted
+	ErrNotFound = errors.New("key not found")
+
+	// ErrVersionMismatch is returned when a CAS version does not match the
+	// current version of the key
+	ErrVersionMismatch = errors.New("version mismatch")
+)
+
+// Values on the block device are prefixed with a random nonce so their blocks
+// are never shared with other values and can be removed if the write fails
+const nonceSize = 16
+
+// Pair is a key-value pair with the version it was read at
+type Pair struct {
+	Key     []byte
+	Value   []byte
+	Version uint32
+}
+
+// Config holds the kv store config
+type Config struct {
+	// Prefix for all wal keys used by the store
+	Namespace []byte
+
+	// Values larger than this are stored on the block device
+	MaxInlineSize int
+
+	// Retry options used for writes
+	Retry *phi.RetryOptions
+
+	// Structured logger.  Defaults to phi.NewStdLogger if nil
+	Logger phi.Logger
+}
+
+// DefaultConfig returns a default config
+func DefaultConfig() *Config {
+	return &Config{
+		Namespace:     []byte("kv/"),
+		MaxInlineSize: 1024,
+		Retry: &phi.RetryOptions{
+			Retries:       3,
+			RetryInterval: 30 * time.Millisecond,
+		},
+		Logger: phi.NewStdLogger(),
+	}
+}
+
+// KV is a distributed key-value store
+type KV struct {
+	conf *Config
+
+	wal phi.WAL
+	dev blox.BlockDevice
+	blx *blox.Blox
+
+	fsm *FSM
+}
+
+// New returns a kv store using the given wal and block device.  The store's
+// FSM must be registered with phi for its namespace e.g. using an FSMRouter
+func New(conf *Config, wal phi.WAL, dev blox.BlockDevice) *KV {
+	if conf.Logger == nil {
+		conf.Logger = phi.NewStdLogger()
+	}
+
+	fsm := NewFSM(conf.Namespace)
+	fsm.logger = conf.Logger
+
+	return &KV{
+		conf: conf,
+		wal:  wal,
+		dev:  dev,
+		blx:  blox.NewBlox(dev),
+		fsm:  fsm,
+	}
+}
+
+// FSM returns the store's FSM to be registered with phi
+func (kv *KV) FSM() *FSM {
+	return kv.fsm
+}
+
+// Get returns the latest value of the key.  It is read from a quorum of
+// participants only when quorum reads are enabled in the phi WALRead config
+func (kv *KV) Get(key []byte) (*Pair, error) {
+	entry, err := kv.wal.GetLatest(kv.walKey(key))
+	if err == hexatype.ErrKeyNotFound {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return kv.pairFromEntry(key, entry)
+}
+
+// Put sets the value for the key regardless of its current version.  It
+// returns the new version
+func (kv *KV) Put(key, value []byte) (uint32, error) {
+	return kv.write(key, value, nil)
+}
+
+// CAS sets the value for the key only if its current version matches the given
+// one.  A version of zero requires the key to not exist.  It returns the new
+// version
+func (kv *KV) CAS(key, value []byte, version uint32) (uint32, error) {
+	return kv.write(key, value, &version)
+}
+
+// UpdateFunc is called with the current pair, or nil if the key does not exist,
+// and returns the new value
+type UpdateFunc func(current *Pair) ([]byte, error)
+
+// Update performs an atomic read-modify-write of the key.  fn may be called
+// multiple times if there are concurrent writers.  It returns the new version
+func (kv *KV) Update(key []byte, fn UpdateFunc) (uint32, error) {
+	var (
+		height  uint32
+		spilled []*block.IndexBlock
+	)
+	_, _, err := kv.wal.Update(kv.walKey(key), func(prev *hexalog.Entry) ([]byte, error) {
+		var current *Pair
+		height = 1
+		if prev != nil {
+			height = uint32(prev.Height) + 1
+
+			var err error
+			if current, err = kv.pairFromEntry(key, prev); err == ErrNotFound {
+				current = nil
+			} else if err != nil {
+				return nil, err
+			}
+		}
+
+		value, err := fn(current)
+		if err != nil {
+			return nil, err
+		}
+
+		data, idx, err := kv.encodeValue(value)
+		if idx != nil {
+			spilled = append(spilled, idx)
+		}
+		return data, err
+	}, kv.conf.Retry)
+
+	// Only the value of the last attempt is written on success
+	if err == nil && len(spilled) > 0 {
+		spilled = spilled[:len(spilled)-1]
+	}
+	for _, idx := range spilled {
+		kv.removeValue(idx)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+	return height, nil
+}
+
+// Delete deletes the key
+func (kv *KV) Delete(key []byte) error {
+	data := encodeOp(opDelete, valInline, nil)
+	_, _, err := kv.wal.Update(kv.walKey(key), func(prev *hexalog.Entry) ([]byte, error) {
+		if prev == nil || isDeleted(prev) {
+			return nil, ErrNotFound
+		}
+		return data, nil
+	}, kv.conf.Retry)
+
+	return err
+}
+
+// List returns the pairs with the given prefix for which this node is a
+// participant.  Values are read from the local FSM and may lag the cluster
+func (kv *KV) List(prefix []byte) ([]*Pair, error) {
+	stored := kv.fsm.list(prefix)
+
+	out := make([]*Pair, 0, len(stored))
+	for _, sp := range stored {
+		pair := &Pair{Key: sp.Key, Value: sp.Value, Version: sp.Version}
+		if sp.BlockID != nil {
+			val, err := kv.readBlock(sp.BlockID)
+			if err != nil {
+				return nil, err
+			}
+			pair.Value = val
+		}
+		out = append(out, pair)
+	}
+
+	return out, nil
+}
+
+// write writes the value.  If version is not nil the current version must
+// match
+func (kv *KV) write(key, value []byte, version *uint32) (uint32, error) {
+	data, idx, err := kv.encodeValue(value)
+	if err != nil {
+		return 0, err
+	}
+
+	var height uint32
+	_, _, err = kv.wal.Update(kv.walKey(key), func(prev *hexalog.Entry) ([]byte, error) {
+		var current uint32
+		if prev != nil {
+			height = uint32(prev.Height) + 1
+			if !isDeleted(prev) {
+				current = uint32(prev.Height)
+			}
+		} else {
+			height = 1
+		}
+
+		if version != nil && *version != current {
+			return nil, ErrVersionMismatch
+		}
+		return data, nil
+	}, kv.conf.Retry)
+
+	if err != nil {
+		kv.removeValue(idx)
+		return 0, err
+	}
+	return height, nil
+}
+
+// encodeValue returns the entry data for a put, spilling large values to the
+// block device.  The index of spilled values is returned
+func (kv *KV) encodeValue(value []byte) ([]byte, *block.IndexBlock, error) {
+	if len(value) <= kv.conf.MaxInlineSize {
+		return encodeOp(opPut, valInline, value), nil, nil
+	}
+
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+
+	idx, err := kv.blx.WriteIndex(io.MultiReader(bytes.NewReader(nonce), bytes.NewReader(value)), 2)
+	if err != nil {
+		return nil, nil, err
+	}
+	return encodeOp(opPut, valBlock, idx.ID()), idx, nil
+}
+
+// removeValue removes the blocks of a spilled value that was not written.  It
+// is a no-op for a nil index
+func (kv *KV) removeValue(idx *block.IndexBlock) {
+	if idx == nil {
+		return
+	}
+
+	idx.Iter(func(index uint64, id []byte) error {
+		if err := kv.dev.RemoveBlock(id); err != nil {
+			kv.conf.Logger.Error("KV failed to remove value block", "id", hex.EncodeToString(id), "err", err)
+		}
+		return nil
+	})
+	if err := kv.dev.RemoveBlock(idx.ID()); err != nil {
+		kv.conf.Logger.Error("KV failed to remove value index", "id", hex.EncodeToString(idx.ID()), "err", err)
+	}
+}
+
+func (kv *KV) pairFromEntry(key []byte, entry *hexalog.Entry) (*Pair, error) {
+	op, kind, payload, err := decodeOp(phi.EntryData(entry))
+	if err != nil {
+		return nil, err
+	}
+	if op == opDelete {
+		return nil, ErrNotFound
+	}
+
+	pair := &Pair{Key: key, Value: payload, Version: uint32(entry.Height)}
+	if kind == valBlock {
+		if pair.Value, err = kv.readBlock(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	return pair, nil
+}
+
+// readBlock reads a spilled value stripping the nonce
+func (kv *KV) readBlock(id []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := kv.blx.ReadIndex(id, buf, 2); err != nil {
+		return nil, err
+	}
+
+	b := buf.Bytes()
+	if len(b) < nonceSize {
+		return nil, errInvalidData
+	}
+	return b[nonceSize:], nil
+}
+
+func (kv *KV) walKey(key []byte) []byte {
+	k := make([]byte, 0, len(kv.conf.Namespace)+len(key))
+	k = append(k, kv.conf.Namespace...)
+	return append(k, key...)
+}
+
+func isDeleted(entry *hexalog.Entry) bool {
+	op, _, _, err := decodeOp(phi.EntryData(entry))
+	return err == nil && op == opDelete
+}
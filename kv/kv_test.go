@@ -0,0 +1,95 @@
+package kv
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hexablock/hexalog"
+	"github.com/hexablock/phi/internal/waltest"
+)
+
+func Test_FSM(t *testing.T) {
+	fsm := NewFSM([]byte("kv/"))
+
+	entries := []*hexalog.Entry{
+		{Key: []byte("kv/a/1"), Height: 1, Data: encodeOp(opPut, valInline, []byte("v1"))},
+		{Key: []byte("kv/a/2"), Height: 1, Data: encodeOp(opPut, valBlock, []byte("blockid"))},
+		{Key: []byte("kv/b/1"), Height: 1, Data: encodeOp(opPut, valInline, []byte("v1"))},
+		{Key: []byte("kv/a/1"), Height: 2, Data: encodeOp(opPut, valInline, []byte("v2"))},
+		{Key: []byte("kv/b/1"), Height: 2, Data: encodeOp(opDelete, valInline, nil)},
+	}
+	for _, e := range entries {
+		if resp := fsm.Apply(nil, e); resp != nil {
+			t.Fatal(resp)
+		}
+	}
+
+	if resp := fsm.Apply(nil, &hexalog.Entry{Key: []byte("kv/c")}); resp == nil {
+		t.Fatal("should fail with invalid data")
+	}
+
+	pairs := fsm.list([]byte("a/"))
+	if len(pairs) != 2 {
+		t.Fatalf("pairs want=2 have=%d", len(pairs))
+	}
+	if string(pairs[0].Key) != "a/1" || string(pairs[0].Value) != "v2" || pairs[0].Version != 2 {
+		t.Fatal("wrong pair", pairs[0])
+	}
+	if string(pairs[1].BlockID) != "blockid" {
+		t.Fatal("block id not set")
+	}
+	if len(fsm.list([]byte("b/"))) != 0 {
+		t.Fatal("deleted key listed")
+	}
+
+	buf := new(bytes.Buffer)
+	if err := fsm.Snapshot(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewFSM([]byte("kv/"))
+	if err := restored.Restore(buf); err != nil {
+		t.Fatal(err)
+	}
+	if len(restored.list(nil)) != 2 {
+		t.Fatal("restore failed")
+	}
+}
+
+func Test_KV(t *testing.T) {
+	kv := New(DefaultConfig(), waltest.New(), nil)
+
+	if v, err := kv.Put([]byte("a"), []byte("v1")); err != nil || v != 1 {
+		t.Fatal("wrong version", v, err)
+	}
+	// A failed write has no version
+	if v, err := kv.CAS([]byte("a"), []byte("v2"), 0); err != ErrVersionMismatch || v != 0 {
+		t.Fatal("should fail with", ErrVersionMismatch, v, err)
+	}
+	if v, err := kv.CAS([]byte("a"), []byte("v2"), 1); err != nil || v != 2 {
+		t.Fatal("wrong version", v, err)
+	}
+
+	pair, err := kv.Get([]byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pair.Value) != "v2" || pair.Version != 2 {
+		t.Fatal("wrong pair", pair)
+	}
+
+	if err = kv.Delete([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = kv.Get([]byte("a")); err != ErrNotFound {
+		t.Fatal("should fail with", ErrNotFound, err)
+	}
+	if v, err := kv.Update([]byte("a"), func(current *Pair) ([]byte, error) {
+		if current != nil {
+			t.Fatal("deleted key should be nil")
+		}
+		return []byte("v3"), nil
+	}); err != nil || v != 4 {
+		t.Fatal("wrong version", v, err)
+	}
+}
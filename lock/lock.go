@@ -0,0 +1,350 @@
+// Package lock implements a distributed lock service on top of the phi WAL.
+// Each lock is a WAL key and all state changes are compare-and-set entries.
+// The height of the entry granting the lock is used as a fencing token.  Grants
+// and refreshes are stamped with the cluster lamport time.  A lock is
+// considered released once its lease expires or its holder leaves the cluster.
+// Leases are measured with the local clock of each node from when it observed
+// the grant or refresh.
+package lock
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hexablock/hexalog"
+	"github.com/hexablock/hexatype"
+	"github.com/hexablock/log"
+	"github.com/hexablock/phi"
+)
+
+var (
+	// ErrLockHeld is returned when the lock is held by another holder
+	ErrLockHeld = errors.New("lock held")
+
+	// ErrNotHolder is returned when releasing or refreshing a lock that is no
+	// longer held by the caller
+	ErrNotHolder = errors.New("not lock holder")
+)
+
+// Config holds the lock service config
+type Config struct {
+	// Prefix for all wal keys used for locks
+	Namespace []byte
+
+	// Interval to wait between tries while the lock is held by someone else
+	PollInterval time.Duration
+
+	// Retry options for compare-and-set conflicts
+	Retry *phi.RetryOptions
+}
+
+// DefaultConfig returns a default config
+func DefaultConfig() *Config {
+	return &Config{
+		Namespace:    []byte("lock/"),
+		PollInterval: 100 * time.Millisecond,
+		Retry: &phi.RetryOptions{
+			Retries:       3,
+			RetryInterval: 30 * time.Millisecond,
+		},
+	}
+}
+
+// Lock is a held lock
+type Lock struct {
+	Name string
+
+	// Fencing token.  It increases every time the lock changes hands and should
+	// be passed to resources protected by the lock
+	Token uint64
+
+	// Local time at which the lease expires unless refreshed.  Other nodes
+	// consider the lock held for at least as long
+	Expires time.Time
+}
+
+// lease is the current record of a lock as observed by this locker
+type lease struct {
+	// Height of the entry holding the record
+	height uint64
+	// Local time the record was first observed or written at
+	observed time.Time
+	node     []byte
+}
+
+// Locker acquires and releases locks for a node.  Leases are measured from the
+// local time a lock record was first observed so clocks of different nodes are
+// never compared.  A node seeing a lock for the first time waits for the full
+// ttl before considering it expired
+type Locker struct {
+	conf *Config
+
+	wal   phi.WAL
+	clock *hexatype.LamportClock
+	live  phi.Liveness
+
+	// Local node id and session unique to this locker
+	node    []byte
+	session []byte
+
+	// Current lease of each lock by name
+	mu     sync.Mutex
+	leases map[string]*lease
+
+	now func() time.Time
+}
+
+// New returns a Locker for the local node.  live is used to consider locks
+// held by nodes that have left as released and can be nil
+func New(conf *Config, wal phi.WAL, clock *hexatype.LamportClock, live phi.Liveness, node []byte) (*Locker, error) {
+	session := make([]byte, 8)
+	if _, err := rand.Read(session); err != nil {
+		return nil, err
+	}
+
+	return &Locker{
+		conf:    conf,
+		wal:     wal,
+		clock:   clock,
+		live:    live,
+		node:    node,
+		session: session,
+		leases:  make(map[string]*lease),
+		now:     time.Now,
+	}, nil
+}
+
+// Acquire blocks until the lock is acquired or the context is done.  The lock
+// is held for the ttl unless refreshed
+func (locker *Locker) Acquire(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	for {
+		lock, err := locker.TryAcquire(ctx, name, ttl)
+		if err != ErrLockHeld {
+			return lock, err
+		}
+
+		select {
+		case <-time.After(locker.conf.PollInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// TryAcquire tries to acquire the lock once.  It returns ErrLockHeld if the
+// lock is held by someone else
+func (locker *Locker) TryAcquire(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	var (
+		rec    *record
+		height uint64
+	)
+	// The lease starts before the grant is written so it expires here first
+	start := locker.now()
+
+	_, _, err := locker.wal.UpdateContext(ctx, locker.key(name), func(prev *hexalog.Entry) ([]byte, error) {
+		height = 1
+		if prev != nil {
+			current, err := locker.decode(prev)
+			if err != nil {
+				return nil, err
+			}
+			if locker.held(name, prev, current) && !current.ownedBy(locker.node, locker.session) {
+				return nil, ErrLockHeld
+			}
+			height = uint64(prev.Height) + 1
+		}
+
+		// The height of the granting entry is the token
+		rec = locker.newRecord(height, ttl)
+		return rec.encode(), nil
+	}, locker.conf.Retry)
+
+	if err != nil {
+		return nil, err
+	}
+
+	locker.setLease(name, height, start)
+	return &Lock{Name: name, Token: rec.Token, Expires: start.Add(ttl)}, nil
+}
+
+// Refresh extends the lease of a held lock by the ttl.  The fencing token does
+// not change
+func (locker *Locker) Refresh(ctx context.Context, lock *Lock, ttl time.Duration) (*Lock, error) {
+	var height uint64
+	start := locker.now()
+
+	_, _, err := locker.wal.UpdateContext(ctx, locker.key(lock.Name), func(prev *hexalog.Entry) ([]byte, error) {
+		if err := locker.checkHolder(prev, lock); err != nil {
+			return nil, err
+		}
+
+		height = uint64(prev.Height) + 1
+		return locker.newRecord(lock.Token, ttl).encode(), nil
+	}, locker.conf.Retry)
+
+	if err != nil {
+		return nil, err
+	}
+
+	locker.setLease(lock.Name, height, start)
+	return &Lock{Name: lock.Name, Token: lock.Token, Expires: start.Add(ttl)}, nil
+}
+
+// Release releases a held lock
+func (locker *Locker) Release(ctx context.Context, lock *Lock) error {
+	_, _, err := locker.wal.UpdateContext(ctx, locker.key(lock.Name), func(prev *hexalog.Entry) ([]byte, error) {
+		if err := locker.checkHolder(prev, lock); err != nil {
+			return nil, err
+		}
+
+		rec := locker.newRecord(lock.Token, 0)
+		rec.Released = true
+		return rec.encode(), nil
+	}, locker.conf.Retry)
+
+	return err
+}
+
+// ReleaseNode releases the locks this locker has seen held by the node.  It
+// must only be called once the node has left the cluster
+func (locker *Locker) ReleaseNode(ctx context.Context, node []byte) error {
+	var err error
+	for _, name := range locker.heldBy(node) {
+		_, _, er := locker.wal.UpdateContext(ctx, locker.key(name), func(prev *hexalog.Entry) ([]byte, error) {
+			if prev == nil {
+				return nil, ErrNotHolder
+			}
+
+			current, err := locker.decode(prev)
+			if err != nil {
+				return nil, err
+			}
+			if current.Released || !bytes.Equal(current.Node, node) {
+				return nil, ErrNotHolder
+			}
+
+			current.Released = true
+			current.LTime = locker.clock.Increment()
+			return current.encode(), nil
+		}, locker.conf.Retry)
+
+		if er != nil && er != ErrNotHolder {
+			err = er
+		}
+	}
+	return err
+}
+
+// ReleaseLeft releases locks held by nodes as they leave the cluster.  sub must
+// deliver phi.EventNodeLeft events.  It returns when the subscription is closed
+func (locker *Locker) ReleaseLeft(sub *phi.Subscription) {
+	for ev := range sub.C {
+		if ev.Type != phi.EventNodeLeft || ev.Node == nil {
+			continue
+		}
+		if err := locker.ReleaseNode(context.Background(), ev.Node.ID); err != nil {
+			log.Printf("[ERROR] Failed to release locks node=%x: %v", ev.Node.ID, err)
+		}
+	}
+}
+
+// checkHolder returns an error if the lock is not currently held by this
+// locker with the same token
+func (locker *Locker) checkHolder(prev *hexalog.Entry, lock *Lock) error {
+	if prev == nil {
+		return ErrNotHolder
+	}
+
+	current, err := locker.decode(prev)
+	if err != nil {
+		return err
+	}
+
+	if current.Token != lock.Token || !current.ownedBy(locker.node, locker.session) ||
+		!locker.held(lock.Name, prev, current) {
+		return ErrNotHolder
+	}
+	return nil
+}
+
+// held returns true if the lock record in the entry is held.  The lease is
+// measured from when the record was first observed
+func (locker *Locker) held(name string, entry *hexalog.Entry, rec *record) bool {
+	if rec.Released {
+		return false
+	}
+
+	observed := locker.observe(name, uint64(entry.Height), rec)
+	if locker.now().Sub(observed) >= time.Duration(rec.TTL) {
+		return false
+	}
+	return !locker.isDead(rec.Node)
+}
+
+// observe returns the local time the record at the height was first observed
+func (locker *Locker) observe(name string, height uint64, rec *record) time.Time {
+	locker.mu.Lock()
+	defer locker.mu.Unlock()
+
+	l, ok := locker.leases[name]
+	if !ok || l.height != height {
+		l = &lease{height: height, observed: locker.now(), node: rec.Node}
+		locker.leases[name] = l
+	}
+	return l.observed
+}
+
+// setLease sets the lease of a record written by this locker
+func (locker *Locker) setLease(name string, height uint64, start time.Time) {
+	locker.mu.Lock()
+	locker.leases[name] = &lease{height: height, observed: start, node: locker.node}
+	locker.mu.Unlock()
+}
+
+// heldBy returns the names of the locks last seen held by the node
+func (locker *Locker) heldBy(node []byte) []string {
+	locker.mu.Lock()
+	defer locker.mu.Unlock()
+
+	names := make([]string, 0)
+	for name, l := range locker.leases {
+		if bytes.Equal(l.node, node) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// decode decodes the record and witnesses its lamport time
+func (locker *Locker) decode(entry *hexalog.Entry) (*record, error) {
+	rec, err := decodeRecord(phi.EntryData(entry))
+	if err == nil {
+		locker.clock.Witness(rec.LTime)
+	}
+	return rec, err
+}
+
+func (locker *Locker) newRecord(token uint64, ttl time.Duration) *record {
+	return &record{
+		Node:    locker.node,
+		Session: locker.session,
+		Token:   token,
+		LTime:   locker.clock.Increment(),
+		TTL:     int64(ttl),
+	}
+}
+
+func (locker *Locker) isDead(node []byte) bool {
+	if locker.live == nil {
+		return false
+	}
+	return locker.live.IsDead(node)
+}
+
+func (locker *Locker) key(name string) []byte {
+	return append(append([]byte{}, locker.conf.Namespace...), name...)
+}
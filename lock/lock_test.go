@@ -0,0 +1,181 @@
+package lock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hexablock/hexatype"
+	"github.com/hexablock/phi"
+	"github.com/hexablock/phi/internal/waltest"
+)
+
+type testLiveness map[string]bool
+
+func (live testLiveness) IsDead(id []byte) bool {
+	return live[string(id)]
+}
+
+// testClock is a manually advanced local clock
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func newTestLocker(t *testing.T, wal phi.WAL, live phi.Liveness, clock *testClock, node string) *Locker {
+	locker, err := New(DefaultConfig(), wal, &hexatype.LamportClock{}, live, []byte(node))
+	if err != nil {
+		t.Fatal(err)
+	}
+	locker.now = clock.Now
+	return locker
+}
+
+func Test_record(t *testing.T) {
+	rec := &record{
+		Node:    []byte("node"),
+		Session: []byte("session"),
+		Token:   3,
+		LTime:   7,
+		TTL:     int64(time.Second),
+	}
+
+	got, err := decodeRecord(rec.encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Token != 3 || got.LTime != 7 || got.TTL != rec.TTL || !got.ownedBy([]byte("node"), []byte("session")) {
+		t.Fatal("decode mismatch", got)
+	}
+	if got.ownedBy([]byte("node"), []byte("other")) {
+		t.Fatal("should not be owned by another session")
+	}
+}
+
+func Test_Locker(t *testing.T) {
+	ctx := context.Background()
+	wal := waltest.New()
+	clock := &testClock{now: time.Unix(1000, 0)}
+
+	l1 := newTestLocker(t, wal, nil, clock, "node1")
+	l2 := newTestLocker(t, wal, nil, clock, "node2")
+
+	lock, err := l1.Acquire(ctx, "a", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.Token != 1 || !lock.Expires.Equal(clock.now.Add(time.Second)) {
+		t.Fatal("wrong lock", lock)
+	}
+
+	if _, err = l2.TryAcquire(ctx, "a", time.Second); err != ErrLockHeld {
+		t.Fatal("should be held", err)
+	}
+
+	// Refresh keeps the token and extends the lease
+	clock.now = clock.now.Add(800 * time.Millisecond)
+	if lock, err = l1.Refresh(ctx, lock, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if lock.Token != 1 {
+		t.Fatal("token changed on refresh", lock.Token)
+	}
+
+	// node2 measures the refreshed lease from when it observes it
+	clock.now = clock.now.Add(500 * time.Millisecond)
+	if _, err = l2.TryAcquire(ctx, "a", time.Second); err != ErrLockHeld {
+		t.Fatal("should be held after refresh", err)
+	}
+
+	if err = l1.Release(ctx, lock); err != nil {
+		t.Fatal(err)
+	}
+	if err = l1.Release(ctx, lock); err != ErrNotHolder {
+		t.Fatal("should not be holder after release", err)
+	}
+
+	lock2, err := l2.TryAcquire(ctx, "a", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock2.Token <= lock.Token {
+		t.Fatalf("token should increase have=%d", lock2.Token)
+	}
+	if _, err = l1.Refresh(ctx, lock, time.Second); err != ErrNotHolder {
+		t.Fatal("stale lock refreshed", err)
+	}
+}
+
+func Test_Locker_expiry(t *testing.T) {
+	ctx := context.Background()
+	wal := waltest.New()
+	clock := &testClock{now: time.Unix(1000, 0)}
+
+	l1 := newTestLocker(t, wal, nil, clock, "node1")
+	lock, err := l1.TryAcquire(ctx, "a", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// node2 first sees the grant later than it was written and waits for the
+	// full ttl from then
+	clock.now = clock.now.Add(900 * time.Millisecond)
+	l2 := newTestLocker(t, wal, nil, clock, "node2")
+	if _, err = l2.TryAcquire(ctx, "a", time.Second); err != ErrLockHeld {
+		t.Fatal("should be held", err)
+	}
+
+	// Expired for the holder but not yet for node2
+	clock.now = clock.now.Add(500 * time.Millisecond)
+	if _, err = l1.Refresh(ctx, lock, time.Second); err != ErrNotHolder {
+		t.Fatal("expired lock refreshed", err)
+	}
+	if _, err = l2.TryAcquire(ctx, "a", time.Second); err != ErrLockHeld {
+		t.Fatal("should be held", err)
+	}
+
+	clock.now = clock.now.Add(500 * time.Millisecond)
+	if _, err = l2.TryAcquire(ctx, "a", time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Locker_ReleaseNode(t *testing.T) {
+	ctx := context.Background()
+	wal := waltest.New()
+	clock := &testClock{now: time.Unix(1000, 0)}
+	live := testLiveness{}
+
+	l1 := newTestLocker(t, wal, nil, clock, "node1")
+	l2 := newTestLocker(t, wal, live, clock, "node2")
+
+	if _, err := l1.TryAcquire(ctx, "a", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l1.TryAcquire(ctx, "b", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l2.TryAcquire(ctx, "a", time.Minute); err != ErrLockHeld {
+		t.Fatal("should be held", err)
+	}
+
+	// Only locks node2 has observed are released
+	if err := l2.ReleaseNode(ctx, []byte("node1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l2.TryAcquire(ctx, "a", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l2.TryAcquire(ctx, "b", time.Minute); err != ErrLockHeld {
+		t.Fatal("unobserved lock released", err)
+	}
+
+	// A dead holder does not hold its locks
+	live["node1"] = true
+	if _, err := l2.TryAcquire(ctx, "b", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+}
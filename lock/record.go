@@ -0,0 +1,41 @@
+package lock
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/hexablock/hexatype"
+)
+
+// record is the lock state stored in the wal
+type record struct {
+	// Node id of the holder
+	Node []byte
+	// Session of the holder on the node
+	Session []byte
+	// Fencing token.  This is the height of the entry that granted the lock
+	Token uint64
+	// Lamport time of the grant or last refresh
+	LTime hexatype.LamportTime
+	// Lease duration in nanoseconds.  Each node measures it from when it
+	// observed the record
+	TTL int64
+	// Set when the holder released the lock
+	Released bool
+}
+
+func decodeRecord(data []byte) (*record, error) {
+	var rec record
+	err := json.Unmarshal(data, &rec)
+	return &rec, err
+}
+
+func (rec *record) encode() []byte {
+	b, _ := json.Marshal(rec)
+	return b
+}
+
+// ownedBy returns true if the record is held by the given node session
+func (rec *record) ownedBy(node, session []byte) bool {
+	return bytes.Equal(rec.Node, node) && bytes.Equal(rec.Session, session)
+}
@@ -0,0 +1,77 @@
+package phi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hexablock/log"
+)
+
+// Logger is a leveled structured logger.  kv contains alternating keys and
+// values e.g. "host", host, "err", err
+type Logger interface {
+	Debug(msg string, kv ...interface{})
+	Info(msg string, kv ...interface{})
+	Warn(msg string, kv ...interface{})
+	Error(msg string, kv ...interface{})
+}
+
+// hexID is a byte slice logged as hex e.g. entry and block ids
+type hexID []byte
+
+func (id hexID) String() string {
+	return hex.EncodeToString(id)
+}
+
+// stdLogger writes key=value formatted messages to the hexablock log so
+// levels set with log.SetLevel apply
+type stdLogger struct{}
+
+// NewStdLogger returns a Logger writing to the hexablock log.  This is the
+// default Logger
+func NewStdLogger() Logger {
+	return &stdLogger{}
+}
+
+func (l *stdLogger) Debug(msg string, kv ...interface{}) { l.log("DEBUG", msg, kv) }
+func (l *stdLogger) Info(msg string, kv ...interface{})  { l.log("INFO", msg, kv) }
+func (l *stdLogger) Warn(msg string, kv ...interface{})  { l.log("WARN", msg, kv) }
+func (l *stdLogger) Error(msg string, kv ...interface{}) { l.log("ERROR", msg, kv) }
+
+func (l *stdLogger) log(level, msg string, kv []interface{}) {
+	log.Printf("[%s] %s%s", level, msg, formatFields(kv))
+}
+
+// formatFields returns the key value pairs as space prefixed key=value strings.
+// Values with spaces are quoted
+func formatFields(kv []interface{}) string {
+	var buf bytes.Buffer
+
+	for i := 0; i < len(kv); i += 2 {
+		var val interface{} = "MISSING"
+		if i+1 < len(kv) {
+			val = kv[i+1]
+		}
+
+		var s string
+		switch v := val.(type) {
+		case []byte:
+			s = string(v)
+		case error:
+			s = v.Error()
+		default:
+			s = fmt.Sprint(v)
+		}
+
+		if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+			s = strconv.Quote(s)
+		}
+
+		fmt.Fprintf(&buf, " %v=%s", kv[i], s)
+	}
+
+	return buf.String()
+}
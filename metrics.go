@@ -0,0 +1,252 @@
+package phi
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	kelips "github.com/hexablock/go-kelips"
+	"github.com/hexablock/hexatype"
+)
+
+const metricsNamespace = "phi"
+
+// metrics contains the prometheus collectors for all subsystems.  All methods
+// are safe to call on a nil metrics so components can be used without them
+// e.g. by clients
+type metrics struct {
+	gossipMsgs *prometheus.CounterVec
+
+	dhtOps    *prometheus.HistogramVec
+	dhtErrors *prometheus.CounterVec
+
+	blockOps       *prometheus.HistogramVec
+	blockBytes     *prometheus.CounterVec
+	blockErrors    *prometheus.CounterVec
+	blockShortfall prometheus.Counter
+
+	walBallot  prometheus.Histogram
+	walApply   prometheus.Histogram
+	walRetries *prometheus.CounterVec
+
+	// Registerer and collectors registered to it so they can be removed
+	reg        prometheus.Registerer
+	collectors []prometheus.Collector
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		gossipMsgs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "gossip",
+			Name:      "messages_total",
+			Help:      "User messages received over gossip by type.",
+		}, []string{"type"}),
+
+		dhtOps: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "dht",
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of dht lookups, inserts and deletes.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{"op"}),
+		dhtErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "dht",
+			Name:      "errors_total",
+			Help:      "Failed dht operations.",
+		}, []string{"op"}),
+
+		blockOps: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "block",
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of block operations per host.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 4, 10),
+		}, []string{"op", "host"}),
+		blockBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "block",
+			Name:      "bytes_total",
+			Help:      "Block bytes written and read per host.",
+		}, []string{"op", "host"}),
+		blockErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "block",
+			Name:      "errors_total",
+			Help:      "Failed block operations per host.",
+		}, []string{"op", "host"}),
+		blockShortfall: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "block",
+			Name:      "replica_shortfall_total",
+			Help:      "Blocks written to fewer than the configured number of replicas.",
+		}),
+
+		walBallot: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "wal",
+			Name:      "ballot_duration_seconds",
+			Help:      "Time taken for an entry ballot to close.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 4, 10),
+		}),
+		walApply: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "wal",
+			Name:      "apply_duration_seconds",
+			Help:      "Time taken for an entry to be applied to the fsm.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 4, 10),
+		}),
+		walRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "wal",
+			Name:      "previous_hash_retries_total",
+			Help:      "Proposal attempts rejected due to a previous hash mismatch.",
+		}, []string{"op"}),
+	}
+}
+
+// register registers all collectors along with gauges reading the gossip state
+// of the node.  All metrics are labeled with the node so multiple nodes can
+// share a registry.  Collectors already registered are removed on failure
+func (m *metrics) register(reg prometheus.Registerer, node string, phi *Phi) error {
+	members := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "gossip",
+		Name:      "members",
+		Help:      "Number of alive gossip members.",
+	}, func() float64 {
+		if phi.memberlist == nil {
+			return 0
+		}
+		return float64(phi.memberlist.NumMembers())
+	})
+
+	queue := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "gossip",
+		Name:      "broadcast_queue_length",
+		Help:      "Number of user messages queued for broadcast.",
+	}, func() float64 {
+		if phi.dlg == nil {
+			return 0
+		}
+		return float64(phi.dlg.queueLen())
+	})
+
+	collectors := []prometheus.Collector{
+		members, queue, m.gossipMsgs,
+		m.dhtOps, m.dhtErrors,
+		m.blockOps, m.blockBytes, m.blockErrors, m.blockShortfall,
+		m.walBallot, m.walApply, m.walRetries,
+	}
+	m.reg = prometheus.WrapRegistererWith(prometheus.Labels{"node": node}, reg)
+	for _, c := range collectors {
+		if err := m.reg.Register(c); err != nil {
+			m.unregister()
+			return err
+		}
+		m.collectors = append(m.collectors, c)
+	}
+	return nil
+}
+
+// unregister removes all registered collectors allowing the node to be
+// created again with the same registry
+func (m *metrics) unregister() {
+	if m == nil {
+		return
+	}
+	for _, c := range m.collectors {
+		m.reg.Unregister(c)
+	}
+	m.collectors = nil
+}
+
+func (m *metrics) gossipMsg(typ string) {
+	if m != nil {
+		m.gossipMsgs.WithLabelValues(typ).Inc()
+	}
+}
+
+func (m *metrics) dhtOp(op string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	m.dhtOps.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.dhtErrors.WithLabelValues(op).Inc()
+	}
+}
+
+func (m *metrics) blockOp(op, host string, start time.Time, size uint64, err error) {
+	if m == nil {
+		return
+	}
+	m.blockOps.WithLabelValues(op, host).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.blockErrors.WithLabelValues(op, host).Inc()
+		return
+	}
+	m.blockBytes.WithLabelValues(op, host).Add(float64(size))
+}
+
+func (m *metrics) replicaShortfall() {
+	if m != nil {
+		m.blockShortfall.Inc()
+	}
+}
+
+func (m *metrics) writeStats(stats *WriteStats) {
+	if m != nil {
+		m.walBallot.Observe(stats.BallotTime.Seconds())
+		m.walApply.Observe(stats.ApplyTime.Seconds())
+	}
+}
+
+func (m *metrics) previousHashRetry(op string) {
+	if m != nil {
+		m.walRetries.WithLabelValues(op).Inc()
+	}
+}
+
+// meteredDHT wraps a DHT recording the latency and errors of each call
+type meteredDHT struct {
+	dht     DHT
+	metrics *metrics
+}
+
+func (md *meteredDHT) LookupNodes(key []byte, min int) ([]*hexatype.Node, error) {
+	start := time.Now()
+	nodes, err := md.dht.LookupNodes(key, min)
+	md.metrics.dhtOp("lookup_nodes", start, err)
+	return nodes, err
+}
+
+func (md *meteredDHT) LookupGroupNodes(key []byte) ([]*hexatype.Node, error) {
+	start := time.Now()
+	nodes, err := md.dht.LookupGroupNodes(key)
+	md.metrics.dhtOp("lookup_group_nodes", start, err)
+	return nodes, err
+}
+
+func (md *meteredDHT) Lookup(key []byte) ([]*hexatype.Node, error) {
+	start := time.Now()
+	nodes, err := md.dht.Lookup(key)
+	md.metrics.dhtOp("lookup", start, err)
+	return nodes, err
+}
+
+func (md *meteredDHT) Insert(key []byte, tuple kelips.TupleHost) error {
+	start := time.Now()
+	err := md.dht.Insert(key, tuple)
+	md.metrics.dhtOp("insert", start, err)
+	return err
+}
+
+func (md *meteredDHT) Delete(key []byte, tuple kelips.TupleHost) error {
+	start := time.Now()
+	err := md.dht.Delete(key, tuple)
+	md.metrics.dhtOp("delete", start, err)
+	return err
+}
@@ -0,0 +1,118 @@
+package phi
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	kelips "github.com/hexablock/go-kelips"
+	"github.com/hexablock/hexatype"
+)
+
+type errDHT struct{}
+
+func (d *errDHT) LookupNodes(key []byte, min int) ([]*hexatype.Node, error) {
+	return nil, errors.New("lookup failed")
+}
+func (d *errDHT) LookupGroupNodes(key []byte) ([]*hexatype.Node, error) { return nil, nil }
+func (d *errDHT) Lookup(key []byte) ([]*hexatype.Node, error)           { return nil, nil }
+func (d *errDHT) Insert(key []byte, tuple kelips.TupleHost) error       { return nil }
+func (d *errDHT) Delete(key []byte, tuple kelips.TupleHost) error       { return nil }
+
+func gatherCount(t *testing.T, reg *prometheus.Registry, name string) uint64 {
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n uint64
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			if h := m.GetHistogram(); h != nil {
+				n += h.GetSampleCount()
+			} else {
+				n += uint64(m.GetCounter().GetValue())
+			}
+		}
+	}
+	return n
+}
+
+func Test_metrics(t *testing.T) {
+	// All methods should be no-ops when disabled
+	var m *metrics
+	m.gossipMsg("insert_key")
+	m.blockOp("set", "127.0.0.1:41000", time.Now(), 10, nil)
+	m.writeStats(&WriteStats{})
+
+	reg := prometheus.NewRegistry()
+	m = newMetrics()
+	if err := m.register(reg, "127.0.0.1:41000", &Phi{}); err != nil {
+		t.Fatal(err)
+	}
+
+	dht := &meteredDHT{dht: &errDHT{}, metrics: m}
+	dht.Lookup([]byte("key"))
+	if _, err := dht.LookupNodes([]byte("key"), 2); err == nil {
+		t.Fatal("should fail")
+	}
+
+	if n := gatherCount(t, reg, "phi_dht_operation_duration_seconds"); n != 2 {
+		t.Fatal("wrong dht operation count", n)
+	}
+	if n := gatherCount(t, reg, "phi_dht_errors_total"); n != 1 {
+		t.Fatal("wrong dht error count", n)
+	}
+
+	m.blockOp("get", "127.0.0.1:41000", time.Now(), 10, nil)
+	m.blockOp("get", "127.0.0.1:41001", time.Now(), 0, errors.New("not found"))
+	if n := gatherCount(t, reg, "phi_block_bytes_total"); n != 10 {
+		t.Fatal("wrong block bytes", n)
+	}
+	if n := gatherCount(t, reg, "phi_block_errors_total"); n != 1 {
+		t.Fatal("wrong block error count", n)
+	}
+}
+
+func Test_metrics_register(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	m1 := newMetrics()
+	if err := m1.register(reg, "127.0.0.1:41000", &Phi{}); err != nil {
+		t.Fatal(err)
+	}
+	// Nodes sharing a registry
+	m2 := newMetrics()
+	if err := m2.register(reg, "127.0.0.1:41001", &Phi{}); err != nil {
+		t.Fatal(err)
+	}
+	// Same node twice
+	m3 := newMetrics()
+	if err := m3.register(reg, "127.0.0.1:41001", &Phi{}); err == nil {
+		t.Fatal("should fail")
+	}
+	if len(m3.collectors) != 0 {
+		t.Fatal("collectors should be unregistered on failure")
+	}
+
+	m1.replicaShortfall()
+	m2.replicaShortfall()
+	if n := gatherCount(t, reg, "phi_block_replica_shortfall_total"); n != 2 {
+		t.Fatal("wrong shortfall count", n)
+	}
+
+	// Re-create after shutdown
+	m2.unregister()
+	m2 = newMetrics()
+	if err := m2.register(reg, "127.0.0.1:41001", &Phi{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var m *metrics
+	m.unregister()
+}
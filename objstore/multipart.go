@@ -0,0 +1,225 @@
+package objstore
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hexablock/phi/internal/blockio"
+	"github.com/hexablock/phi/kv"
+)
+
+var (
+	// ErrNoSuchUpload is returned when a multipart upload does not exist
+	ErrNoSuchUpload = errors.New("no such upload")
+	// ErrInvalidPart is returned when completing with a part that was not
+	// uploaded or has a different etag
+	ErrInvalidPart = errors.New("invalid part")
+	// ErrNoParts is returned when completing an upload without parts
+	ErrNoParts = errors.New("at least one part must be specified")
+)
+
+// Part is an uploaded part of a multipart upload
+type Part struct {
+	Number  int
+	Size    int64
+	ETag    string
+	IndexID []byte
+}
+
+// upload is the state of a multipart upload stored in the kv store
+type upload struct {
+	ID      string
+	Bucket  string
+	Name    string
+	Options *PutOptions
+	Started time.Time
+	Parts   map[int]*Part
+}
+
+// CreateMultipartUpload starts a multipart upload and returns its id
+func (store *Store) CreateMultipartUpload(bucket, name string, opts *PutOptions) (string, error) {
+	if _, err := store.getBucket(bucket); err != nil {
+		return "", err
+	}
+
+	rid := make([]byte, 16)
+	if _, err := rand.Read(rid); err != nil {
+		return "", err
+	}
+
+	up := &upload{
+		ID:      hex.EncodeToString(rid),
+		Bucket:  bucket,
+		Name:    name,
+		Options: opts,
+		Started: time.Now(),
+		Parts:   make(map[int]*Part),
+	}
+
+	b, err := json.Marshal(up)
+	if err != nil {
+		return "", err
+	}
+	if _, err = store.kv.CAS(uploadKey(up.ID), b, 0); err != nil {
+		return "", err
+	}
+
+	return up.ID, nil
+}
+
+// UploadPart writes a part of a multipart upload replacing any existing part
+// with the same number
+func (store *Store) UploadPart(bucket, name, uploadID string, number int, r io.Reader) (*Part, error) {
+	if _, err := store.getUpload(bucket, name, uploadID); err != nil {
+		return nil, err
+	}
+
+	h := md5.New()
+	cr := &blockio.CountReader{R: io.TeeReader(r, h)}
+	idx, err := store.blx.WriteIndex(cr, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	part := &Part{
+		Number:  number,
+		Size:    cr.N,
+		ETag:    hex.EncodeToString(h.Sum(nil)),
+		IndexID: idx.ID(),
+	}
+
+	err = store.updateUpload(uploadID, func(up *upload) {
+		up.Parts[number] = part
+	})
+
+	return part, err
+}
+
+// CompleteMultipartUpload assembles the given parts in order into the object.
+// Each part must have been uploaded with the given etag.  An empty etag skips
+// the check
+func (store *Store) CompleteMultipartUpload(bucket, name, uploadID string, parts []*Part) (*ObjectInfo, error) {
+	if len(parts) == 0 {
+		return nil, ErrNoParts
+	}
+
+	up, err := store.getUpload(bucket, name, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Number < parts[j].Number })
+
+	ordered := make([]*Part, 0, len(parts))
+	for _, p := range parts {
+		uploaded, ok := up.Parts[p.Number]
+		if !ok || (p.ETag != "" && p.ETag != uploaded.ETag) {
+			return nil, ErrInvalidPart
+		}
+		ordered = append(ordered, uploaded)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		for _, p := range ordered {
+			if err := store.blx.ReadIndex(p.IndexID, pw, 2); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.Close()
+	}()
+
+	info, err := store.put(bucket, name, pr, up.Options, MultipartETag(ordered))
+	pr.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return info, store.kv.Delete(uploadKey(uploadID))
+}
+
+// MultipartETag returns the S3 style etag of an object assembled from the
+// parts.  It is the md5 of the binary part md5s followed by a dash and the
+// number of parts
+func MultipartETag(parts []*Part) string {
+	h := md5.New()
+	for _, p := range parts {
+		sum, _ := hex.DecodeString(p.ETag)
+		h.Write(sum)
+	}
+	return hex.EncodeToString(h.Sum(nil)) + "-" + strconv.Itoa(len(parts))
+}
+
+// AbortMultipartUpload removes a multipart upload
+func (store *Store) AbortMultipartUpload(bucket, name, uploadID string) error {
+	if _, err := store.getUpload(bucket, name, uploadID); err != nil {
+		return err
+	}
+	return store.kv.Delete(uploadKey(uploadID))
+}
+
+// ListParts returns the uploaded parts sorted by part number
+func (store *Store) ListParts(bucket, name, uploadID string) ([]*Part, error) {
+	up, err := store.getUpload(bucket, name, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*Part, 0, len(up.Parts))
+	for _, p := range up.Parts {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Number < out[j].Number })
+
+	return out, nil
+}
+
+func (store *Store) getUpload(bucket, name, uploadID string) (*upload, error) {
+	pair, err := store.kv.Get(uploadKey(uploadID))
+	if err == kv.ErrNotFound {
+		return nil, ErrNoSuchUpload
+	} else if err != nil {
+		return nil, err
+	}
+
+	var up upload
+	if err = json.Unmarshal(pair.Value, &up); err != nil {
+		return nil, err
+	}
+	if up.Bucket != bucket || up.Name != name {
+		return nil, ErrNoSuchUpload
+	}
+	return &up, nil
+}
+
+func (store *Store) updateUpload(uploadID string, fn func(*upload)) error {
+	_, err := store.kv.Update(uploadKey(uploadID), func(current *kv.Pair) ([]byte, error) {
+		if current == nil {
+			return nil, ErrNoSuchUpload
+		}
+
+		var up upload
+		if err := json.Unmarshal(current.Value, &up); err != nil {
+			return nil, err
+		}
+		if up.Parts == nil {
+			up.Parts = make(map[int]*Part)
+		}
+
+		fn(&up)
+		return json.Marshal(&up)
+	})
+	return err
+}
+
+func uploadKey(id string) []byte {
+	return []byte("u/" + id)
+}
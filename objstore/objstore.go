@@ -0,0 +1,427 @@
+// Package objstore implements an object store with named buckets on top of
+// phi.  Object data is stored as blox indexes on the block device.  Bucket and
+// object metadata is stored in the kv store so all changes go through the WAL.
+// Each object is a kv key under its bucket and buckets are listed by prefix.
+package objstore
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/hexablock/blox"
+	"github.com/hexablock/phi/internal/blockio"
+	"github.com/hexablock/phi/kv"
+)
+
+var (
+	// ErrNoSuchBucket is returned when a bucket does not exist
+	ErrNoSuchBucket = errors.New("no such bucket")
+	// ErrBucketExists is returned when creating a bucket that exists
+	ErrBucketExists = errors.New("bucket exists")
+	// ErrInvalidBucketName is returned when creating a bucket with a name not
+	// following the S3 naming rules
+	ErrInvalidBucketName = errors.New("invalid bucket name")
+	// ErrBucketNotEmpty is returned when deleting a bucket with objects
+	ErrBucketNotEmpty = errors.New("bucket not empty")
+	// ErrNoSuchKey is returned when an object does not exist
+	ErrNoSuchKey = errors.New("no such key")
+	// ErrInvalidRange is returned for a range outside of the object
+	ErrInvalidRange = errors.New("invalid range")
+)
+
+// ObjectInfo contains the metadata of an object
+type ObjectInfo struct {
+	Bucket      string
+	Name        string
+	Size        int64
+	ContentType string
+	// Hex encoded md5 of the data.  See MultipartETag for objects from
+	// multipart uploads
+	ETag     string
+	Tags     map[string]string
+	Modified time.Time
+	// Blox index id of the data
+	IndexID []byte
+}
+
+// BucketInfo contains the metadata of a bucket
+type BucketInfo struct {
+	Name    string
+	Created time.Time
+}
+
+// bucketRecord is the bucket as stored in the kv store
+type bucketRecord struct {
+	BucketInfo
+	Deleted bool
+	// Number of objects in the bucket.  It is counted before an object is
+	// written so it may be higher than the actual number but never lower
+	Objects int64
+}
+
+// PutOptions are optional object metadata
+type PutOptions struct {
+	ContentType string
+	Tags        map[string]string
+}
+
+// Store is an object store
+type Store struct {
+	kv  *kv.KV
+	blx *blox.Blox
+}
+
+// New returns an object store using the kv store for metadata and the block
+// device for data
+func New(kvs *kv.KV, dev blox.BlockDevice) *Store {
+	return &Store{kv: kvs, blx: blox.NewBlox(dev)}
+}
+
+// CreateBucket creates a new bucket.  The name must follow the S3 bucket naming
+// rules
+func (store *Store) CreateBucket(name string) error {
+	if !validBucketName(name) {
+		return ErrInvalidBucketName
+	}
+
+	rec := &bucketRecord{BucketInfo: BucketInfo{Name: name, Created: time.Now()}}
+
+	_, err := store.kv.Update(bucketKey(name), func(current *kv.Pair) ([]byte, error) {
+		if current != nil {
+			var existing bucketRecord
+			if err := json.Unmarshal(current.Value, &existing); err != nil {
+				return nil, err
+			}
+			if !existing.Deleted {
+				return nil, ErrBucketExists
+			}
+		}
+		return json.Marshal(rec)
+	})
+	if err != nil {
+		return err
+	}
+
+	return store.updateBucketList(func(names map[string]time.Time) {
+		names[name] = rec.Created
+	})
+}
+
+// DeleteBucket deletes an empty bucket.  The object count of the bucket record
+// is checked in the same write so objects cannot be added concurrently
+func (store *Store) DeleteBucket(name string) error {
+	err := store.updateBucket(name, func(rec *bucketRecord) error {
+		if rec.Objects > 0 {
+			return ErrBucketNotEmpty
+		}
+		rec.Deleted = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return store.updateBucketList(func(names map[string]time.Time) {
+		delete(names, name)
+	})
+}
+
+// ListBuckets returns all buckets sorted by name
+func (store *Store) ListBuckets() ([]*BucketInfo, error) {
+	names, err := store.bucketList()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*BucketInfo, 0, len(names))
+	for n, created := range names {
+		out = append(out, &BucketInfo{Name: n, Created: created})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+
+	return out, nil
+}
+
+// HeadBucket returns the bucket info
+func (store *Store) HeadBucket(name string) (*BucketInfo, error) {
+	rec, err := store.getBucket(name)
+	if err != nil {
+		return nil, err
+	}
+	return &rec.BucketInfo, nil
+}
+
+// Put writes the object data from the reader replacing any existing object.
+// The object is added with a single kv write.  If it fails the data blocks are
+// not removed as they may be shared with other objects
+func (store *Store) Put(bucket, name string, r io.Reader, opts *PutOptions) (*ObjectInfo, error) {
+	return store.put(bucket, name, r, opts, "")
+}
+
+// put writes the object with the given etag.  The md5 of the data is used if
+// the etag is empty
+func (store *Store) put(bucket, name string, r io.Reader, opts *PutOptions, etag string) (*ObjectInfo, error) {
+	// Count the object before writing it so the bucket cannot be deleted
+	// underneath it.  The count is released if the write fails or the object
+	// replaces an existing one
+	if err := store.countObjects(bucket, 1); err != nil {
+		return nil, err
+	}
+	var added bool
+	defer func() {
+		if !added {
+			store.countObjects(bucket, -1)
+		}
+	}()
+
+	h := md5.New()
+	cr := &blockio.CountReader{R: io.TeeReader(r, h)}
+	idx, err := store.blx.WriteIndex(cr, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ObjectInfo{
+		Bucket:   bucket,
+		Name:     name,
+		Size:     cr.N,
+		ETag:     hex.EncodeToString(h.Sum(nil)),
+		Modified: time.Now(),
+		IndexID:  idx.ID(),
+	}
+	if etag != "" {
+		info.ETag = etag
+	}
+	if opts != nil {
+		info.ContentType = opts.ContentType
+		info.Tags = opts.Tags
+	}
+
+	b, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	_, err = store.kv.Update(objectKey(bucket, name), func(current *kv.Pair) ([]byte, error) {
+		added = current == nil
+		return b, nil
+	})
+	if err != nil {
+		added = false
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// Head returns the object metadata
+func (store *Store) Head(bucket, name string) (*ObjectInfo, error) {
+	pair, err := store.kv.Get(objectKey(bucket, name))
+	if err == kv.ErrNotFound {
+		return nil, ErrNoSuchKey
+	} else if err != nil {
+		return nil, err
+	}
+
+	var info ObjectInfo
+	err = json.Unmarshal(pair.Value, &info)
+	return &info, err
+}
+
+// Get writes the object data to the writer
+func (store *Store) Get(bucket, name string, w io.Writer) (*ObjectInfo, error) {
+	info, err := store.Head(bucket, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return info, store.blx.ReadIndex(info.IndexID, w, 2)
+}
+
+// GetRange writes length bytes of the object data starting at offset to the
+// writer.  A negative length reads to the end of the object
+func (store *Store) GetRange(bucket, name string, offset, length int64, w io.Writer) (*ObjectInfo, error) {
+	info, err := store.Head(bucket, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset < 0 || offset > info.Size || (offset == info.Size && length != 0) {
+		return nil, ErrInvalidRange
+	}
+	if length < 0 || offset+length > info.Size {
+		length = info.Size - offset
+	}
+	if length == 0 {
+		return info, nil
+	}
+
+	if _, err = blockio.ReadRange(store.blx, info.IndexID, offset, length, w); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// Delete removes the object.  The data blocks are not removed as they may be
+// shared with other objects
+func (store *Store) Delete(bucket, name string) error {
+	if _, err := store.getBucket(bucket); err != nil {
+		return err
+	}
+
+	err := store.kv.Delete(objectKey(bucket, name))
+	if err == kv.ErrNotFound {
+		return ErrNoSuchKey
+	} else if err != nil {
+		return err
+	}
+
+	return store.countObjects(bucket, -1)
+}
+
+// List returns up to max objects in the bucket with the prefix sorted by name,
+// starting after the given name.  A max less than 1 returns all objects.
+// Objects are listed from the local kv FSM so only objects this node is a
+// participant for are included and they may lag the cluster
+func (store *Store) List(bucket, prefix, startAfter string, max int) ([]*ObjectInfo, error) {
+	if _, err := store.getBucket(bucket); err != nil {
+		return nil, err
+	}
+
+	pairs, err := store.kv.List(objectKey(bucket, prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*ObjectInfo, 0, len(pairs))
+	for _, pair := range pairs {
+		var info ObjectInfo
+		if err = json.Unmarshal(pair.Value, &info); err != nil {
+			return nil, err
+		}
+		if info.Name <= startAfter {
+			continue
+		}
+
+		out = append(out, &info)
+		if max > 0 && len(out) == max {
+			break
+		}
+	}
+	return out, nil
+}
+
+func (store *Store) getBucket(name string) (*bucketRecord, error) {
+	pair, err := store.kv.Get(bucketKey(name))
+	if err == kv.ErrNotFound {
+		return nil, ErrNoSuchBucket
+	} else if err != nil {
+		return nil, err
+	}
+
+	var rec bucketRecord
+	if err = json.Unmarshal(pair.Value, &rec); err != nil {
+		return nil, err
+	}
+	if rec.Deleted {
+		return nil, ErrNoSuchBucket
+	}
+	return &rec, nil
+}
+
+// updateBucket atomically updates an existing bucket record
+func (store *Store) updateBucket(name string, fn func(*bucketRecord) error) error {
+	_, err := store.kv.Update(bucketKey(name), func(current *kv.Pair) ([]byte, error) {
+		if current == nil {
+			return nil, ErrNoSuchBucket
+		}
+
+		var rec bucketRecord
+		if err := json.Unmarshal(current.Value, &rec); err != nil {
+			return nil, err
+		}
+		if rec.Deleted {
+			return nil, ErrNoSuchBucket
+		}
+
+		if err := fn(&rec); err != nil {
+			return nil, err
+		}
+		return json.Marshal(&rec)
+	})
+
+	return err
+}
+
+// countObjects atomically adds delta to the object count of the bucket
+func (store *Store) countObjects(name string, delta int64) error {
+	return store.updateBucket(name, func(rec *bucketRecord) error {
+		if rec.Objects += delta; rec.Objects < 0 {
+			rec.Objects = 0
+		}
+		return nil
+	})
+}
+
+func (store *Store) bucketList() (map[string]time.Time, error) {
+	names := make(map[string]time.Time)
+
+	pair, err := store.kv.Get([]byte(bucketListKey))
+	if err == kv.ErrNotFound {
+		return names, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(pair.Value, &names)
+	return names, err
+}
+
+func (store *Store) updateBucketList(fn func(map[string]time.Time)) error {
+	_, err := store.kv.Update([]byte(bucketListKey), func(current *kv.Pair) ([]byte, error) {
+		names := make(map[string]time.Time)
+		if current != nil {
+			if err := json.Unmarshal(current.Value, &names); err != nil {
+				return nil, err
+			}
+		}
+
+		fn(names)
+		return json.Marshal(names)
+	})
+	return err
+}
+
+const bucketListKey = "buckets"
+
+// validBucketName returns true if the name is 3 to 63 lowercase letters,
+// digits, dots and hyphens starting and ending with a letter or digit
+func validBucketName(name string) bool {
+	if len(name) < 3 || len(name) > 63 {
+		return false
+	}
+
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case (c == '.' || c == '-') && i > 0 && i < len(name)-1:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func bucketKey(bucket string) []byte {
+	return []byte("b/" + bucket)
+}
+
+func objectKey(bucket, name string) []byte {
+	return []byte("o/" + bucket + "/" + name)
+}
@@ -0,0 +1,213 @@
+package objstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hexablock/blox/device"
+	"github.com/hexablock/phi/internal/waltest"
+	"github.com/hexablock/phi/kv"
+)
+
+// newTestStore returns a store on an in-memory wal applied to the kv FSM and a
+// local block device.  The returned func removes the device data
+func newTestStore(t *testing.T) (*Store, func()) {
+	dir, _ := ioutil.TempDir("", "phi-objstore")
+
+	raw, err := device.NewFileRawDevice(dir, sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev := device.NewBlockDevice(device.NewInmemIndex(), raw)
+
+	wal := waltest.New()
+	kvs := kv.New(kv.DefaultConfig(), wal, dev)
+	wal.FSM = kvs.FSM()
+
+	return New(kvs, dev), func() { os.RemoveAll(dir) }
+}
+
+func Test_MultipartETag(t *testing.T) {
+	parts := []*Part{
+		{Number: 1, ETag: "f814893777bcc2295fff05f00e508da6"},
+		{Number: 2, ETag: "7d793037a0760186574b0282f2f435e7"},
+	}
+
+	if etag := MultipartETag(parts); etag != "e09e4fd6265b36115fe3db32df945d84-2" {
+		t.Fatal("wrong etag", etag)
+	}
+}
+
+func Test_validBucketName(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"abc":                   true,
+		"my-bucket.2":           true,
+		"ab":                    false,
+		strings.Repeat("a", 64): false,
+		"Bucket":                false,
+		"a/b":                   false,
+		"-abc":                  false,
+		"abc.":                  false,
+		"a_bc":                  false,
+	} {
+		if validBucketName(name) != valid {
+			t.Error("wrong validity", name, !valid)
+		}
+	}
+}
+
+func Test_Store_bucket(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+
+	if err := store.CreateBucket("a/b"); err != ErrInvalidBucketName {
+		t.Fatal("should fail with", ErrInvalidBucketName, err)
+	}
+	if err := store.CreateBucket("bucket"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateBucket("bucket"); err != ErrBucketExists {
+		t.Fatal("should fail with", ErrBucketExists, err)
+	}
+
+	if _, err := store.Put("bucket", "obj", strings.NewReader("data"), nil); err != nil {
+		t.Fatal(err)
+	}
+	// Replacing an object does not add to the count
+	if _, err := store.Put("bucket", "obj", strings.NewReader("data2"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if rec, _ := store.getBucket("bucket"); rec.Objects != 1 {
+		t.Fatal("wrong object count", rec.Objects)
+	}
+
+	if err := store.DeleteBucket("bucket"); err != ErrBucketNotEmpty {
+		t.Fatal("should fail with", ErrBucketNotEmpty, err)
+	}
+	if err := store.Delete("bucket", "obj"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteBucket("bucket"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.HeadBucket("bucket"); err != ErrNoSuchBucket {
+		t.Fatal("should fail with", ErrNoSuchBucket, err)
+	}
+	if buckets, _ := store.ListBuckets(); len(buckets) != 0 {
+		t.Fatal("should have no buckets", len(buckets))
+	}
+}
+
+func Test_Store_object(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+
+	if _, err := store.Put("bucket", "obj", strings.NewReader("data"), nil); err != ErrNoSuchBucket {
+		t.Fatal("should fail with", ErrNoSuchBucket, err)
+	}
+	if err := store.CreateBucket("bucket"); err != nil {
+		t.Fatal(err)
+	}
+
+	data := "0123456789abcdefghij"
+	opts := &PutOptions{ContentType: "text/plain"}
+	for _, name := range []string{"b", "a", "c"} {
+		if _, err := store.Put("bucket", name, strings.NewReader(data), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	info, err := store.Get("bucket", "a", buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != data || info.Size != int64(len(data)) || info.ContentType != "text/plain" {
+		t.Fatal("wrong object", buf.String(), info.Size, info.ContentType)
+	}
+
+	buf.Reset()
+	if _, err = store.GetRange("bucket", "a", 5, 4, buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "5678" {
+		t.Fatal("wrong range", buf.String())
+	}
+	if _, err = store.GetRange("bucket", "a", 21, 1, buf); err != ErrInvalidRange {
+		t.Fatal("should fail with", ErrInvalidRange, err)
+	}
+
+	objects, err := store.List("bucket", "", "a", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 || objects[0].Name != "b" || objects[1].Name != "c" {
+		t.Fatal("wrong objects", len(objects))
+	}
+
+	if err = store.Delete("bucket", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Delete("bucket", "a"); err != ErrNoSuchKey {
+		t.Fatal("should fail with", ErrNoSuchKey, err)
+	}
+	if _, err = store.Head("bucket", "a"); err != ErrNoSuchKey {
+		t.Fatal("should fail with", ErrNoSuchKey, err)
+	}
+}
+
+func Test_Store_multipart(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+
+	if err := store.CreateBucket("bucket"); err != nil {
+		t.Fatal(err)
+	}
+	id, err := store.CreateMultipartUpload("bucket", "obj", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = store.CompleteMultipartUpload("bucket", "obj", id, nil); err != ErrNoParts {
+		t.Fatal("should fail with", ErrNoParts, err)
+	}
+
+	parts := make([]*Part, 0, 2)
+	for i, data := range []string{"hello ", "world"} {
+		part, err := store.UploadPart("bucket", "obj", id, i+1, strings.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, &Part{Number: part.Number, ETag: part.ETag})
+	}
+
+	if _, err = store.CompleteMultipartUpload("bucket", "obj", id, []*Part{{Number: 3}}); err != ErrInvalidPart {
+		t.Fatal("should fail with", ErrInvalidPart, err)
+	}
+
+	// Parts are assembled in order regardless of the given order
+	info, err := store.CompleteMultipartUpload("bucket", "obj", id, []*Part{parts[1], parts[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(info.ETag, "-2") {
+		t.Fatal("wrong etag", info.ETag)
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err = store.Get("bucket", "obj", buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "hello world" {
+		t.Fatal("wrong data", buf.String())
+	}
+
+	if _, err = store.ListParts("bucket", "obj", id); err != ErrNoSuchUpload {
+		t.Fatal("should fail with", ErrNoSuchUpload, err)
+	}
+}
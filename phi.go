@@ -2,13 +2,18 @@ package phi
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/memberlist"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/hexablock/blox"
 	"github.com/hexablock/blox/device"
@@ -20,6 +25,10 @@ import (
 	"github.com/hexablock/vivaldi"
 )
 
+// Max time to wait for the leave to propagate and in-flight requests to
+// complete on shutdown
+const leaveTimeout = 5 * time.Second
+
 // DHT implements a distributed hash table needed to route keys
 type DHT interface {
 	LookupNodes(key []byte, min int) ([]*hexatype.Node, error)
@@ -29,23 +38,35 @@ type DHT interface {
 	Delete(key []byte, tuple kelips.TupleHost) error
 }
 
-// WAL implements an interface to provide p2p distributed consensus
+// WAL implements an interface to provide p2p distributed consensus.  The
+// Context variants abort when the context is done
 type WAL interface {
 	NewEntry(key []byte) (*hexalog.Entry, []*hexalog.Participant, error)
+	NewEntryContext(ctx context.Context, key []byte) (*hexalog.Entry, []*hexalog.Participant, error)
 	NewEntryFrom(entry *hexalog.Entry) (*hexalog.Entry, []*hexalog.Participant, error)
+	NewEntryFromContext(ctx context.Context, entry *hexalog.Entry) (*hexalog.Entry, []*hexalog.Participant, error)
 	ProposeEntry(entry *hexalog.Entry, opts *hexalog.RequestOptions, retry *RetryOptions) ([]byte, *WriteStats, error)
+	ProposeEntryContext(ctx context.Context, entry *hexalog.Entry, opts *hexalog.RequestOptions, retry *RetryOptions) ([]byte, *WriteStats, error)
 	GetEntry(key []byte, id []byte) (*hexalog.Entry, error)
+	GetEntryContext(ctx context.Context, key []byte, id []byte) (*hexalog.Entry, error)
+	Update(key []byte, fn UpdateFunc, retry *RetryOptions) ([]byte, *WriteStats, error)
+	UpdateContext(ctx context.Context, key []byte, fn UpdateFunc, retry *RetryOptions) ([]byte, *WriteStats, error)
+	GetLatest(key []byte) (*hexalog.Entry, error)
+	GetLatestContext(ctx context.Context, key []byte) (*hexalog.Entry, error)
+	History(key []byte, fromHeight, toHeight uint32) (*EntryIterator, error)
+	HistoryContext(ctx context.Context, key []byte, fromHeight, toHeight uint32) (*EntryIterator, error)
 	RegisterJury(jury Jury)
 }
 
 // WALTransport implements an interface for network log operations
 type WALTransport interface {
-	NewEntry(host string, key []byte, opts *hexalog.RequestOptions) (*hexalog.Entry, error)
+	NewEntry(ctx context.Context, host string, key []byte, opts *hexalog.RequestOptions) (*hexalog.Entry, error)
 	ProposeEntry(ctx context.Context, host string, entry *hexalog.Entry, opts *hexalog.RequestOptions) (*hexalog.ReqResp, error)
-	GetEntry(host string, key []byte, id []byte, opts *hexalog.RequestOptions) (*hexalog.Entry, error)
+	GetEntry(ctx context.Context, host string, key []byte, id []byte, opts *hexalog.RequestOptions) (*hexalog.Entry, error)
 }
 
-// FSM implements a phi fsm using a dht
+// FSM implements a phi fsm using a dht.  Multiple FSMs can be served by a
+// single phi instance using an FSMRouter
 type FSM interface {
 	Apply(entryID []byte, entry *hexalog.Entry) interface{}
 	RegisterDHT(dht DHT)
@@ -66,6 +87,8 @@ type Phi struct {
 
 	// DHT
 	dht *kelips.Kelips
+	// DHT recording metrics handed to all other components
+	mdht *meteredDHT
 
 	// Gossip delegate
 	dlg *delegate
@@ -81,11 +104,66 @@ type Phi struct {
 
 	// local hexalog instance
 	hexalog *hexalog.Hexalog
+
+	// local hexalog entry store and index
+	entries hexalog.EntryStore
+	lidx    *logIndex
+
+	// Wraps the user fsm
+	fsm *localFSM
+
+	// Serializes snapshots and restores
+	snapMu sync.Mutex
+
+	// Watchers for applied entries
+	watch *watchManager
+
+	// Subsystem metrics.  nil if disabled
+	metrics *metrics
+
+	// Cluster event subscriptions
+	events *eventBus
+
+	logger Logger
+
+	// Listeners and servers to stop on shutdown
+	dhtConn *net.UDPConn
+	httpSrv *http.Server
+
+	shutdownCh   chan struct{}
+	shutdownOnce sync.Once
 }
 
 // Create creates a new Phi instance.  It inits the local node, gossip layer
-// and associated delegates
+// and associated delegates.  The config is validated before anything is
+// started.  The node works on a copy of the config so the given one is not
+// modified
 func Create(conf *Config, fsm FSM) (*Phi, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	conf = conf.clone()
+	if err := conf.deriveAddrs(); err != nil {
+		return nil, err
+	}
+	if conf.Logger == nil {
+		conf.Logger = NewStdLogger()
+	}
+	ltime := &hexatype.LamportClock{}
+	inc, err := nextIncarnation(conf.DataDir, ltime)
+	if err != nil {
+		return nil, err
+	}
+	if err = conf.setupGossip(inc); err != nil {
+		return nil, err
+	}
+
+	// The default grpc server verifies remote proposals with the acl
+	if conf.auth == nil {
+		conf.auth = &entryAuth{}
+	}
+	conf.auth.acl = conf.ACL
+
 	// Coorinate client
 	coord, err := vivaldi.NewClient(vivaldi.DefaultConfig())
 	if err != nil {
@@ -93,10 +171,29 @@ func Create(conf *Config, fsm FSM) (*Phi, error) {
 	}
 
 	fid := &Phi{
-		conf:  conf,
-		ltime: &hexatype.LamportClock{},
-		coord: coord,
+		conf:       conf,
+		ltime:      ltime,
+		coord:      coord,
+		watch:      newWatchManager(),
+		events:     newEventBus(),
+		logger:     conf.Logger,
+		shutdownCh: make(chan struct{}),
+	}
+	fid.watch.logger = conf.Logger
+
+	if conf.Metrics != nil {
+		fid.metrics = newMetrics()
+		if err = fid.metrics.register(conf.Metrics, conf.DHT.AdvertiseHost, fid); err != nil {
+			return nil, err
+		}
 	}
+	// Free the metrics for a retry with the same registry if creation fails
+	defer func() {
+		if err != nil {
+			fid.metrics.unregister()
+		}
+	}()
+
 	//
 	// The order of initialization is important
 	//
@@ -112,15 +209,30 @@ func Create(conf *Config, fsm FSM) (*Phi, error) {
 		return nil, err
 	}
 
+	if err = fid.restoreLatest(); err != nil {
+		return nil, err
+	}
+
+	RegisterPhiRPCServer(conf.GRPCServer, &rpcServer{phi: fid})
+	RegisterPhiAdminRPCServer(conf.GRPCServer, &adminServer{phi: fid})
+
 	fid.init()
 
 	if err = fid.startGrpc(); err != nil {
 		return nil, err
 	}
 
-	fid.memberlist, err = memberlist.Create(conf.Memberlist)
+	if err = fid.startHTTP(); err != nil {
+		return nil, err
+	}
+
+	if fid.memberlist, err = memberlist.Create(conf.Memberlist); err != nil {
+		return nil, err
+	}
+
+	fid.startSnapshots()
 
-	return fid, err
+	return fid, nil
 }
 
 // init is called after all other components are initialized
@@ -132,7 +244,16 @@ func (phi *Phi) init() {
 		ltime:      phi.ltime,
 		dht:        phi.dht,
 		broadcasts: make([][]byte, 0),
+		dead:       make(map[string]struct{}),
+		conflict:   phi.conf.Conflict,
+		rejected:   make(map[string]string),
+		shutdown:   phi.conflictShutdown,
+		admission:  phi.conf.Admission,
+		metrics:    phi.metrics,
+		events:     phi.events,
+		logger:     phi.logger,
 	}
+	phi.wal.RegisterLiveness(phi.dlg)
 
 	// Set all delegates
 	c := phi.conf.Memberlist
@@ -141,10 +262,11 @@ func (phi *Phi) init() {
 	c.Events = phi.dlg
 	c.Alive = phi.dlg
 	c.Conflict = phi.dlg
+	c.Merge = phi.dlg
 }
 
 func (phi *Phi) initDHT() error {
-	udpAddr, err := net.ResolveUDPAddr("udp", phi.conf.DHT.AdvertiseHost)
+	udpAddr, err := net.ResolveUDPAddr("udp", phi.conf.dhtBindAddr())
 	if err != nil {
 		return err
 	}
@@ -154,8 +276,10 @@ func (phi *Phi) initDHT() error {
 		return err
 	}
 
+	phi.dhtConn = ln
 	remote := kelips.NewUDPTransport(ln)
 	phi.dht = kelips.Create(phi.conf.DHT, remote)
+	phi.mdht = &meteredDHT{dht: phi.dht, metrics: phi.metrics}
 
 	phi.local = phi.dht.LocalNode()
 
@@ -164,7 +288,7 @@ func (phi *Phi) initDHT() error {
 
 // must be called after dht is init'd.  It listens on the same port as the dht
 func (phi *Phi) initBlockDevice() error {
-	ln, err := net.Listen("tcp", phi.conf.DHT.AdvertiseHost)
+	ln, err := net.Listen("tcp", phi.conf.dhtBindAddr())
 	if err != nil {
 		return err
 	}
@@ -200,8 +324,10 @@ func (phi *Phi) initBlockDevice() error {
 
 	// DHT block device
 	phi.dev = NewBlockDevice(phi.conf.Replicas, phi.conf.HashFunc, phi.local, index, trans)
+	phi.dev.metrics = phi.metrics
+	phi.dev.logger = phi.logger
 	phi.dev.Register(dev)
-	phi.dev.RegisterDHT(phi.dht)
+	phi.dev.RegisterDHT(phi.mdht)
 
 	err = trans.Start(ln.(*net.TCPListener))
 	return err
@@ -232,32 +358,44 @@ func (phi *Phi) initHexalog(fsm FSM) error {
 
 	stable := &hexalog.InMemStableStore{}
 
-	fsm.RegisterDHT(phi.dht)
+	fsm.RegisterDHT(phi.mdht)
+	phi.fsm = newLocalFSM(fsm, phi.watch, phi.events)
 
 	c := phi.conf.Hexalog
 
-	hexlog, err := hexalog.NewHexalog(c, fsm, entries, index, stable, hlnet)
+	hexlog, err := hexalog.NewHexalog(c, phi.fsm, entries, index, stable, hlnet)
 	if err != nil {
 		return err
 	}
 
 	phi.hexalog = hexlog
+	phi.entries = entries
 
 	trans := &localHexalogTransport{
 		host:   c.AdvertiseHost,
 		hexlog: hexlog,
 		remote: hlnet,
+		auth:   phi.conf.auth,
 	}
 
 	phi.wal = NewHexalog(trans, c.Votes, c.Hasher)
-	phi.conf.Jury.RegisterDHT(phi.dht)
+	phi.wal.SetReadOptions(phi.conf.WALRead)
+	phi.wal.metrics = phi.metrics
+	phi.wal.events = phi.events
+	phi.wal.logger = phi.logger
+	if phi.conf.SigningKey != nil {
+		phi.wal.signer = NewSigner(phi.conf.SigningKey)
+	}
+	phi.lidx = newLogIndex(index)
+	phi.wal.RegisterIndex(phi.lidx)
+	phi.conf.Jury.RegisterDHT(phi.mdht)
 	phi.wal.RegisterJury(phi.conf.Jury)
 
 	return nil
 }
 
 func (phi *Phi) startGrpc() error {
-	ln, err := net.Listen("tcp", phi.conf.Hexalog.AdvertiseHost)
+	ln, err := net.Listen("tcp", phi.conf.grpcBindAddr())
 	if err != nil {
 		return err
 	}
@@ -268,7 +406,47 @@ func (phi *Phi) startGrpc() error {
 		}
 	}()
 
-	log.Println("[INFO] Fidias started:", ln.Addr().String())
+	phi.logger.Info("gRPC started", "addr", ln.Addr().String())
+	return nil
+}
+
+// startHTTP starts the optional http server if an address is configured
+func (phi *Phi) startHTTP() error {
+	if phi.conf.HTTPAddr == "" {
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", phi.conf.HTTPAddr)
+	if err != nil {
+		return err
+	}
+
+	if phi.conf.TLS != nil {
+		tlsConf, err := phi.conf.TLS.ServerConfig()
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		ln = tls.NewListener(ln, tlsConf)
+	}
+
+	if phi.conf.Metrics != nil {
+		phi.conf.HTTPMux.Handle("/metrics", promhttp.HandlerFor(phi.conf.Metrics, promhttp.HandlerOpts{}))
+	}
+
+	var handler http.Handler = phi.conf.HTTPMux
+	if phi.conf.TLS != nil {
+		handler = grpcOrHTTP(phi.conf.GRPCServer, handler)
+	}
+
+	phi.httpSrv = &http.Server{Handler: handler}
+	go func() {
+		if er := phi.httpSrv.Serve(ln); er != nil && er != http.ErrServerClosed {
+			log.Fatal(er)
+		}
+	}()
+
+	phi.logger.Info("HTTP started", "addr", ln.Addr().String())
 	return nil
 }
 
@@ -278,9 +456,20 @@ func (phi *Phi) LocalNode() hexatype.Node {
 	return phi.dht.LocalNode()
 }
 
+// LamportClock returns the cluster wide lamport clock
+func (phi *Phi) LamportClock() *hexatype.LamportClock {
+	return phi.ltime
+}
+
+// Liveness returns an interface to check if a node has left or failed based on
+// gossip
+func (phi *Phi) Liveness() Liveness {
+	return phi.dlg
+}
+
 // DHT returns a distributed hash table interface
 func (phi *Phi) DHT() DHT {
-	return phi.dht
+	return phi.mdht
 }
 
 // BlockDevice returns a cluster aware block device
@@ -301,13 +490,97 @@ func (phi *Phi) Join(existing []string) error {
 
 	n, err := phi.memberlist.Join(existing)
 	if err == nil {
-		log.Println("[INFO] Joined peers:", n)
+		phi.logger.Info("Joined cluster", "peers", n)
 	}
 
 	return err
 }
 
-// Shutdown performs a complete shutdown of all components
+// Done returns a channel that is closed when the node starts shutting down
+// e.g. after losing a gossip name conflict
+func (phi *Phi) Done() <-chan struct{} {
+	return phi.shutdownCh
+}
+
+// Shutdown gracefully leaves the cluster and stops all components.  In-flight
+// gRPC and HTTP requests are allowed to complete
 func (phi *Phi) Shutdown() error {
-	return fmt.Errorf("TBI")
+	var err error
+	phi.shutdownOnce.Do(func() {
+		err = phi.shutdown()
+	})
+	return err
+}
+
+// conflictShutdown shuts down the node after it lost a gossip name conflict
+func (phi *Phi) conflictShutdown() {
+	if err := phi.Shutdown(); err != nil {
+		phi.logger.Error("Shutdown failed", "err", err)
+	}
+}
+
+// stopGrpc gracefully stops the gRPC server.  Requests and streams still
+// running after the leave timeout are cancelled
+func (phi *Phi) stopGrpc() {
+	done := make(chan struct{})
+	go func() {
+		phi.conf.GRPCServer.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(leaveTimeout):
+		phi.logger.Warn("Stopping gRPC server with requests in flight", "timeout", leaveTimeout)
+		phi.conf.GRPCServer.Stop()
+		<-done
+	}
+}
+
+func (phi *Phi) shutdown() error {
+	close(phi.shutdownCh)
+
+	var err error
+	if phi.memberlist != nil {
+		if er := phi.memberlist.Leave(leaveTimeout); er != nil {
+			phi.logger.Error("Failed to leave cluster", "err", er)
+		}
+		if er := phi.memberlist.Shutdown(); er != nil {
+			err = er
+		}
+	}
+
+	if phi.httpSrv != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), leaveTimeout)
+		if er := phi.httpSrv.Shutdown(ctx); er != nil {
+			err = er
+		}
+		cancel()
+	}
+
+	// Watch streams only end once their watchers are closed
+	phi.watch.closeAll()
+	phi.events.closeAll()
+	phi.stopGrpc()
+
+	if er := phi.dev.Close(); er != nil {
+		err = er
+	}
+	if er := phi.dhtConn.Close(); er != nil {
+		err = er
+	}
+
+	// Close the log stores once nothing can write to them
+	for _, store := range []interface{}{phi.entries, phi.lidx.store} {
+		if c, ok := store.(io.Closer); ok {
+			if er := c.Close(); er != nil {
+				err = er
+			}
+		}
+	}
+
+	phi.metrics.unregister()
+
+	phi.logger.Info("Shutdown complete")
+	return err
 }
@@ -1,6 +1,7 @@
 package phi
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"io/ioutil"
@@ -13,6 +14,7 @@ import (
 	"github.com/hexablock/go-kelips"
 	"github.com/hexablock/hexalog"
 	"github.com/hexablock/log"
+	"google.golang.org/grpc"
 )
 
 func TestMain(t *testing.M) {
@@ -132,8 +134,69 @@ func Test_Phi(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	retry := &RetryOptions{Retries: 3, RetryInterval: 30 * time.Millisecond}
+	_, _, err = wal.Update([]byte("key"), func(prev *hexalog.Entry) ([]byte, error) {
+		if prev == nil {
+			t.Fatal("previous entry should exist")
+		}
+		return []byte("value"), nil
+	}, retry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	if err = blx.ReadIndex(wrIdx.ID(), ioutil.Discard, 2); err != nil {
 		t.Fatal(err, hex.EncodeToString(wrIdx.ID()))
 	}
+	if _, err = dev.GetBlockContext(context.Background(), wrIdx.ID()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = dev.GetBlockContext(ctx, wrIdx.ID()); err != context.Canceled {
+		t.Fatal("should fail with", context.Canceled, err)
+	}
+
+}
+
+func Test_Phi_shutdownWatch(t *testing.T) {
+	node, err := newTestPhi("127.0.0.1:41010", "127.0.0.1:18090", "127.0.0.1", 44560)
+	if err != nil {
+		t.Fatal(err)
+	}
 
+	conn, err := grpc.Dial("127.0.0.1:18090", grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	stream, err := NewPhiRPCClient(conn).WatchRPC(context.Background(), &WatchRequest{Prefix: []byte("key")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Wait for the stream to be served
+	for i := 0; ; i++ {
+		node.watch.mu.RLock()
+		n := len(node.watch.watchers)
+		node.watch.mu.RUnlock()
+		if n > 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("watcher not added")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	start := time.Now()
+	if err = node.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d >= leaveTimeout {
+		t.Fatal("shutdown waited for the watch stream", d)
+	}
+	if _, err = stream.Recv(); err == nil {
+		t.Fatal("stream should be closed")
+	}
 }
@@ -0,0 +1,35 @@
+package phi
+
+// rpcServer implements the PhiRPCServer interface
+type rpcServer struct {
+	phi *Phi
+}
+
+// WatchRPC streams applied entries for the requested prefix until the client
+// goes away or the watcher is closed
+func (server *rpcServer) WatchRPC(req *WatchRequest, stream PhiRPC_WatchRPCServer) error {
+	// An empty map is received as nil
+	positions := req.Positions
+	if positions == nil && req.Replay {
+		positions = make(map[string]uint32)
+	}
+
+	w := server.phi.Watch(req.Prefix, positions)
+	defer w.Close()
+
+	ctx := stream.Context()
+	for {
+		select {
+		case ev, ok := <-w.C:
+			if !ok {
+				return w.Err()
+			}
+			if err := stream.Send(ev); err != nil {
+				return err
+			}
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Messages and service stubs for rpc.proto.  Regenerate with `make protoc`
+
+package phi
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+
+// WatchRequest is a request to watch applied entries for keys with the given
+// prefix.  Positions contains the last seen height per key to resume from.
+// Replay replays entries from the log for keys not in Positions
+type WatchRequest struct {
+	Prefix    []byte            `protobuf:"bytes,1,opt,name=Prefix,proto3" json:"Prefix,omitempty"`
+	Positions map[string]uint32 `protobuf:"bytes,2,rep,name=Positions" json:"Positions,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	Replay    bool              `protobuf:"varint,3,opt,name=Replay" json:"Replay,omitempty"`
+}
+
+func (m *WatchRequest) Reset()         { *m = WatchRequest{} }
+func (m *WatchRequest) String() string { return proto.CompactTextString(m) }
+func (*WatchRequest) ProtoMessage()    {}
+
+func (m *WatchRequest) GetPrefix() []byte {
+	if m != nil {
+		return m.Prefix
+	}
+	return nil
+}
+
+func (m *WatchRequest) GetPositions() map[string]uint32 {
+	if m != nil {
+		return m.Positions
+	}
+	return nil
+}
+
+func (m *WatchRequest) GetReplay() bool {
+	if m != nil {
+		return m.Replay
+	}
+	return false
+}
+
+// WatchEvent is an applied WAL entry
+type WatchEvent struct {
+	Key     []byte `protobuf:"bytes,1,opt,name=Key,proto3" json:"Key,omitempty"`
+	Height  uint32 `protobuf:"varint,2,opt,name=Height" json:"Height,omitempty"`
+	EntryID []byte `protobuf:"bytes,3,opt,name=EntryID,proto3" json:"EntryID,omitempty"`
+	Data    []byte `protobuf:"bytes,4,opt,name=Data,proto3" json:"Data,omitempty"`
+}
+
+func (m *WatchEvent) Reset()         { *m = WatchEvent{} }
+func (m *WatchEvent) String() string { return proto.CompactTextString(m) }
+func (*WatchEvent) ProtoMessage()    {}
+
+func (m *WatchEvent) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *WatchEvent) GetHeight() uint32 {
+	if m != nil {
+		return m.Height
+	}
+	return 0
+}
+
+func (m *WatchEvent) GetEntryID() []byte {
+	if m != nil {
+		return m.EntryID
+	}
+	return nil
+}
+
+func (m *WatchEvent) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*WatchRequest)(nil), "phi.WatchRequest")
+	proto.RegisterType((*WatchEvent)(nil), "phi.WatchEvent")
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// Client API for PhiRPC service
+
+type PhiRPCClient interface {
+	WatchRPC(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (PhiRPC_WatchRPCClient, error)
+}
+
+type phiRPCClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewPhiRPCClient(cc *grpc.ClientConn) PhiRPCClient {
+	return &phiRPCClient{cc}
+}
+
+func (c *phiRPCClient) WatchRPC(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (PhiRPC_WatchRPCClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_PhiRPC_serviceDesc.Streams[0], c.cc, "/phi.PhiRPC/WatchRPC", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &phiRPCWatchRPCClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type PhiRPC_WatchRPCClient interface {
+	Recv() (*WatchEvent, error)
+	grpc.ClientStream
+}
+
+type phiRPCWatchRPCClient struct {
+	grpc.ClientStream
+}
+
+func (x *phiRPCWatchRPCClient) Recv() (*WatchEvent, error) {
+	m := new(WatchEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Server API for PhiRPC service
+
+type PhiRPCServer interface {
+	WatchRPC(*WatchRequest, PhiRPC_WatchRPCServer) error
+}
+
+func RegisterPhiRPCServer(s *grpc.Server, srv PhiRPCServer) {
+	s.RegisterService(&_PhiRPC_serviceDesc, srv)
+}
+
+func _PhiRPC_WatchRPC_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PhiRPCServer).WatchRPC(m, &phiRPCWatchRPCServer{stream})
+}
+
+type PhiRPC_WatchRPCServer interface {
+	Send(*WatchEvent) error
+	grpc.ServerStream
+}
+
+type phiRPCWatchRPCServer struct {
+	grpc.ServerStream
+}
+
+func (x *phiRPCWatchRPCServer) Send(m *WatchEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _PhiRPC_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "phi.PhiRPC",
+	HandlerType: (*PhiRPCServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchRPC",
+			Handler:       _PhiRPC_WatchRPC_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "rpc.proto",
+}
@@ -0,0 +1,87 @@
+package s3
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var errMalformedChunk = errors.New("malformed aws-chunked encoding")
+
+// chunkedReader decodes an aws-chunked request body.  Chunk signatures and
+// trailers are not verified
+type chunkedReader struct {
+	r         *bufio.Reader
+	remaining int64
+	done      bool
+}
+
+func newChunkedReader(r io.Reader) *chunkedReader {
+	return &chunkedReader{r: bufio.NewReader(r)}
+}
+
+func (cr *chunkedReader) Read(p []byte) (int, error) {
+	if cr.done {
+		return 0, io.EOF
+	}
+
+	if cr.remaining == 0 {
+		size, err := cr.readHeader()
+		if err != nil {
+			return 0, err
+		}
+		if size == 0 {
+			cr.done = true
+			return 0, io.EOF
+		}
+		cr.remaining = size
+	}
+
+	if int64(len(p)) > cr.remaining {
+		p = p[:cr.remaining]
+	}
+	n, err := cr.r.Read(p)
+	cr.remaining -= int64(n)
+
+	if cr.remaining == 0 && err == nil {
+		// Consume the CRLF trailing the chunk data
+		err = cr.readCRLF()
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+// readHeader reads a chunk header of the form <hex-size>[;chunk-signature=..]
+func (cr *chunkedReader) readHeader() (int64, error) {
+	line, err := cr.r.ReadString('\n')
+	if err != nil {
+		return 0, err
+	}
+
+	line = strings.TrimRight(line, "\r\n")
+	if i := strings.IndexByte(line, ';'); i >= 0 {
+		line = line[:i]
+	}
+
+	size, err := strconv.ParseInt(line, 16, 64)
+	if err != nil || size < 0 {
+		return 0, errMalformedChunk
+	}
+	return size, nil
+}
+
+func (cr *chunkedReader) readCRLF() error {
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(cr.r, b); err != nil {
+		return err
+	}
+	if b[0] != '\r' || b[1] != '\n' {
+		return errMalformedChunk
+	}
+	return nil
+}
@@ -0,0 +1,518 @@
+// Package s3 implements an S3 compatible HTTP gateway for the phi object store.
+// Only path style requests are supported i.e. /<bucket>/<key> and requests are
+// not authenticated.  Signed requests are accepted with the signature ignored.
+//
+// The gateway is an http.Handler and is usually mounted on the phi HTTP mux:
+//
+//	conf.HTTPMux.Handle("/", s3.NewGateway(store))
+//
+// Other handlers registered on the same mux take precedence over buckets of the
+// same name
+package s3
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hexablock/log"
+	"github.com/hexablock/phi/objstore"
+)
+
+const (
+	defaultMaxKeys = 1000
+
+	amzMetaPrefix = "X-Amz-Meta-"
+)
+
+// Backend is the object store served by the gateway.  It is satisfied by
+// *objstore.Store
+type Backend interface {
+	CreateBucket(name string) error
+	DeleteBucket(name string) error
+	ListBuckets() ([]*objstore.BucketInfo, error)
+	HeadBucket(name string) (*objstore.BucketInfo, error)
+
+	Put(bucket, name string, r io.Reader, opts *objstore.PutOptions) (*objstore.ObjectInfo, error)
+	Head(bucket, name string) (*objstore.ObjectInfo, error)
+	GetRange(bucket, name string, offset, length int64, w io.Writer) (*objstore.ObjectInfo, error)
+	Delete(bucket, name string) error
+	List(bucket, prefix, startAfter string, max int) ([]*objstore.ObjectInfo, error)
+
+	CreateMultipartUpload(bucket, name string, opts *objstore.PutOptions) (string, error)
+	UploadPart(bucket, name, uploadID string, number int, r io.Reader) (*objstore.Part, error)
+	CompleteMultipartUpload(bucket, name, uploadID string, parts []*objstore.Part) (*objstore.ObjectInfo, error)
+	AbortMultipartUpload(bucket, name, uploadID string) error
+}
+
+// Gateway serves the S3 API over a Backend
+type Gateway struct {
+	store Backend
+}
+
+// NewGateway returns a gateway serving the given store
+func NewGateway(store Backend) *Gateway {
+	return &Gateway{store: store}
+}
+
+// ServeHTTP dispatches the request to the S3 operation
+func (gw *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key := splitPath(r.URL.Path)
+
+	switch {
+	case bucket == "":
+		if r.Method != http.MethodGet {
+			gw.writeError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "method not allowed")
+			return
+		}
+		gw.listBuckets(w, r)
+
+	case key == "":
+		gw.serveBucket(w, r, bucket)
+
+	default:
+		gw.serveObject(w, r, bucket, key)
+	}
+}
+
+func (gw *Gateway) serveBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	switch r.Method {
+	case http.MethodPut:
+		if err := gw.store.CreateBucket(bucket); err != nil {
+			gw.writeStoreError(w, r, err)
+			return
+		}
+		w.Header().Set("Location", "/"+bucket)
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodDelete:
+		if err := gw.store.DeleteBucket(bucket); err != nil {
+			gw.writeStoreError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodHead:
+		if _, err := gw.store.HeadBucket(bucket); err != nil {
+			gw.writeStoreError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodGet:
+		gw.listObjects(w, r, bucket)
+
+	default:
+		gw.writeError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "method not allowed")
+	}
+}
+
+func (gw *Gateway) serveObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+
+	switch r.Method {
+	case http.MethodPut:
+		if uploadID != "" {
+			gw.uploadPart(w, r, bucket, key, uploadID)
+		} else {
+			gw.putObject(w, r, bucket, key)
+		}
+
+	case http.MethodGet:
+		gw.getObject(w, r, bucket, key)
+
+	case http.MethodHead:
+		info, err := gw.store.Head(bucket, key)
+		if err != nil {
+			gw.writeStoreError(w, r, err)
+			return
+		}
+		setObjectHeaders(w, info)
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodDelete:
+		var err error
+		if uploadID != "" {
+			err = gw.store.AbortMultipartUpload(bucket, key, uploadID)
+		} else if err = gw.store.Delete(bucket, key); err == objstore.ErrNoSuchKey {
+			// Deleting a non-existent object is not an error in S3
+			err = nil
+		}
+		if err != nil {
+			gw.writeStoreError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodPost:
+		if _, ok := query["uploads"]; ok {
+			gw.createMultipartUpload(w, r, bucket, key)
+		} else if uploadID != "" {
+			gw.completeMultipartUpload(w, r, bucket, key, uploadID)
+		} else {
+			gw.writeError(w, r, http.StatusBadRequest, "InvalidRequest", "unsupported post request")
+		}
+
+	default:
+		gw.writeError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "method not allowed")
+	}
+}
+
+func (gw *Gateway) listBuckets(w http.ResponseWriter, r *http.Request) {
+	buckets, err := gw.store.ListBuckets()
+	if err != nil {
+		gw.writeStoreError(w, r, err)
+		return
+	}
+
+	resp := &listAllMyBucketsResult{Xmlns: s3Namespace, Owner: owner{ID: "phi", DisplayName: "phi"}}
+	for _, b := range buckets {
+		resp.Buckets = append(resp.Buckets, bucketEntry{Name: b.Name, CreationDate: formatTime(b.Created)})
+	}
+
+	writeXML(w, http.StatusOK, resp)
+}
+
+// listObjects implements ListObjectsV2.  The continuation token is the base64
+// encoded last key or common prefix returned
+func (gw *Gateway) listObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+
+	resp := &listBucketV2Result{
+		Xmlns:             s3Namespace,
+		Name:              bucket,
+		Prefix:            query.Get("prefix"),
+		Delimiter:         query.Get("delimiter"),
+		StartAfter:        query.Get("start-after"),
+		ContinuationToken: query.Get("continuation-token"),
+		MaxKeys:           defaultMaxKeys,
+	}
+
+	if v := query.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			gw.writeError(w, r, http.StatusBadRequest, "InvalidArgument", "invalid max-keys")
+			return
+		}
+		if n < resp.MaxKeys {
+			resp.MaxKeys = n
+		}
+	}
+
+	after := resp.StartAfter
+	if resp.ContinuationToken != "" {
+		b, err := base64.StdEncoding.DecodeString(resp.ContinuationToken)
+		if err != nil {
+			gw.writeError(w, r, http.StatusBadRequest, "InvalidArgument", "invalid continuation token")
+			return
+		}
+		after = string(b)
+	}
+
+	objects, err := gw.store.List(bucket, resp.Prefix, after, 0)
+	if err != nil {
+		gw.writeStoreError(w, r, err)
+		return
+	}
+
+	last := after
+	for _, obj := range objects {
+		// Skip keys rolled up into the last returned common prefix
+		if resp.Delimiter != "" && strings.HasSuffix(last, resp.Delimiter) &&
+			strings.HasPrefix(obj.Name, last) {
+			continue
+		}
+
+		if resp.KeyCount == resp.MaxKeys {
+			resp.IsTruncated = true
+			resp.NextContinuationToken = base64.StdEncoding.EncodeToString([]byte(last))
+			break
+		}
+
+		if cp := commonPrefixOf(obj.Name, resp.Prefix, resp.Delimiter); cp != "" {
+			resp.CommonPrefixes = append(resp.CommonPrefixes, commonPrefix{Prefix: cp})
+			last = cp
+		} else {
+			resp.Contents = append(resp.Contents, objectEntry{
+				Key:          obj.Name,
+				LastModified: formatTime(obj.Modified),
+				ETag:         quoteETag(obj.ETag),
+				Size:         obj.Size,
+				StorageClass: "STANDARD",
+			})
+			last = obj.Name
+		}
+		resp.KeyCount++
+	}
+
+	writeXML(w, http.StatusOK, resp)
+}
+
+func (gw *Gateway) putObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	info, err := gw.store.Put(bucket, key, requestBody(r), putOptions(r))
+	if err != nil {
+		gw.writeStoreError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", quoteETag(info.ETag))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (gw *Gateway) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	info, err := gw.store.Head(bucket, key)
+	if err != nil {
+		gw.writeStoreError(w, r, err)
+		return
+	}
+
+	offset, length, partial, err := parseRange(r.Header.Get("Range"), info.Size)
+	if err != nil {
+		w.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(info.Size, 10))
+		gw.writeError(w, r, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", err.Error())
+		return
+	}
+
+	setObjectHeaders(w, info)
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	status := http.StatusOK
+	if partial {
+		w.Header().Set("Content-Range", "bytes "+strconv.FormatInt(offset, 10)+"-"+
+			strconv.FormatInt(offset+length-1, 10)+"/"+strconv.FormatInt(info.Size, 10))
+		status = http.StatusPartialContent
+	}
+	w.WriteHeader(status)
+
+	if _, err = gw.store.GetRange(bucket, key, offset, length, w); err != nil {
+		// Headers have been sent so the error can only be logged
+		log.Printf("[ERROR] S3 get failed bucket=%s key=%s: %v", bucket, key, err)
+	}
+}
+
+func (gw *Gateway) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	id, err := gw.store.CreateMultipartUpload(bucket, key, putOptions(r))
+	if err != nil {
+		gw.writeStoreError(w, r, err)
+		return
+	}
+
+	writeXML(w, http.StatusOK, &initiateMultipartUploadResult{
+		Xmlns:    s3Namespace,
+		Bucket:   bucket,
+		Key:      key,
+		UploadID: id,
+	})
+}
+
+func (gw *Gateway) uploadPart(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string) {
+	number, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || number < 1 {
+		gw.writeError(w, r, http.StatusBadRequest, "InvalidArgument", "invalid part number")
+		return
+	}
+
+	part, err := gw.store.UploadPart(bucket, key, uploadID, number, requestBody(r))
+	if err != nil {
+		gw.writeStoreError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", quoteETag(part.ETag))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (gw *Gateway) completeMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string) {
+	var req completeMultipartUpload
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		gw.writeError(w, r, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+
+	parts := make([]*objstore.Part, len(req.Parts))
+	for i, p := range req.Parts {
+		parts[i] = &objstore.Part{Number: p.PartNumber, ETag: strings.Trim(p.ETag, `"`)}
+	}
+
+	info, err := gw.store.CompleteMultipartUpload(bucket, key, uploadID, parts)
+	if err != nil {
+		gw.writeStoreError(w, r, err)
+		return
+	}
+
+	writeXML(w, http.StatusOK, &completeMultipartUploadResult{
+		Xmlns:    s3Namespace,
+		Location: "/" + bucket + "/" + key,
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     quoteETag(info.ETag),
+	})
+}
+
+// writeStoreError maps object store errors to S3 error responses
+func (gw *Gateway) writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case objstore.ErrNoSuchBucket:
+		gw.writeError(w, r, http.StatusNotFound, "NoSuchBucket", err.Error())
+	case objstore.ErrNoSuchKey:
+		gw.writeError(w, r, http.StatusNotFound, "NoSuchKey", err.Error())
+	case objstore.ErrBucketExists:
+		gw.writeError(w, r, http.StatusConflict, "BucketAlreadyOwnedByYou", err.Error())
+	case objstore.ErrBucketNotEmpty:
+		gw.writeError(w, r, http.StatusConflict, "BucketNotEmpty", err.Error())
+	case objstore.ErrInvalidRange:
+		gw.writeError(w, r, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", err.Error())
+	case objstore.ErrNoSuchUpload:
+		gw.writeError(w, r, http.StatusNotFound, "NoSuchUpload", err.Error())
+	case objstore.ErrInvalidPart:
+		gw.writeError(w, r, http.StatusBadRequest, "InvalidPart", err.Error())
+	case objstore.ErrNoParts:
+		gw.writeError(w, r, http.StatusBadRequest, "InvalidRequest", err.Error())
+	case objstore.ErrInvalidBucketName:
+		gw.writeError(w, r, http.StatusBadRequest, "InvalidBucketName", err.Error())
+	case errMalformedChunk:
+		gw.writeError(w, r, http.StatusBadRequest, "IncompleteBody", err.Error())
+	default:
+		log.Printf("[ERROR] S3 request failed method=%s path=%s: %v", r.Method, r.URL.Path, err)
+		gw.writeError(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+	}
+}
+
+func (gw *Gateway) writeError(w http.ResponseWriter, r *http.Request, status int, code, msg string) {
+	// Head responses have no body
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	writeXML(w, status, &errorResponse{Code: code, Message: msg, Resource: r.URL.Path})
+}
+
+func writeXML(w http.ResponseWriter, status int, v interface{}) {
+	b, err := xml.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	w.Write(b)
+}
+
+// requestBody returns the request body decoding aws-chunked uploads
+func requestBody(r *http.Request) io.Reader {
+	if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return newChunkedReader(r.Body)
+	}
+	return r.Body
+}
+
+// putOptions returns the content type and user metadata of the request
+func putOptions(r *http.Request) *objstore.PutOptions {
+	opts := &objstore.PutOptions{ContentType: r.Header.Get("Content-Type")}
+
+	for k, v := range r.Header {
+		if strings.HasPrefix(k, amzMetaPrefix) && len(v) > 0 {
+			if opts.Tags == nil {
+				opts.Tags = make(map[string]string)
+			}
+			opts.Tags[strings.ToLower(k[len(amzMetaPrefix):])] = v[0]
+		}
+	}
+
+	return opts
+}
+
+func setObjectHeaders(w http.ResponseWriter, info *objstore.ObjectInfo) {
+	h := w.Header()
+	h.Set("ETag", quoteETag(info.ETag))
+	h.Set("Last-Modified", info.Modified.UTC().Format(http.TimeFormat))
+	h.Set("Accept-Ranges", "bytes")
+	if info.ContentType != "" {
+		h.Set("Content-Type", info.ContentType)
+	} else {
+		h.Set("Content-Type", "application/octet-stream")
+	}
+	for k, v := range info.Tags {
+		h.Set(amzMetaPrefix+k, v)
+	}
+}
+
+// parseRange parses a single byte range header returning the offset and length
+// to read and whether the range is partial
+func parseRange(header string, size int64) (int64, int64, bool, error) {
+	if header == "" {
+		return 0, size, false, nil
+	}
+
+	spec := strings.TrimPrefix(header, "bytes=")
+	if spec == header || strings.Contains(spec, ",") {
+		return 0, 0, false, objstore.ErrInvalidRange
+	}
+
+	i := strings.IndexByte(spec, '-')
+	if i < 0 {
+		return 0, 0, false, objstore.ErrInvalidRange
+	}
+	startStr, endStr := spec[:i], spec[i+1:]
+
+	// Suffix range i.e. the last n bytes
+	if startStr == "" {
+		n, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false, objstore.ErrInvalidRange
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true, nil
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false, objstore.ErrInvalidRange
+	}
+
+	end := size - 1
+	if endStr != "" {
+		if end, err = strconv.ParseInt(endStr, 10, 64); err != nil || end < start {
+			return 0, 0, false, objstore.ErrInvalidRange
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+
+	return start, end - start + 1, true, nil
+}
+
+// commonPrefixOf returns the common prefix the key rolls up into or an empty
+// string if it is listed as is
+func commonPrefixOf(key, prefix, delim string) string {
+	if delim == "" {
+		return ""
+	}
+	i := strings.Index(key[len(prefix):], delim)
+	if i < 0 {
+		return ""
+	}
+	return key[:len(prefix)+i+len(delim)]
+}
+
+func splitPath(p string) (string, string) {
+	p = strings.TrimPrefix(p, "/")
+	i := strings.IndexByte(p, '/')
+	if i < 0 {
+		return p, ""
+	}
+	return p[:i], p[i+1:]
+}
+
+func quoteETag(etag string) string {
+	return `"` + etag + `"`
+}
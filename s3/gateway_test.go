@@ -0,0 +1,460 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/hexablock/phi/objstore"
+)
+
+type testObject struct {
+	info *objstore.ObjectInfo
+	data []byte
+}
+
+// testBackend is an in-memory Backend
+type testBackend struct {
+	buckets map[string]map[string]*testObject
+	uploads map[string]map[int][]byte
+}
+
+func newTestBackend() *testBackend {
+	return &testBackend{
+		buckets: make(map[string]map[string]*testObject),
+		uploads: make(map[string]map[int][]byte),
+	}
+}
+
+func (be *testBackend) CreateBucket(name string) error {
+	if _, ok := be.buckets[name]; ok {
+		return objstore.ErrBucketExists
+	}
+	be.buckets[name] = make(map[string]*testObject)
+	return nil
+}
+
+func (be *testBackend) DeleteBucket(name string) error {
+	b, ok := be.buckets[name]
+	if !ok {
+		return objstore.ErrNoSuchBucket
+	}
+	if len(b) > 0 {
+		return objstore.ErrBucketNotEmpty
+	}
+	delete(be.buckets, name)
+	return nil
+}
+
+func (be *testBackend) ListBuckets() ([]*objstore.BucketInfo, error) {
+	var out []*objstore.BucketInfo
+	for n := range be.buckets {
+		out = append(out, &objstore.BucketInfo{Name: n})
+	}
+	return out, nil
+}
+
+func (be *testBackend) HeadBucket(name string) (*objstore.BucketInfo, error) {
+	if _, ok := be.buckets[name]; !ok {
+		return nil, objstore.ErrNoSuchBucket
+	}
+	return &objstore.BucketInfo{Name: name}, nil
+}
+
+func (be *testBackend) Put(bucket, name string, r io.Reader, opts *objstore.PutOptions) (*objstore.ObjectInfo, error) {
+	b, ok := be.buckets[bucket]
+	if !ok {
+		return nil, objstore.ErrNoSuchBucket
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := md5.Sum(data)
+	info := &objstore.ObjectInfo{
+		Bucket:   bucket,
+		Name:     name,
+		Size:     int64(len(data)),
+		ETag:     hex.EncodeToString(sum[:]),
+		Modified: time.Now(),
+	}
+	if opts != nil {
+		info.ContentType = opts.ContentType
+		info.Tags = opts.Tags
+	}
+	b[name] = &testObject{info: info, data: data}
+
+	return info, nil
+}
+
+func (be *testBackend) Head(bucket, name string) (*objstore.ObjectInfo, error) {
+	b, ok := be.buckets[bucket]
+	if !ok {
+		return nil, objstore.ErrNoSuchBucket
+	}
+	obj, ok := b[name]
+	if !ok {
+		return nil, objstore.ErrNoSuchKey
+	}
+	return obj.info, nil
+}
+
+func (be *testBackend) GetRange(bucket, name string, offset, length int64, w io.Writer) (*objstore.ObjectInfo, error) {
+	info, err := be.Head(bucket, name)
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 {
+		length = info.Size - offset
+	}
+	_, err = w.Write(be.buckets[bucket][name].data[offset : offset+length])
+	return info, err
+}
+
+func (be *testBackend) Delete(bucket, name string) error {
+	if _, err := be.Head(bucket, name); err != nil {
+		return err
+	}
+	delete(be.buckets[bucket], name)
+	return nil
+}
+
+func (be *testBackend) List(bucket, prefix, startAfter string, max int) ([]*objstore.ObjectInfo, error) {
+	b, ok := be.buckets[bucket]
+	if !ok {
+		return nil, objstore.ErrNoSuchBucket
+	}
+
+	var names []string
+	for n := range b {
+		if strings.HasPrefix(n, prefix) && n > startAfter {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+
+	out := make([]*objstore.ObjectInfo, len(names))
+	for i, n := range names {
+		out[i] = b[n].info
+	}
+	return out, nil
+}
+
+func (be *testBackend) CreateMultipartUpload(bucket, name string, opts *objstore.PutOptions) (string, error) {
+	id := fmt.Sprintf("upload%d", len(be.uploads))
+	be.uploads[id] = make(map[int][]byte)
+	return id, nil
+}
+
+func (be *testBackend) UploadPart(bucket, name, uploadID string, number int, r io.Reader) (*objstore.Part, error) {
+	up, ok := be.uploads[uploadID]
+	if !ok {
+		return nil, objstore.ErrNoSuchUpload
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	up[number] = data
+
+	sum := md5.Sum(data)
+	return &objstore.Part{Number: number, Size: int64(len(data)), ETag: hex.EncodeToString(sum[:])}, nil
+}
+
+func (be *testBackend) CompleteMultipartUpload(bucket, name, uploadID string, parts []*objstore.Part) (*objstore.ObjectInfo, error) {
+	up, ok := be.uploads[uploadID]
+	if !ok {
+		return nil, objstore.ErrNoSuchUpload
+	}
+
+	var buf bytes.Buffer
+	for _, p := range parts {
+		data, ok := up[p.Number]
+		if !ok {
+			return nil, objstore.ErrInvalidPart
+		}
+		buf.Write(data)
+	}
+	delete(be.uploads, uploadID)
+
+	info, err := be.Put(bucket, name, &buf, nil)
+	if err == nil {
+		info.ETag = objstore.MultipartETag(parts)
+	}
+	return info, err
+}
+
+func (be *testBackend) AbortMultipartUpload(bucket, name, uploadID string) error {
+	if _, ok := be.uploads[uploadID]; !ok {
+		return objstore.ErrNoSuchUpload
+	}
+	delete(be.uploads, uploadID)
+	return nil
+}
+
+func doRequest(t *testing.T, srv *httptest.Server, method, path string, body io.Reader, hdrs map[string]string) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, srv.URL+path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range hdrs {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, b
+}
+
+func Test_Gateway(t *testing.T) {
+	srv := httptest.NewServer(NewGateway(newTestBackend()))
+	defer srv.Close()
+
+	if resp, _ := doRequest(t, srv, "PUT", "/bkt/missing", strings.NewReader("x"), nil); resp.StatusCode != 404 {
+		t.Fatal("should fail without bucket", resp.StatusCode)
+	}
+	if resp, _ := doRequest(t, srv, "PUT", "/bkt", nil, nil); resp.StatusCode != 200 {
+		t.Fatal("create bucket failed", resp.StatusCode)
+	}
+
+	hdrs := map[string]string{"Content-Type": "text/plain", "X-Amz-Meta-Owner": "test"}
+	for _, k := range []string{"a/1", "a/2", "b", "c/d/e"} {
+		if resp, _ := doRequest(t, srv, "PUT", "/bkt/"+k, strings.NewReader("0123456789"), hdrs); resp.StatusCode != 200 {
+			t.Fatal("put failed", k, resp.StatusCode)
+		}
+	}
+
+	resp, body := doRequest(t, srv, "GET", "/bkt/b", nil, nil)
+	if resp.StatusCode != 200 || string(body) != "0123456789" {
+		t.Fatal("wrong object", resp.StatusCode, string(body))
+	}
+	if resp.Header.Get("X-Amz-Meta-Owner") != "test" || resp.Header.Get("Content-Type") != "text/plain" {
+		t.Fatal("wrong headers", resp.Header)
+	}
+
+	resp, body = doRequest(t, srv, "GET", "/bkt/b", nil, map[string]string{"Range": "bytes=2-4"})
+	if resp.StatusCode != 206 || string(body) != "234" {
+		t.Fatal("wrong range", resp.StatusCode, string(body))
+	}
+	if resp.Header.Get("Content-Range") != "bytes 2-4/10" {
+		t.Fatal("wrong content range", resp.Header.Get("Content-Range"))
+	}
+
+	resp, _ = doRequest(t, srv, "GET", "/bkt/b", nil, map[string]string{"Range": "bytes=20-"})
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatal("should fail with invalid range", resp.StatusCode)
+	}
+
+	// List with a delimiter in pages of 2
+	var (
+		token string
+		seen  []string
+	)
+	for {
+		_, body = doRequest(t, srv, "GET", "/bkt?list-type=2&delimiter=/&max-keys=2&continuation-token="+token, nil, nil)
+		var res listBucketV2Result
+		if err := xml.Unmarshal(body, &res); err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range res.Contents {
+			seen = append(seen, c.Key)
+		}
+		for _, cp := range res.CommonPrefixes {
+			seen = append(seen, cp.Prefix)
+		}
+		if !res.IsTruncated {
+			break
+		}
+		token = res.NextContinuationToken
+	}
+	sort.Strings(seen)
+	if fmt.Sprint(seen) != "[a/ b c/]" {
+		t.Fatal("wrong listing", seen)
+	}
+
+	if resp, _ = doRequest(t, srv, "DELETE", "/bkt/b", nil, nil); resp.StatusCode != 204 {
+		t.Fatal("delete failed", resp.StatusCode)
+	}
+	if resp, _ = doRequest(t, srv, "HEAD", "/bkt/b", nil, nil); resp.StatusCode != 404 {
+		t.Fatal("should not exist", resp.StatusCode)
+	}
+}
+
+func Test_Gateway_multipart(t *testing.T) {
+	srv := httptest.NewServer(NewGateway(newTestBackend()))
+	defer srv.Close()
+
+	doRequest(t, srv, "PUT", "/bkt", nil, nil)
+
+	_, body := doRequest(t, srv, "POST", "/bkt/obj?uploads", nil, nil)
+	var init initiateMultipartUploadResult
+	if err := xml.Unmarshal(body, &init); err != nil {
+		t.Fatal(err)
+	}
+
+	var complete completeMultipartUpload
+	for i, data := range []string{"hello ", "world"} {
+		path := fmt.Sprintf("/bkt/obj?partNumber=%d&uploadId=%s", i+1, init.UploadID)
+		resp, _ := doRequest(t, srv, "PUT", path, strings.NewReader(data), nil)
+		if resp.StatusCode != 200 {
+			t.Fatal("upload part failed", resp.StatusCode)
+		}
+		complete.Parts = append(complete.Parts, completePart{PartNumber: i + 1, ETag: resp.Header.Get("ETag")})
+	}
+
+	b, _ := xml.Marshal(&complete)
+	resp, _ := doRequest(t, srv, "POST", "/bkt/obj?uploadId="+init.UploadID, bytes.NewReader(b), nil)
+	if resp.StatusCode != 200 {
+		t.Fatal("complete failed", resp.StatusCode)
+	}
+
+	if _, body = doRequest(t, srv, "GET", "/bkt/obj", nil, nil); string(body) != "hello world" {
+		t.Fatal("wrong data", string(body))
+	}
+}
+
+func Test_chunkedReader(t *testing.T) {
+	body := "5;chunk-signature=abc\r\nhello\r\n6;chunk-signature=def\r\n world\r\n0;chunk-signature=ghi\r\n\r\n"
+	b, err := ioutil.ReadAll(newChunkedReader(strings.NewReader(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello world" {
+		t.Fatal("wrong data", string(b))
+	}
+
+	if _, err = ioutil.ReadAll(newChunkedReader(strings.NewReader("zz\r\n"))); err != errMalformedChunk {
+		t.Fatal("should fail with", errMalformedChunk, err)
+	}
+}
+
+func Test_Gateway_awsClient(t *testing.T) {
+	srv := httptest.NewServer(NewGateway(newTestBackend()))
+	defer srv.Close()
+
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:         aws.String(srv.URL),
+		Region:           aws.String("us-east-1"),
+		Credentials:      credentials.NewStaticCredentials("key", "secret", ""),
+		S3ForcePathStyle: aws.Bool(true),
+		DisableSSL:       aws.Bool(true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := awss3.New(sess)
+
+	if _, err = client.CreateBucket(&awss3.CreateBucketInput{Bucket: aws.String("bkt")}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []string{"a/1", "a/2", "b"} {
+		_, err = client.PutObject(&awss3.PutObjectInput{
+			Bucket:      aws.String("bkt"),
+			Key:         aws.String(k),
+			Body:        strings.NewReader("0123456789"),
+			ContentType: aws.String("text/plain"),
+			Metadata:    map[string]*string{"Owner": aws.String("test")},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get, err := client.GetObject(&awss3.GetObjectInput{
+		Bucket: aws.String("bkt"),
+		Key:    aws.String("b"),
+		Range:  aws.String("bytes=2-4"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(get.Body)
+	get.Body.Close()
+	if string(b) != "234" || aws.StringValue(get.Metadata["Owner"]) != "test" {
+		t.Fatal("wrong object", string(b), get.Metadata)
+	}
+
+	list, err := client.ListObjectsV2(&awss3.ListObjectsV2Input{
+		Bucket: aws.String("bkt"),
+		Prefix: aws.String("a/"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Contents) != 2 || aws.StringValue(list.Contents[1].Key) != "a/2" {
+		t.Fatal("wrong listing", list.Contents)
+	}
+
+	// Multipart upload
+	up, err := client.CreateMultipartUpload(&awss3.CreateMultipartUploadInput{
+		Bucket: aws.String("bkt"),
+		Key:    aws.String("obj"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var parts []*awss3.CompletedPart
+	for i, data := range []string{"hello ", "world"} {
+		part, err := client.UploadPart(&awss3.UploadPartInput{
+			Bucket:     aws.String("bkt"),
+			Key:        aws.String("obj"),
+			UploadId:   up.UploadId,
+			PartNumber: aws.Int64(int64(i + 1)),
+			Body:       strings.NewReader(data),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, &awss3.CompletedPart{ETag: part.ETag, PartNumber: aws.Int64(int64(i + 1))})
+	}
+
+	complete, err := client.CompleteMultipartUpload(&awss3.CompleteMultipartUploadInput{
+		Bucket:          aws.String("bkt"),
+		Key:             aws.String("obj"),
+		UploadId:        up.UploadId,
+		MultipartUpload: &awss3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(complete.ETag) != `"e09e4fd6265b36115fe3db32df945d84-2"` {
+		t.Fatal("wrong multipart etag", aws.StringValue(complete.ETag))
+	}
+
+	if _, err = client.DeleteObject(&awss3.DeleteObjectInput{Bucket: aws.String("bkt"), Key: aws.String("b")}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.HeadObject(&awss3.HeadObjectInput{Bucket: aws.String("bkt"), Key: aws.String("b")})
+	if aerr, ok := err.(awserr.RequestFailure); !ok || aerr.StatusCode() != 404 {
+		t.Fatal("should not exist", err)
+	}
+}
@@ -0,0 +1,207 @@
+package phi
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/hexablock/hexalog"
+)
+
+// Signed entry data starts with the magic, the frame version, the length of
+// the payload, the public key of the signer and the signature followed by the
+// payload.  Unsigned data is only taken as signed if the whole frame matches
+const (
+	signedMagic      = "\x00phisig"
+	signedVersion    = 1
+	signedPrefixSize = len(signedMagic) + 1 + 4
+	signedHeaderSize = signedPrefixSize + ed25519.PublicKeySize + ed25519.SignatureSize
+)
+
+var (
+	errInvalidSigningKey = errors.New("invalid ed25519 signing key")
+	errInvalidSignature  = errors.New("invalid entry signature")
+	errUnauthorized      = errors.New("not authorized to write key")
+)
+
+// Signer signs the data of log entries with an ed25519 key
+type Signer struct {
+	key ed25519.PrivateKey
+}
+
+// NewSigner returns a Signer for the private key
+func NewSigner(key ed25519.PrivateKey) *Signer {
+	return &Signer{key: key}
+}
+
+// PublicKey returns the public key entries are verified with
+func (signer *Signer) PublicKey() ed25519.PublicKey {
+	return signer.key.Public().(ed25519.PublicKey)
+}
+
+// Sign prefixes the entry data with the public key and a signature over the
+// key, previous hash, height and data.  The entry must not be changed after it
+// is signed.  Already signed entries are left as is
+func (signer *Signer) Sign(entry *hexalog.Entry) {
+	if isSigned(entry.Data) {
+		return
+	}
+
+	sig := ed25519.Sign(signer.key, signedBytes(entry, entry.Data))
+
+	data := make([]byte, signedPrefixSize, signedHeaderSize+len(entry.Data))
+	copy(data, signedMagic)
+	data[len(signedMagic)] = signedVersion
+	binary.BigEndian.PutUint32(data[len(signedMagic)+1:], uint32(len(entry.Data)))
+	data = append(data, signer.PublicKey()...)
+	data = append(data, sig...)
+	entry.Data = append(data, entry.Data...)
+}
+
+// signedBytes returns the bytes signed for the entry with the given payload
+func signedBytes(entry *hexalog.Entry, payload []byte) []byte {
+	buf := make([]byte, 12, 12+len(entry.Key)+len(entry.Previous)+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(len(entry.Key)))
+	binary.BigEndian.PutUint64(buf[4:], uint64(entry.Height))
+
+	buf = append(buf, entry.Key...)
+	buf = append(buf, entry.Previous...)
+	return append(buf, payload...)
+}
+
+// isSigned returns true if the data has the signed frame with a version and
+// payload length matching the data
+func isSigned(data []byte) bool {
+	if len(data) < signedHeaderSize || !bytes.HasPrefix(data, []byte(signedMagic)) {
+		return false
+	}
+	if data[len(signedMagic)] != signedVersion {
+		return false
+	}
+	size := binary.BigEndian.Uint32(data[len(signedMagic)+1:])
+	return uint64(size) == uint64(len(data)-signedHeaderSize)
+}
+
+// EntryData returns the entry data without the signature if it is signed.
+// FSMs are applied with the unsigned data
+func EntryData(entry *hexalog.Entry) []byte {
+	if !isSigned(entry.Data) {
+		return entry.Data
+	}
+	return entry.Data[signedHeaderSize:]
+}
+
+// EntrySigner returns the public key the entry was signed with or nil if it is
+// not signed.  The signature is not verified
+func EntrySigner(entry *hexalog.Entry) ed25519.PublicKey {
+	if !isSigned(entry.Data) {
+		return nil
+	}
+	return ed25519.PublicKey(entry.Data[signedPrefixSize : signedPrefixSize+ed25519.PublicKeySize])
+}
+
+// verifyEntry verifies the signature of a signed entry and returns the public
+// key of the signer.  It returns nil for unsigned entries
+func verifyEntry(entry *hexalog.Entry) (ed25519.PublicKey, error) {
+	pub := EntrySigner(entry)
+	if pub == nil {
+		return nil, nil
+	}
+
+	sig := entry.Data[signedPrefixSize+ed25519.PublicKeySize : signedHeaderSize]
+	if !ed25519.Verify(pub, signedBytes(entry, EntryData(entry)), sig) {
+		return nil, errInvalidSignature
+	}
+	return pub, nil
+}
+
+// ACL authorizes writes to log keys.  pub is nil for unsigned entries
+type ACL interface {
+	Authorized(key []byte, pub ed25519.PublicKey) bool
+}
+
+// PrefixACL restricts writes to keys by prefix.  The longest matching prefix
+// applies.  Keys not matching any prefix can be written by anyone
+type PrefixACL struct {
+	mu    sync.RWMutex
+	rules map[string][]ed25519.PublicKey
+}
+
+// NewPrefixACL returns an ACL without any restrictions
+func NewPrefixACL() *PrefixACL {
+	return &PrefixACL{rules: make(map[string][]ed25519.PublicKey)}
+}
+
+// Allow authorizes the public keys to write keys with the prefix.  Only
+// entries signed by one of the allowed keys can be written once a prefix is
+// added
+func (acl *PrefixACL) Allow(prefix []byte, keys ...ed25519.PublicKey) {
+	acl.mu.Lock()
+	acl.rules[string(prefix)] = append(acl.rules[string(prefix)], keys...)
+	acl.mu.Unlock()
+}
+
+// Authorized satisfies the ACL interface
+func (acl *PrefixACL) Authorized(key []byte, pub ed25519.PublicKey) bool {
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+
+	var (
+		match string
+		keys  []ed25519.PublicKey
+		found bool
+	)
+	for prefix, k := range acl.rules {
+		if bytes.HasPrefix(key, []byte(prefix)) && (!found || len(prefix) > len(match)) {
+			match, keys, found = prefix, k, true
+		}
+	}
+
+	if !found {
+		return true
+	}
+	for _, k := range keys {
+		if pub != nil && k.Equal(pub) {
+			return true
+		}
+	}
+	return false
+}
+
+// entryAuth verifies entry signatures and authorizes the signer against the
+// ACL before the local node votes on a proposal
+type entryAuth struct {
+	acl ACL
+}
+
+func (auth *entryAuth) authorize(entry *hexalog.Entry) error {
+	pub, err := verifyEntry(entry)
+	if err != nil {
+		return err
+	}
+
+	if auth != nil && auth.acl != nil && !auth.acl.Authorized(entry.Key, pub) {
+		return errUnauthorized
+	}
+	return nil
+}
+
+// unaryInterceptor authorizes entries proposed by remote nodes
+func (auth *entryAuth) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if strings.HasSuffix(info.FullMethod, "/ProposeRPC") {
+		if r, ok := req.(interface{ GetEntry() *hexalog.Entry }); ok && r.GetEntry() != nil {
+			if err := auth.authorize(r.GetEntry()); err != nil {
+				return nil, status.Error(codes.PermissionDenied, err.Error())
+			}
+		}
+	}
+	return handler(ctx, req)
+}
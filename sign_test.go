@@ -0,0 +1,136 @@
+package phi
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/hexablock/hexalog"
+)
+
+func testSigner(t *testing.T) *Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewSigner(key)
+}
+
+func Test_Signer(t *testing.T) {
+	signer := testSigner(t)
+
+	entry := &hexalog.Entry{Key: []byte("key"), Previous: make([]byte, 32), Height: 1, Data: []byte("data")}
+	signer.Sign(entry)
+	// Signing twice is a no-op
+	signer.Sign(entry)
+
+	if string(EntryData(entry)) != "data" {
+		t.Fatalf("wrong data %q", EntryData(entry))
+	}
+	if !bytes.Equal(EntrySigner(entry), signer.PublicKey()) {
+		t.Fatal("wrong signer")
+	}
+	if pub, err := verifyEntry(entry); err != nil || !bytes.Equal(pub, signer.PublicKey()) {
+		t.Fatal("should verify", err)
+	}
+
+	// Moving a signed entry to another key invalidates it
+	entry.Key = []byte("other")
+	if _, err := verifyEntry(entry); err != errInvalidSignature {
+		t.Fatal("should fail with", errInvalidSignature, err)
+	}
+
+	unsigned := &hexalog.Entry{Key: []byte("key"), Data: []byte("data")}
+	if pub, err := verifyEntry(unsigned); pub != nil || err != nil {
+		t.Fatal("unsigned entries have no signer", pub, err)
+	}
+
+	// Unsigned data starting with the magic is not taken as signed
+	data := append([]byte(signedMagic+"\x01"), make([]byte, signedHeaderSize+10)...)
+	unsigned = &hexalog.Entry{Key: []byte("key"), Data: data}
+	if !bytes.Equal(EntryData(unsigned), data) || EntrySigner(unsigned) != nil {
+		t.Fatal("unsigned data misparsed")
+	}
+	// and can be signed
+	signer.Sign(unsigned)
+	if !bytes.Equal(EntryData(unsigned), data) {
+		t.Fatal("wrong data")
+	}
+	if _, err := verifyEntry(unsigned); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_PrefixACL(t *testing.T) {
+	tenant, admin := testSigner(t), testSigner(t)
+
+	acl := NewPrefixACL()
+	acl.Allow([]byte("tenant/"), tenant.PublicKey(), admin.PublicKey())
+	acl.Allow([]byte("tenant/config/"), admin.PublicKey())
+
+	cases := []struct {
+		key string
+		pub ed25519.PublicKey
+		ok  bool
+	}{
+		{"open/key", nil, true},
+		{"tenant/key", tenant.PublicKey(), true},
+		{"tenant/key", nil, false},
+		{"tenant/config/key", tenant.PublicKey(), false},
+		{"tenant/config/key", admin.PublicKey(), true},
+	}
+	for _, c := range cases {
+		if acl.Authorized([]byte(c.key), c.pub) != c.ok {
+			t.Errorf("key=%s: want %v", c.key, c.ok)
+		}
+	}
+}
+
+type testProposeReq struct {
+	entry *hexalog.Entry
+}
+
+func (req *testProposeReq) GetEntry() *hexalog.Entry {
+	return req.entry
+}
+
+func Test_entryAuth(t *testing.T) {
+	signer := testSigner(t)
+	acl := NewPrefixACL()
+	acl.Allow([]byte("tenant/"), signer.PublicKey())
+	auth := &entryAuth{acl: acl}
+
+	signed := &hexalog.Entry{Key: []byte("tenant/key"), Height: 1, Data: []byte("data")}
+	signer.Sign(signed)
+	if err := auth.authorize(signed); err != nil {
+		t.Fatal(err)
+	}
+
+	unsigned := &hexalog.Entry{Key: []byte("tenant/key"), Height: 1, Data: []byte("data")}
+	if err := auth.authorize(unsigned); err != errUnauthorized {
+		t.Fatal("should fail with", errUnauthorized, err)
+	}
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return req, nil }
+	info := &grpc.UnaryServerInfo{FullMethod: "/hexalog.HexalogRPC/ProposeRPC"}
+
+	_, err := auth.unaryInterceptor(context.Background(), &testProposeReq{unsigned}, info, handler)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatal("should deny remote proposal", err)
+	}
+	if _, err = auth.unaryInterceptor(context.Background(), &testProposeReq{signed}, info, handler); err != nil {
+		t.Fatal(err)
+	}
+
+	// Other methods are not checked
+	info.FullMethod = "/hexalog.HexalogRPC/GetRPC"
+	if _, err = auth.unaryInterceptor(context.Background(), &testProposeReq{unsigned}, info, handler); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,327 @@
+package phi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hexablock/blox"
+	"github.com/hexablock/hexalog"
+)
+
+var errSnapshotNotSupported = errors.New("fsm does not support snapshots")
+
+// SnapshotFSM is an FSM that can snapshot and restore its state.  Apply is not
+// called while Snapshot is in progress
+type SnapshotFSM interface {
+	FSM
+	Snapshot(w io.Writer) error
+	Restore(r io.Reader) error
+}
+
+// snapshotKey is the last applied entry for a key at the time of the snapshot
+type snapshotKey struct {
+	Key    []byte
+	Height uint32
+	ID     []byte
+}
+
+// snapshotManifest describes a snapshot.  It is stored as a block along with the
+// fsm state
+type snapshotManifest struct {
+	// Blox index id of the fsm state
+	State     []byte
+	Keys      []*snapshotKey
+	Timestamp int64
+}
+
+// Snapshot takes a snapshot of the FSM state, stores it on the block device and
+// compacts the local log entries below the snapshot height of each key.  A
+// copy is kept in the data dir to restore from on start.  It returns the id of
+// the snapshot
+func (phi *Phi) Snapshot() ([]byte, error) {
+	sfsm, ok := phi.fsm.fsm.(SnapshotFSM)
+	if !ok {
+		return nil, errSnapshotNotSupported
+	}
+
+	phi.snapMu.Lock()
+	defer phi.snapMu.Unlock()
+
+	manifest := &snapshotManifest{Timestamp: time.Now().UnixNano()}
+
+	// Block applies while the fsm writes its state so the applied positions
+	// match the state.  The state is uploaded once applies are unblocked
+	state := new(bytes.Buffer)
+	phi.fsm.mu.Lock()
+	for k, pos := range phi.fsm.applied {
+		manifest.Keys = append(manifest.Keys, &snapshotKey{Key: []byte(k), Height: pos.Height, ID: pos.ID})
+	}
+	// Carry over keys compacted by previous snapshots not applied since
+	for k, height := range phi.lidx.copyFloors() {
+		if _, ok := phi.fsm.applied[k]; !ok {
+			manifest.Keys = append(manifest.Keys, &snapshotKey{Key: []byte(k), Height: height})
+		}
+	}
+	err := sfsm.Snapshot(state)
+	phi.fsm.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	blx := blox.NewBlox(phi.dev)
+	idx, err := blx.WriteIndex(bytes.NewReader(state.Bytes()), 2)
+	if err != nil {
+		return nil, err
+	}
+	manifest.State = idx.ID()
+
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if idx, err = blx.WriteIndex(bytes.NewReader(b), 1); err != nil {
+		return nil, err
+	}
+	id := idx.ID()
+
+	if err = phi.saveLocal(id, b, state.Bytes()); err != nil {
+		return nil, err
+	}
+
+	phi.logger.Info("Snapshot taken", "id", hexID(id), "keys", len(manifest.Keys))
+
+	phi.compact(manifest)
+
+	return id, nil
+}
+
+// RestoreSnapshot restores the FSM state from the snapshot with the given id and
+// then applies any entries in the local log after the snapshot
+func (phi *Phi) RestoreSnapshot(id []byte) error {
+	sfsm, ok := phi.fsm.fsm.(SnapshotFSM)
+	if !ok {
+		return errSnapshotNotSupported
+	}
+
+	phi.snapMu.Lock()
+	defer phi.snapMu.Unlock()
+
+	blx := blox.NewBlox(phi.dev)
+
+	buf := new(bytes.Buffer)
+	if err := blx.ReadIndex(id, buf, 1); err != nil {
+		return err
+	}
+
+	var manifest snapshotManifest
+	if err := json.Unmarshal(buf.Bytes(), &manifest); err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(blx.ReadIndex(manifest.State, pw, 2))
+	}()
+	defer pr.Close()
+
+	return phi.restore(id, sfsm, &manifest, pr)
+}
+
+// restoreLatest restores the FSM from the latest snapshot taken by this node
+// if any.  It is called on start before the WAL is used as entries compacted
+// by the snapshot are no longer in the local log.  The local copy is used so
+// the cluster need not be reachable
+func (phi *Phi) restoreLatest() error {
+	id, err := phi.LatestSnapshot()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	sfsm, ok := phi.fsm.fsm.(SnapshotFSM)
+	if !ok {
+		return errSnapshotNotSupported
+	}
+
+	f, err := os.Open(phi.localSnapshotPath(id))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rd := bufio.NewReader(f)
+	manifest, err := readLocalManifest(rd)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range manifest.Keys {
+		phi.lidx.setFloor(k.Key, k.Height)
+	}
+
+	return phi.restore(id, sfsm, manifest, rd)
+}
+
+// restore restores the FSM state from r and applies the entries in the local
+// log after the snapshot
+func (phi *Phi) restore(id []byte, sfsm SnapshotFSM, manifest *snapshotManifest, r io.Reader) error {
+	phi.fsm.mu.Lock()
+	err := sfsm.Restore(r)
+
+	positions := make(map[string]uint32, len(manifest.Keys))
+	phi.fsm.applied = make(map[string]*appliedPos, len(manifest.Keys))
+	for _, k := range manifest.Keys {
+		positions[string(k.Key)] = k.Height
+		phi.fsm.applied[string(k.Key)] = &appliedPos{Height: k.Height, ID: k.ID}
+	}
+	phi.fsm.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	// Apply entries after the snapshot
+	var n int
+	err = phi.iterLocal(nil, positions, func(key, eid []byte, entry *hexalog.Entry) bool {
+		phi.fsm.Apply(eid, entry)
+		n++
+		return true
+	})
+
+	phi.logger.Info("Snapshot restored", "id", hexID(id), "keys", len(manifest.Keys), "applied", n)
+	return err
+}
+
+// compact removes local log entries below the snapshot height of each key.
+// The entry at the snapshot height is kept so the hash chain can be verified
+// from that point on
+func (phi *Phi) compact(manifest *snapshotManifest) {
+	var n int
+	for _, k := range manifest.Keys {
+		// Nothing below the first entry
+		if k.Height < 2 {
+			continue
+		}
+
+		ids, err := phi.lidx.EntryIDs(k.Key, 1, k.Height-1)
+		if err != nil {
+			phi.logger.Error("Compaction failed", "key", k.Key, "err", err)
+			continue
+		}
+
+		for _, id := range ids {
+			if err = phi.entries.Delete(id); err != nil {
+				phi.logger.Error("Compaction failed", "key", k.Key, "id", hexID(id), "err", err)
+				break
+			}
+			n++
+		}
+
+		if err == nil {
+			phi.lidx.setFloor(k.Key, k.Height)
+		}
+	}
+
+	phi.logger.Info("Compacted log", "keys", len(manifest.Keys), "entries", n)
+}
+
+// LatestSnapshot returns the id of the latest snapshot taken by this node
+func (phi *Phi) LatestSnapshot() ([]byte, error) {
+	b, err := ioutil.ReadFile(filepath.Join(phi.conf.DataDir, "snapshot", "id"))
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(string(b))
+}
+
+func (phi *Phi) localSnapshotPath(id []byte) string {
+	return filepath.Join(phi.conf.DataDir, "snapshot", hex.EncodeToString(id))
+}
+
+// saveLocal persists the manifest and state of the snapshot to the data dir
+// followed by its id.  The manifest is written as the first line followed by
+// the state.  The previous snapshot is removed
+func (phi *Phi) saveLocal(id, manifest, state []byte) error {
+	dir := filepath.Join(phi.conf.DataDir, "snapshot")
+	os.MkdirAll(dir, 0755)
+
+	prev, _ := phi.LatestSnapshot()
+
+	b := make([]byte, 0, len(manifest)+1+len(state))
+	b = append(b, manifest...)
+	b = append(b, '\n')
+	b = append(b, state...)
+	if err := writeFileAtomic(phi.localSnapshotPath(id), b); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, "id"), []byte(hex.EncodeToString(id))); err != nil {
+		return err
+	}
+
+	if prev != nil && !bytes.Equal(prev, id) {
+		os.Remove(phi.localSnapshotPath(prev))
+	}
+	return nil
+}
+
+func writeFileAtomic(name string, b []byte) error {
+	tmp := name + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// readLocalManifest reads the manifest line of a local snapshot leaving r at
+// the start of the state
+func readLocalManifest(r *bufio.Reader) (*snapshotManifest, error) {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest snapshotManifest
+	if err = json.Unmarshal(line, &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// startSnapshots periodically takes snapshots if the fsm supports it and an
+// interval is configured
+func (phi *Phi) startSnapshots() {
+	if phi.conf.SnapshotInterval <= 0 {
+		return
+	}
+	if _, ok := phi.fsm.fsm.(SnapshotFSM); !ok {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(phi.conf.SnapshotInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := phi.Snapshot(); err != nil {
+					phi.logger.Error("Snapshot failed", "err", err)
+				}
+
+			case <-phi.shutdownCh:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,50 @@
+package phi
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test_Phi_saveLocal(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "phi-snapshot")
+	defer os.RemoveAll(dir)
+	phi := &Phi{conf: &Config{DataDir: dir}}
+
+	manifest := &snapshotManifest{Keys: []*snapshotKey{{Key: []byte("key"), Height: 3}}}
+	b, _ := json.Marshal(manifest)
+
+	for _, id := range [][]byte{[]byte("id1"), []byte("id2")} {
+		if err := phi.saveLocal(id, b, []byte("state\nwith lines")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	id, err := phi.LatestSnapshot()
+	if err != nil || string(id) != "id2" {
+		t.Fatal("wrong latest snapshot", string(id), err)
+	}
+	if _, err = os.Stat(phi.localSnapshotPath([]byte("id1"))); !os.IsNotExist(err) {
+		t.Fatal("previous snapshot should be removed", err)
+	}
+
+	f, err := os.Open(phi.localSnapshotPath(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	rd := bufio.NewReader(f)
+	m, err := readLocalManifest(rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Keys) != 1 || m.Keys[0].Height != 3 {
+		t.Fatalf("wrong manifest %+v", m)
+	}
+	if state, _ := ioutil.ReadAll(rd); string(state) != "state\nwith lines" {
+		t.Fatalf("wrong state %q", state)
+	}
+}
@@ -0,0 +1,216 @@
+package phi
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+var (
+	errTLSFilesRequired = errors.New("tls cert, key and ca files required")
+	errInvalidCA        = errors.New("no certificates found in ca file")
+	errNoPeerCert       = errors.New("no peer certificate")
+	errTLSTransports    = errors.New("tls cannot be applied to the grpc, block and dht transports")
+)
+
+// TLSConfig configures mutual TLS.  Both sides present a certificate signed by
+// the CA.  The files are reloaded when modified so certificates can be rotated
+// without a restart
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	CAFile   string
+
+	// The upstream hexalog and blox transports cannot use TLS so the gRPC
+	// listener, block and dht transports are served in plain text.  Phi
+	// refuses to start with TLS unless this is set to accept that
+	AllowPlaintextTransports bool
+}
+
+// Validate checks all files are set and can be loaded
+func (tc *TLSConfig) Validate() error {
+	_, err := newCertReloader(tc)
+	return err
+}
+
+// ServerConfig returns a tls config for servers requiring client certificates
+func (tc *TLSConfig) ServerConfig() (*tls.Config, error) {
+	r, err := newCertReloader(tc)
+	if err != nil {
+		return nil, err
+	}
+	return r.serverConfig(), nil
+}
+
+// ClientConfig returns a tls config for clients presenting a certificate.  The
+// server certificate must be valid for serverName if not empty
+func (tc *TLSConfig) ClientConfig(serverName string) (*tls.Config, error) {
+	r, err := newCertReloader(tc)
+	if err != nil {
+		return nil, err
+	}
+	return r.clientConfig(serverName), nil
+}
+
+// certReloader holds the key pair and ca pool reloading them when any of the
+// files change.  A failed reload keeps the current ones
+type certReloader struct {
+	conf *TLSConfig
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+	modTime time.Time
+}
+
+func newCertReloader(conf *TLSConfig) (*certReloader, error) {
+	if conf.CertFile == "" || conf.KeyFile == "" || conf.CAFile == "" {
+		return nil, errTLSFilesRequired
+	}
+
+	r := &certReloader{conf: conf}
+	return r, r.reload()
+}
+
+// latestModTime returns the most recent modification time of all files
+func (r *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, fp := range []string{r.conf.CertFile, r.conf.KeyFile, r.conf.CAFile} {
+		fi, err := os.Stat(fp)
+		if err != nil {
+			return latest, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (r *certReloader) reload() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.conf.CertFile, r.conf.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	ca, err := ioutil.ReadFile(r.conf.CAFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return errInvalidCA
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.pool = pool
+	r.modTime = modTime
+	r.mu.Unlock()
+
+	return nil
+}
+
+// current reloads the files if modified and returns the key pair and ca pool
+func (r *certReloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.RLock()
+	loaded := r.modTime
+	r.mu.RUnlock()
+
+	if modTime, err := r.latestModTime(); err == nil && modTime.After(loaded) {
+		r.reload()
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, r.pool
+}
+
+// serverConfig offers h2 so gRPC clients can reach the gRPC server through the
+// HTTP server
+func (r *certReloader) serverConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := r.current()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				NextProtos:   []string{"h2", "http/1.1"},
+				Certificates: []tls.Certificate{*cert},
+				ClientCAs:    pool,
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+			}, nil
+		},
+	}
+}
+
+// grpcOrHTTP serves gRPC requests with the gRPC server and all others with the
+// handler.  It lets gRPC clients e.g. phictl use mutual TLS through the HTTP
+// server as the gRPC listener must stay plain text for the hexalog transport
+func grpcOrHTTP(srv *grpc.Server, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			srv.ServeHTTP(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func (r *certReloader) clientConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		},
+		// Verification is done below against the current ca pool as RootCAs
+		// cannot be swapped once the config is in use
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return r.verifyServer(rawCerts, serverName)
+		},
+	}
+}
+
+func (r *certReloader) verifyServer(rawCerts [][]byte, serverName string) error {
+	if len(rawCerts) == 0 {
+		return errNoPeerCert
+	}
+
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs[i] = cert
+	}
+
+	_, pool := r.current()
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		DNSName:       serverName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	_, err := certs[0].Verify(opts)
+	return err
+}
@@ -0,0 +1,232 @@
+package phi
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func writePEM(t *testing.T, fp, typ string, der []byte) {
+	if err := ioutil.WriteFile(fp, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTestCerts writes a new ca and a key pair signed by it to dir.  The
+// modification time is set to mod
+func writeTestCerts(t *testing.T, dir string, mod time.Time) *TLSConfig {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "phi test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	tc := &TLSConfig{
+		CertFile: filepath.Join(dir, "cert.pem"),
+		KeyFile:  filepath.Join(dir, "key.pem"),
+		CAFile:   filepath.Join(dir, "ca.pem"),
+	}
+	writePEM(t, tc.CAFile, "CERTIFICATE", caDER)
+	writePEM(t, tc.CertFile, "CERTIFICATE", der)
+	writePEM(t, tc.KeyFile, "EC PRIVATE KEY", keyDER)
+
+	for _, fp := range []string{tc.CAFile, tc.CertFile, tc.KeyFile} {
+		if err = os.Chtimes(fp, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tc
+}
+
+func testHandshake(t *testing.T, server *tls.Config, client *tls.Config) error {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, er := ln.Accept()
+		if er == nil {
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), client)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Client certificate errors surface on the first read
+	_, err = conn.Read(make([]byte, 1))
+	if err != nil && err.Error() == "EOF" {
+		err = nil
+	}
+	return err
+}
+
+func Test_TLSConfig(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "phi-tls")
+	defer os.RemoveAll(dir)
+
+	if err := (&TLSConfig{}).Validate(); err != errTLSFilesRequired {
+		t.Fatal("should fail without files", err)
+	}
+
+	tc := writeTestCerts(t, dir, time.Now().Add(-time.Minute))
+	server, err := tc.ServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := tc.ClientConfig("localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = testHandshake(t, server, client); err != nil {
+		t.Fatal(err)
+	}
+
+	// Client without a certificate
+	noCert := &tls.Config{InsecureSkipVerify: true}
+	if err = testHandshake(t, server, noCert); err == nil {
+		t.Fatal("should fail without client certificate")
+	}
+
+	// Server not valid for the name
+	other, _ := tc.ClientConfig("example.com")
+	if err = testHandshake(t, server, other); err == nil {
+		t.Fatal("should fail with wrong server name")
+	}
+
+	// Rotate the ca and certificates.  The old certificate is no longer
+	// trusted while both sides pick up the new ones without a restart
+	oldCert, err := tls.LoadX509KeyPair(tc.CertFile, tc.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := &tls.Config{Certificates: []tls.Certificate{oldCert}, InsecureSkipVerify: true}
+
+	writeTestCerts(t, dir, time.Now())
+	if err = testHandshake(t, server, old); err == nil {
+		t.Fatal("should fail with old client certificate")
+	}
+	if err = testHandshake(t, server, client); err != nil {
+		t.Fatal(err)
+	}
+
+	// Node to node transports stay plain text which must be accepted
+	conf := testValidConfig()
+	conf.TLS = tc
+	if err = conf.Validate(); err != errTLSTransports {
+		t.Fatal("should fail with", errTLSTransports, err)
+	}
+	tc.AllowPlaintextTransports = true
+	if err = conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_grpcOrHTTP(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "phi-tls")
+	defer os.RemoveAll(dir)
+
+	tc := writeTestCerts(t, dir, time.Now())
+	server, err := tc.ServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := tc.ClientConfig("localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gsrv := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(gsrv, health.NewServer())
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("pong")) })
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: grpcOrHTTP(gsrv, mux)}
+	go srv.Serve(tls.NewListener(ln, server))
+	defer srv.Close()
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	addr := net.JoinHostPort("localhost", port)
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(credentials.NewTLS(client)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Fatal("wrong status", resp.Status)
+	}
+
+	// Plain HTTP requests still go to the mux
+	hc := &http.Client{Transport: &http.Transport{TLSClientConfig: client}}
+	hresp, err := hc.Get("https://" + addr + "/ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hresp.Body.Close()
+	if b, _ := ioutil.ReadAll(hresp.Body); string(b) != "pong" {
+		t.Fatal("wrong response", string(b))
+	}
+}
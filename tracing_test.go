@@ -0,0 +1,35 @@
+package phi
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+func Test_injectTrace(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3},
+		SpanID:     trace.SpanID{4, 5, 6},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "key", "value")
+	ctx = injectTrace(trace.ContextWithSpanContext(ctx, sc))
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if len(md.Get("key")) != 1 {
+		t.Fatal("existing metadata should be kept")
+	}
+
+	// The server side extracts the same trace
+	out := otel.GetTextMapPropagator().Extract(context.Background(), metadataCarrier(md))
+	if got := trace.SpanContextFromContext(out); got.TraceID() != sc.TraceID() || got.SpanID() != sc.SpanID() {
+		t.Fatalf("wrong span context %v", got)
+	}
+}
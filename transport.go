@@ -4,9 +4,37 @@ import (
 	"context"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/status"
+
 	"github.com/hexablock/hexalog"
+	"github.com/hexablock/hexatype"
 )
 
+// Errors compared by identity that remote nodes return as gRPC status errors
+var remoteErrors = []error{
+	hexatype.ErrPreviousHash,
+	hexatype.ErrKeyNotFound,
+	hexatype.ErrEntryNotFound,
+}
+
+// remoteError maps a gRPC status error from a remote node back to the hexatype
+// error with the same message so callers can compare errors by identity e.g.
+// to retry on ErrPreviousHash.  Other errors are returned as is
+func remoteError(err error) error {
+	st, ok := status.FromError(err)
+	if !ok || err == nil {
+		return err
+	}
+	for _, e := range remoteErrors {
+		if st.Message() == e.Error() {
+			return e
+		}
+	}
+	return err
+}
+
 type localHexalogTransport struct {
 	host string
 
@@ -15,6 +43,9 @@ type localHexalogTransport struct {
 
 	// Network transport
 	remote hexalog.Transport
+
+	// Authorizes local proposals
+	auth *entryAuth
 }
 
 func newLocalHexalogTransport(host string, remote hexalog.Transport) *localHexalogTransport {
@@ -24,21 +55,41 @@ func newLocalHexalogTransport(host string, remote hexalog.Transport) *localHexal
 	}
 }
 
-func (trans *localHexalogTransport) NewEntry(host string, key []byte, opt *hexalog.RequestOptions) (*hexalog.Entry, error) {
+func (trans *localHexalogTransport) NewEntry(ctx context.Context, host string, key []byte, opt *hexalog.RequestOptions) (entry *hexalog.Entry, err error) {
+	ctx, span := trans.startSpan(ctx, "HexalogTransport.NewEntry", host, key)
+	defer func() { endSpan(span, err) }()
+
 	if trans.host == host {
 		return trans.hexlog.New(key), nil
 	}
-	return trans.remote.NewEntry(host, key, opt)
+
+	// The remote transport takes no context so the trace is not continued by
+	// the remote node
+	entry, err = waitEntry(ctx, func() (*hexalog.Entry, error) {
+		return trans.remote.NewEntry(host, key, opt)
+	})
+	return entry, remoteError(err)
 }
 
-func (trans *localHexalogTransport) ProposeEntry(ctx context.Context, host string, entry *hexalog.Entry, opts *hexalog.RequestOptions) (*hexalog.ReqResp, error) {
-	// Remote host
+func (trans *localHexalogTransport) ProposeEntry(ctx context.Context, host string, entry *hexalog.Entry, opts *hexalog.RequestOptions) (resp *hexalog.ReqResp, err error) {
+	ctx, span := trans.startSpan(ctx, "HexalogTransport.ProposeEntry", host, entry.Key)
+	defer func() { endSpan(span, err) }()
+
+	// Remote host.  The trace is continued by the remote gRPC server
 	if trans.host != host {
-		return trans.remote.ProposeEntry(ctx, host, entry, opts)
+		resp, err = trans.remote.ProposeEntry(injectTrace(ctx), host, entry, opts)
+		return resp, remoteError(err)
 	}
 
 	// Local
-	resp := &hexalog.ReqResp{}
+	resp = &hexalog.ReqResp{}
+	if err = ctx.Err(); err != nil {
+		return resp, err
+	}
+	if err = trans.auth.authorize(entry); err != nil {
+		return resp, err
+	}
+
 	ballot, err := trans.hexlog.Propose(entry, opts)
 	if err != nil {
 		return resp, err
@@ -47,24 +98,113 @@ func (trans *localHexalogTransport) ProposeEntry(ctx context.Context, host strin
 	if !opts.WaitBallot {
 		return resp, nil
 	}
-	if err = ballot.Wait(); err != nil {
+	if err = waitContext(ctx, ballot.Wait); err != nil {
 		return resp, err
 	}
 	resp.BallotTime = ballot.Runtime().Nanoseconds()
+	span.AddEvent("ballot closed")
 
 	if opts.WaitApply {
 		fut := ballot.Future()
-		_, err = fut.Wait(time.Duration(opts.WaitApplyTimeout) * time.Millisecond)
+		err = waitContext(ctx, func() error {
+			_, er := fut.Wait(time.Duration(opts.WaitApplyTimeout) * time.Millisecond)
+			return er
+		})
 		resp.ApplyTime = fut.Runtime().Nanoseconds()
+		span.AddEvent("applied")
 	}
 
 	return resp, err
 }
 
 // GetEntry gets a local or remote entry based on host
-func (trans *localHexalogTransport) GetEntry(host string, key, id []byte, opt *hexalog.RequestOptions) (*hexalog.Entry, error) {
+func (trans *localHexalogTransport) GetEntry(ctx context.Context, host string, key, id []byte, opt *hexalog.RequestOptions) (entry *hexalog.Entry, err error) {
+	ctx, span := trans.startSpan(ctx, "HexalogTransport.GetEntry", host, key)
+	defer func() { endSpan(span, err) }()
+
 	if trans.host == host {
 		return trans.hexlog.Get(key, id)
 	}
-	return trans.remote.GetEntry(host, key, id, opt)
+
+	// Not traced on the remote node as with NewEntry
+	entry, err = waitEntry(ctx, func() (*hexalog.Entry, error) {
+		return trans.remote.GetEntry(host, key, id, opt)
+	})
+	return entry, remoteError(err)
+}
+
+// CanRepair returns true if host is the local participant
+func (trans *localHexalogTransport) CanRepair(host string) bool {
+	return trans.hexlog != nil && trans.host == host
+}
+
+// RepairKey heals the key on the local participant using the peers in the
+// options.  Remote participants cannot be repaired
+func (trans *localHexalogTransport) RepairKey(ctx context.Context, host string, key []byte, opts *hexalog.RequestOptions) (err error) {
+	ctx, span := trans.startSpan(ctx, "HexalogTransport.RepairKey", host, key)
+	defer func() { endSpan(span, err) }()
+
+	if trans.host != host {
+		return errRepairRemote
+	}
+
+	return waitContext(ctx, func() error {
+		return trans.hexlog.Heal(key, opts)
+	})
+}
+
+func (trans *localHexalogTransport) startSpan(ctx context.Context, name, host string, key []byte) (context.Context, trace.Span) {
+	return startSpan(ctx, name, key,
+		attribute.String("phi.host", host),
+		attribute.Bool("phi.local", trans.host == host),
+	)
+}
+
+// waitContext calls fn returning its error or the context error if the context
+// is done before fn returns.  fn continues to run in the background in the
+// latter case
+func waitContext(ctx context.Context, fn func() error) error {
+	if ctx.Done() == nil {
+		return fn()
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- fn()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type entryResult struct {
+	entry *hexalog.Entry
+	err   error
+}
+
+// waitEntry is waitContext for calls returning an entry.  The entry is only
+// handed over through the channel so an abandoned call never races with the
+// caller.  The remote transport takes no context so the call itself runs until
+// the transport times out
+func waitEntry(ctx context.Context, fn func() (*hexalog.Entry, error)) (*hexalog.Entry, error) {
+	if ctx.Done() == nil {
+		return fn()
+	}
+
+	resCh := make(chan entryResult, 1)
+	go func() {
+		entry, err := fn()
+		resCh <- entryResult{entry, err}
+	}()
+
+	select {
+	case res := <-resCh:
+		return res.entry, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
 }
@@ -0,0 +1,95 @@
+package phi
+
+import (
+	"context"
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/hexablock/hexalog"
+	"github.com/hexablock/hexatype"
+)
+
+func Test_waitEntry(t *testing.T) {
+	release := make(chan struct{})
+	done := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	entry, err := waitEntry(ctx, func() (*hexalog.Entry, error) {
+		defer close(done)
+		<-release
+		return &hexalog.Entry{Key: []byte("key")}, nil
+	})
+	if err != context.Canceled || entry != nil {
+		t.Fatal("should fail with", context.Canceled, entry, err)
+	}
+	// The abandoned call completes without anyone reading the result
+	close(release)
+	<-done
+
+	entry, err = waitEntry(context.Background(), func() (*hexalog.Entry, error) {
+		return &hexalog.Entry{Key: []byte("key")}, nil
+	})
+	if err != nil || string(entry.Key) != "key" {
+		t.Fatal("wrong entry", entry, err)
+	}
+}
+
+// testRemoteTransport is a remote hexalog transport returning errors the way
+// they arrive over gRPC
+type testRemoteTransport struct {
+	hexalog.Transport
+
+	// Proposals to fail with a previous hash mismatch
+	conflicts int
+	proposed  int
+}
+
+func (trans *testRemoteTransport) NewEntry(host string, key []byte, opts *hexalog.RequestOptions) (*hexalog.Entry, error) {
+	return &hexalog.Entry{Key: key, Previous: make([]byte, 32), Height: 1}, nil
+}
+
+func (trans *testRemoteTransport) ProposeEntry(ctx context.Context, host string, entry *hexalog.Entry, opts *hexalog.RequestOptions) (*hexalog.ReqResp, error) {
+	trans.proposed++
+	if trans.proposed <= trans.conflicts {
+		return nil, status.Error(codes.Unknown, hexatype.ErrPreviousHash.Error())
+	}
+	return &hexalog.ReqResp{}, nil
+}
+
+func Test_localHexalogTransport_remoteError(t *testing.T) {
+	remote := &testRemoteTransport{conflicts: 2}
+	wal := NewHexalog(newLocalHexalogTransport("local", remote), 2, sha256.New)
+	wal.RegisterJury(newTestJury(2))
+
+	retry := &RetryOptions{Retries: 3, RetryInterval: time.Millisecond}
+	_, _, err := wal.Update([]byte("key"), func(prev *hexalog.Entry) ([]byte, error) {
+		return []byte("value"), nil
+	}, retry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remote.proposed != 3 {
+		t.Fatal("remote conflicts should be retried", remote.proposed)
+	}
+
+	// Out of retries
+	remote.proposed, remote.conflicts = 0, 5
+	_, _, err = wal.Update([]byte("key"), func(prev *hexalog.Entry) ([]byte, error) {
+		return []byte("value"), nil
+	}, retry)
+	if err != hexatype.ErrPreviousHash {
+		t.Fatal("should fail with", hexatype.ErrPreviousHash, err)
+	}
+
+	if err = remoteError(status.Error(codes.Unavailable, "down")); status.Code(err) != codes.Unavailable {
+		t.Fatal("other errors should be kept", err)
+	}
+	if remoteError(nil) != nil {
+		t.Fatal("should be nil")
+	}
+}
@@ -0,0 +1,289 @@
+package phi
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+
+	"github.com/hexablock/hexalog"
+)
+
+var errWatcherLagging = errors.New("watcher lagging")
+
+// Watcher receives entries applied on the local node for keys with a given
+// prefix.  C is closed when the watcher is closed or if it falls too far
+// behind, in which case Err returns the reason.  A watcher can be resumed by
+// passing the last seen height of each key to Watch
+type Watcher struct {
+	C <-chan *WatchEvent
+
+	out    chan *WatchEvent
+	in     chan *WatchEvent
+	prefix []byte
+
+	// Last height sent per key.  Only accessed by the run loop
+	sent map[string]uint32
+
+	wm   *watchManager
+	stop chan struct{}
+	once sync.Once
+
+	mu  sync.Mutex
+	err error
+	// Set while replaying.  Live entries are dropped instead of closing the
+	// watcher if the buffer is full and replayed afterwards
+	replaying bool
+	missed    bool
+}
+
+func newWatcher(prefix []byte, positions map[string]uint32, buffSize int) *Watcher {
+	w := &Watcher{
+		out:    make(chan *WatchEvent),
+		in:     make(chan *WatchEvent, buffSize),
+		prefix: prefix,
+		sent:   make(map[string]uint32, len(positions)),
+		stop:   make(chan struct{}),
+	}
+	w.C = w.out
+
+	for k, v := range positions {
+		w.sent[k] = v
+	}
+
+	return w
+}
+
+// Err returns the error that caused the watcher to close if any
+func (w *Watcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Close stops the watcher and closes C
+func (w *Watcher) Close() {
+	w.closeWithError(nil)
+}
+
+func (w *Watcher) closeWithError(err error) {
+	w.once.Do(func() {
+		w.mu.Lock()
+		w.err = err
+		w.mu.Unlock()
+
+		w.wm.remove(w)
+		close(w.stop)
+	})
+}
+
+// run replays entries if a replay function is given and then forwards live
+// entries until the watcher is closed.  The replay function is called with the
+// last sent height per key until no live entries were dropped
+func (w *Watcher) run(replay func(positions map[string]uint32, send func(*WatchEvent) bool)) {
+	defer close(w.out)
+
+	if replay != nil {
+		for {
+			replay(w.sent, w.send)
+			if !w.replayMissed() {
+				break
+			}
+		}
+	}
+
+	for {
+		select {
+		case ev := <-w.in:
+			if !w.send(ev) {
+				return
+			}
+
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// dropLive returns true if a live entry can be dropped because it will be
+// replayed
+func (w *Watcher) dropLive() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.replaying {
+		w.missed = true
+	}
+	return w.replaying
+}
+
+// replayMissed returns true if live entries were dropped during the last
+// replay.  Otherwise replaying ends
+func (w *Watcher) replayMissed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	select {
+	case <-w.stop:
+		w.replaying = false
+		return false
+	default:
+	}
+
+	missed := w.missed
+	w.missed = false
+	w.replaying = missed
+	return missed
+}
+
+// send sends the event skipping ones already seen.  It returns false if the
+// watcher was closed
+func (w *Watcher) send(ev *WatchEvent) bool {
+	key := string(ev.Key)
+	if ev.Height <= w.sent[key] {
+		return true
+	}
+
+	select {
+	case w.out <- ev:
+		w.sent[key] = ev.Height
+		return true
+
+	case <-w.stop:
+		return false
+	}
+}
+
+// watchManager tracks all active watchers and publishes events to them
+type watchManager struct {
+	mu       sync.RWMutex
+	watchers map[*Watcher]struct{}
+
+	logger Logger
+}
+
+func newWatchManager() *watchManager {
+	return &watchManager{
+		watchers: make(map[*Watcher]struct{}),
+		logger:   NewStdLogger(),
+	}
+}
+
+func (wm *watchManager) add(w *Watcher) {
+	w.wm = wm
+
+	wm.mu.Lock()
+	wm.watchers[w] = struct{}{}
+	wm.mu.Unlock()
+}
+
+func (wm *watchManager) remove(w *Watcher) {
+	wm.mu.Lock()
+	delete(wm.watchers, w)
+	wm.mu.Unlock()
+}
+
+// closeAll closes all watchers
+func (wm *watchManager) closeAll() {
+	wm.mu.RLock()
+	watchers := make([]*Watcher, 0, len(wm.watchers))
+	for w := range wm.watchers {
+		watchers = append(watchers, w)
+	}
+	wm.mu.RUnlock()
+
+	for _, w := range watchers {
+		w.Close()
+	}
+}
+
+// publish sends the event to all watchers with a matching prefix without
+// blocking.  Watchers whose buffer is full are closed unless they are still
+// replaying
+func (wm *watchManager) publish(ev *WatchEvent) {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+
+	for w := range wm.watchers {
+		if !bytes.HasPrefix(ev.Key, w.prefix) {
+			continue
+		}
+
+		select {
+		case w.in <- ev:
+		default:
+			if w.dropLive() {
+				continue
+			}
+			wm.logger.Warn("Closing lagging watcher", "prefix", w.prefix)
+			// Closing removes the watcher requiring a write lock
+			go w.closeWithError(errWatcherLagging)
+		}
+	}
+}
+
+// Watch returns a watcher receiving entries for keys with the given prefix
+// after they have been applied to the local FSM.  Only keys the local node is
+// a participant for are delivered.  Use a watcher on each participant or
+// History to follow other keys.  If positions is not nil, entries in the local
+// log above the given height for each key are replayed first.  Keys not in
+// positions are replayed from the start.  A nil positions only delivers new
+// entries
+func (phi *Phi) Watch(prefix []byte, positions map[string]uint32) *Watcher {
+	w := newWatcher(prefix, positions, phi.conf.WatchBuffSize)
+
+	var replay func(map[string]uint32, func(*WatchEvent) bool)
+	if positions != nil {
+		w.replaying = true
+		replay = func(positions map[string]uint32, send func(*WatchEvent) bool) {
+			phi.replay(prefix, positions, send)
+		}
+	}
+	// Register before replaying so no entries are missed
+	phi.watch.add(w)
+
+	go w.run(replay)
+
+	return w
+}
+
+// replay sends entries from the local log for keys with the prefix above the
+// given positions
+func (phi *Phi) replay(prefix []byte, positions map[string]uint32, send func(*WatchEvent) bool) {
+	err := phi.iterLocal(prefix, positions, func(key, id []byte, entry *hexalog.Entry) bool {
+		return send(&WatchEvent{Key: key, Height: uint32(entry.Height), EntryID: id, Data: EntryData(entry)})
+	})
+
+	if err != nil {
+		phi.logger.Error("Watch replay failed", "prefix", prefix, "err", err)
+	}
+}
+
+// iterLocal calls fn for each entry in the local log for keys with the prefix
+// above the given positions in height order.  Iteration stops if fn returns
+// false
+func (phi *Phi) iterLocal(prefix []byte, positions map[string]uint32, fn func(key, id []byte, entry *hexalog.Entry) bool) error {
+	keys, err := phi.lidx.keys(prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		ids, err := phi.lidx.EntryIDs(key, positions[string(key)]+1, 0)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			entry, err := phi.hexalog.Get(key, id)
+			if err != nil {
+				return err
+			}
+
+			if !fn(key, id, entry) {
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package phi
+
+import (
+	"testing"
+)
+
+func testWatchEvent(key string, height uint32) *WatchEvent {
+	return &WatchEvent{Key: []byte(key), Height: height}
+}
+
+func Test_watchManager(t *testing.T) {
+	wm := newWatchManager()
+
+	w := newWatcher([]byte("a/"), map[string]uint32{"a/1": 2}, 4)
+	wm.add(w)
+	go w.run(nil)
+
+	wm.publish(testWatchEvent("a/1", 2))
+	wm.publish(testWatchEvent("b/1", 1))
+	wm.publish(testWatchEvent("a/1", 3))
+
+	if ev := <-w.C; string(ev.Key) != "a/1" || ev.Height != 3 {
+		t.Fatal("wrong event", ev)
+	}
+
+	w.Close()
+	if _, ok := <-w.C; ok {
+		t.Fatal("channel should be closed")
+	}
+	if len(wm.watchers) != 0 {
+		t.Fatal("watcher should be removed")
+	}
+}
+
+func Test_watchManager_lagging(t *testing.T) {
+	wm := newWatchManager()
+
+	// Not running so the buffer fills up
+	w := newWatcher([]byte("a/"), nil, 1)
+	wm.add(w)
+
+	wm.publish(testWatchEvent("a/1", 1))
+	wm.publish(testWatchEvent("a/1", 2))
+
+	<-w.stop
+	if w.Err() != errWatcherLagging {
+		t.Fatal("should fail with", errWatcherLagging, w.Err())
+	}
+}
+
+func Test_watchManager_replay(t *testing.T) {
+	wm := newWatchManager()
+
+	w := newWatcher([]byte("a/"), map[string]uint32{}, 1)
+	w.replaying = true
+	wm.add(w)
+
+	// Local log.  More entries than the buffer holds are applied during the
+	// first replay
+	local := []*WatchEvent{testWatchEvent("a/1", 1), testWatchEvent("a/1", 2)}
+	replays := 0
+	replay := func(positions map[string]uint32, send func(*WatchEvent) bool) {
+		replays++
+		if replays == 1 {
+			for h := uint32(3); h <= 5; h++ {
+				ev := testWatchEvent("a/1", h)
+				local = append(local, ev)
+				wm.publish(ev)
+			}
+		}
+
+		for _, ev := range local {
+			if ev.Height > positions[string(ev.Key)] && !send(ev) {
+				return
+			}
+		}
+	}
+	go w.run(replay)
+
+	for h := uint32(1); h <= 5; h++ {
+		if ev := <-w.C; ev.Height != h {
+			t.Fatalf("height want=%d have=%d", h, ev.Height)
+		}
+	}
+	if w.Err() != nil {
+		t.Fatal("should not close a replaying watcher", w.Err())
+	}
+
+	w.Close()
+	if _, ok := <-w.C; ok {
+		t.Fatal("channel should be closed")
+	}
+}